@@ -0,0 +1,33 @@
+package ratelimiter
+
+import "time"
+
+// rateTrend measures a key's request rate over consecutive windows to detect
+// clients that are ramping up
+type rateTrend struct {
+	windowStart  time.Time
+	count        int
+	prevRate     float64
+	hasPrev      bool
+	accelerating bool
+}
+
+// observe adds a request at now and reports whether the key's rate grew
+// faster than threshold, in requests per second per second, between the last
+// two completed windows
+func (rt *rateTrend) observe(now time.Time, window time.Duration, threshold float64) bool {
+	if rt.windowStart.IsZero() {
+		rt.windowStart = now
+	}
+	if elapsed := now.Sub(rt.windowStart); elapsed >= window {
+		seconds := elapsed.Seconds()
+		current := float64(rt.count) / seconds
+		rt.accelerating = rt.hasPrev && (current-rt.prevRate)/seconds > threshold
+		rt.prevRate = current
+		rt.hasPrev = true
+		rt.windowStart = now
+		rt.count = 0
+	}
+	rt.count++
+	return rt.accelerating
+}
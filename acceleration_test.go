@@ -0,0 +1,77 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateTrendAcceleration(t *testing.T) {
+	var rt rateTrend
+	now := time.Unix(0, 0)
+	// 1, then 2, then 4 requests a second over 10 second windows
+	for _, perSecond := range []int{1, 2, 4} {
+		for range 10 * perSecond {
+			rt.observe(now, 10*time.Second, 0.1)
+			now = now.Add(time.Second / time.Duration(perSecond))
+		}
+	}
+	if !rt.observe(now, 10*time.Second, 0.1) {
+		t.Error("rate going from 2 to 4 a second over 10s not judged accelerating")
+	}
+
+	var steady rateTrend
+	for range 100 {
+		steady.observe(now, 10*time.Second, 0.1)
+		now = now.Add(500 * time.Millisecond)
+	}
+	if steady.observe(now, 10*time.Second, 0.1) {
+		t.Error("steady rate judged accelerating")
+	}
+}
+
+func TestAcceleratingClientsThrottledEarlier(t *testing.T) {
+	// firstDenial returns when a client making rate(elapsed) requests a
+	// second is first denied, or zero if it isn't within two minutes
+	firstDenial := func(threshold float64, rate func(elapsed time.Duration) float64) time.Duration {
+		clock := NewManualClock(time.Unix(0, 0))
+		rl := New(&Config{
+			RequestsPerSecond:     2,
+			Burst:                 60,
+			AccelerationThreshold: threshold,
+			AccelerationWindow:    10 * time.Second,
+			AccelerationPenalty:   4,
+			Clock:                 clock,
+		})
+		defer rl.Close()
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		var elapsed time.Duration
+		for elapsed < 2*time.Minute {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code == http.StatusTooManyRequests {
+				return elapsed
+			}
+			gap := time.Duration(float64(time.Second) / rate(elapsed))
+			clock.Advance(gap)
+			elapsed += gap
+		}
+		return 0
+	}
+
+	steadyRate := func(time.Duration) float64 { return 3 }
+	// ramping up from 1 a second by 1 every 10 seconds, under the steady
+	// client's rate for the first 20 seconds
+	rampingRate := func(elapsed time.Duration) float64 { return 1 + float64(elapsed/(10*time.Second)) }
+
+	steady, steadyUndetected := firstDenial(0.05, steadyRate), firstDenial(0, steadyRate)
+	ramping, rampingUndetected := firstDenial(0.05, rampingRate), firstDenial(0, rampingRate)
+	if steady != steadyUndetected {
+		t.Errorf("steady client first denied after %v with the detector, %v without", steady, steadyUndetected)
+	}
+	if ramping == 0 || ramping >= rampingUndetected || ramping >= steady {
+		t.Errorf("ramping client first denied after %v with the detector and %v without, the steady one after %v; want it throttled earliest with the detector",
+			ramping, rampingUndetected, steady)
+	}
+}
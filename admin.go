@@ -0,0 +1,252 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// AdminKeyState is a tracked key's state as reported by AdminHandler
+type AdminKeyState struct {
+	Key         string     `json:"key"`
+	Tokens      float64    `json:"tokens"`
+	Limit       float64    `json:"limit"`
+	Burst       int        `json:"burst"`
+	LastSeen    time.Time  `json:"last_seen"`
+	Requests    uint64     `json:"requests"`
+	Denials     uint64     `json:"denials"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+// adminLimit is the body of the admin limit endpoints
+type adminLimit struct {
+	RequestsPerSecond       float64 `json:"requests_per_second"`
+	Burst                   int     `json:"burst"`
+	GlobalRequestsPerSecond float64 `json:"global_requests_per_second,omitempty"`
+	GlobalBurst             int     `json:"global_burst,omitempty"`
+}
+
+// countDenial counts a denied request against key's visitor
+func (rl *RateLimiter) countDenial(key string) {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+	if v, exists := shard.visitors.get(key); exists {
+		v.denials++
+	}
+}
+
+// adminState describes visitor v of key at now. The caller must hold the
+// lock of the visitor's shard.
+func (rl *RateLimiter) adminState(key string, v *visitor, now time.Time) AdminKeyState {
+	state := AdminKeyState{Key: key, LastSeen: v.lastSeen.Load(), Requests: v.requests.Load(), Denials: v.denials}
+	if v.limiter != nil {
+		res := rl.bucketState(v, now)
+		state.Tokens, state.Limit, state.Burst = res.Remaining, float64(res.Limit), res.Burst
+	}
+	return state
+}
+
+// resetKey forgets key's buckets, quota usage and denial history and lifts
+// any ban on it
+func (rl *RateLimiter) resetKey(key string) {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	if v, exists := shard.visitors.get(key); exists {
+		shard.evict(key, v, EvictReset)
+	}
+	shard.mx.Unlock()
+	rl.bans.unban(key)
+	if rl.quotas != nil {
+		rl.quotas.reset(key)
+	}
+	rl.limits.reset(key)
+	if rl.offenders != nil {
+		rl.offenders.reset(key)
+	}
+}
+
+// AdminHandler serves a small JSON API for inspecting and controlling the
+// limiter at runtime. Every request must pass Config.AdminAuth. Keys are
+// the stored keys the visitors endpoint lists, passed as the key query
+// parameter:
+//
+//	GET    /visitors?limit=20          keys with the most denials
+//	GET    /key?key=K                  a key's tokens, limit and last seen
+//	POST   /reset?key=K                forget a key's bucket, quotas and ban
+//	POST   /ban?key=K&duration=10m     ban a key, for BanDuration by default
+//	DELETE /ban?key=K                  lift a ban
+//	GET    /limit                      the top-level rate and burst
+//	PUT    /limit                      change them, see SetLimit
+//
+// The limit endpoints also cover the global limit when one is configured.
+//
+// Mount it under a prefix with http.StripPrefix, away from public traffic.
+func (rl *RateLimiter) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /visitors", rl.adminVisitors)
+	mux.HandleFunc("GET /key", rl.adminKey)
+	mux.HandleFunc("POST /reset", rl.adminReset)
+	mux.HandleFunc("POST /ban", rl.adminBan)
+	mux.HandleFunc("DELETE /ban", rl.adminUnban)
+	mux.HandleFunc("GET /limit", rl.adminGetLimit)
+	mux.HandleFunc("PUT /limit", rl.adminSetLimit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl.config.AdminAuth == nil || !rl.config.AdminAuth(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON sends v as the JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminKeyParam returns the key query parameter, answering 400 if it's missing
+func adminKeyParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key parameter", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+func (rl *RateLimiter) adminVisitors(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	now := rl.now()
+	states := make([]AdminKeyState, 0)
+	rl.memory.each(func(key string, v *visitor) {
+		if v.denials > 0 {
+			states = append(states, rl.adminState(key, v, now))
+		}
+	})
+	slices.SortFunc(states, func(a, b AdminKeyState) int {
+		if a.Denials != b.Denials {
+			if a.Denials > b.Denials {
+				return -1
+			}
+			return 1
+		}
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	if len(states) > limit {
+		states = states[:limit]
+	}
+	writeJSON(w, states)
+}
+
+func (rl *RateLimiter) adminKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := adminKeyParam(w, r)
+	if !ok {
+		return
+	}
+	now := rl.now()
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	v, exists := shard.visitors.get(key)
+	var state AdminKeyState
+	if exists {
+		state = rl.adminState(key, v, now)
+	}
+	shard.mx.Unlock()
+
+	banned := rl.bans.banned(key, now)
+	if !exists && banned == 0 {
+		http.Error(w, "unknown key", http.StatusNotFound)
+		return
+	}
+	state.Key = key
+	if banned > 0 {
+		until := now.Add(banned)
+		state.BannedUntil = &until
+	}
+	writeJSON(w, state)
+}
+
+func (rl *RateLimiter) adminReset(w http.ResponseWriter, r *http.Request) {
+	key, ok := adminKeyParam(w, r)
+	if !ok {
+		return
+	}
+	rl.resetKey(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rl *RateLimiter) adminBan(w http.ResponseWriter, r *http.Request) {
+	key, ok := adminKeyParam(w, r)
+	if !ok {
+		return
+	}
+	duration := rl.config.BanDuration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid duration parameter", http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+	rl.bans.ban(key, rl.now().Add(duration))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rl *RateLimiter) adminUnban(w http.ResponseWriter, r *http.Request) {
+	key, ok := adminKeyParam(w, r)
+	if !ok {
+		return
+	}
+	rl.bans.unban(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rl *RateLimiter) adminGetLimit(w http.ResponseWriter, r *http.Request) {
+	limit, burst := rl.defaultLimit()
+	body := adminLimit{RequestsPerSecond: float64(limit), Burst: burst}
+	if rl.global != nil {
+		body.GlobalRequestsPerSecond, body.GlobalBurst = float64(rl.global.Limit()), rl.global.Burst()
+	}
+	writeJSON(w, body)
+}
+
+func (rl *RateLimiter) adminSetLimit(w http.ResponseWriter, r *http.Request) {
+	var body adminLimit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if body.RequestsPerSecond < 0 || body.Burst < 0 || body.GlobalRequestsPerSecond < 0 || body.GlobalBurst < 0 {
+		http.Error(w, "rates and bursts must not be negative", http.StatusBadRequest)
+		return
+	}
+	if rl.global == nil && (body.GlobalRequestsPerSecond > 0 || body.GlobalBurst > 0) {
+		http.Error(w, "no global limit is configured", http.StatusBadRequest)
+		return
+	}
+	rl.SetLimit(body.RequestsPerSecond, body.Burst)
+	if body.GlobalRequestsPerSecond > 0 {
+		rl.global.SetLimitAt(rl.now(), rate.Limit(body.GlobalRequestsPerSecond))
+	}
+	if body.GlobalBurst > 0 {
+		rl.global.SetBurstAt(rl.now(), body.GlobalBurst)
+	}
+	rl.adminGetLimit(w, r)
+}
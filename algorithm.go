@@ -0,0 +1,215 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Algorithm selects how requests are counted against a key's limit
+type Algorithm int
+
+const (
+	// AlgorithmTokenBucket refills tokens continuously at RequestsPerSecond
+	// and allows bursts of up to Burst requests
+	AlgorithmTokenBucket Algorithm = iota
+	// AlgorithmFixedWindow allows Burst requests per Window, with windows
+	// aligned to the clock. A client may send twice that around a boundary.
+	AlgorithmFixedWindow
+	// AlgorithmSlidingWindowLog allows Burst requests in any Window long
+	// period by remembering the time of every admitted request. It is exact
+	// but uses memory proportional to Burst per key.
+	AlgorithmSlidingWindowLog
+	// AlgorithmSlidingWindowCounter approximates the sliding log by weighting
+	// the previous fixed window's count by how much of it still overlaps the
+	// sliding window, using constant memory per key
+	AlgorithmSlidingWindowCounter
+	// AlgorithmGCRA is the generic cell rate algorithm, a leaky bucket that
+	// admits the same requests as the token bucket at RequestsPerSecond and
+	// Burst but keeps only a theoretical arrival time per key. Retry-after
+	// values are exact, and shared stores implementing GCRAStore need a
+	// single read and write per request.
+	AlgorithmGCRA
+)
+
+// MarshalText encodes the algorithm as "token_bucket", "fixed_window",
+// "sliding_window_log", "sliding_window_counter" or "gcra"
+func (a Algorithm) MarshalText() ([]byte, error) {
+	switch a {
+	case AlgorithmTokenBucket:
+		return []byte("token_bucket"), nil
+	case AlgorithmFixedWindow:
+		return []byte("fixed_window"), nil
+	case AlgorithmSlidingWindowLog:
+		return []byte("sliding_window_log"), nil
+	case AlgorithmSlidingWindowCounter:
+		return []byte("sliding_window_counter"), nil
+	case AlgorithmGCRA:
+		return []byte("gcra"), nil
+	}
+	return nil, fmt.Errorf("ratelimiter: unknown algorithm %d", int(a))
+}
+
+// UnmarshalText decodes the names written by MarshalText so algorithms can
+// be chosen by name in config files
+func (a *Algorithm) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "token_bucket":
+		*a = AlgorithmTokenBucket
+	case "fixed_window":
+		*a = AlgorithmFixedWindow
+	case "sliding_window_log":
+		*a = AlgorithmSlidingWindowLog
+	case "sliding_window_counter":
+		*a = AlgorithmSlidingWindowCounter
+	case "gcra":
+		*a = AlgorithmGCRA
+	default:
+		return fmt.Errorf("ratelimiter: unknown algorithm %q", text)
+	}
+	return nil
+}
+
+// windowState counts a visitor's requests for the window based algorithms
+type windowState struct {
+	algorithm Algorithm
+	length    time.Duration
+	start     time.Time   // start of the current fixed window
+	count     int         // requests admitted in the current fixed window
+	prev      int         // requests admitted in the previous fixed window
+	log       []time.Time // admission times, oldest first, for the sliding log
+}
+
+// advance rolls the state forward to now
+func (ws *windowState) advance(now time.Time) {
+	if ws.algorithm == AlgorithmSlidingWindowLog {
+		cutoff := now.Add(-ws.length)
+		i := 0
+		for i < len(ws.log) && !ws.log[i].After(cutoff) {
+			i++
+		}
+		ws.log = ws.log[i:]
+		return
+	}
+	start := now.Truncate(ws.length)
+	if start.Equal(ws.start) {
+		return
+	}
+	if start.Sub(ws.start) == ws.length {
+		ws.prev = ws.count
+	} else {
+		ws.prev = 0
+	}
+	ws.start, ws.count = start, 0
+}
+
+// used returns the requests counted against the window ending at now
+func (ws *windowState) used(now time.Time) float64 {
+	switch ws.algorithm {
+	case AlgorithmSlidingWindowLog:
+		return float64(len(ws.log))
+	case AlgorithmSlidingWindowCounter:
+		overlap := 1 - float64(now.Sub(ws.start))/float64(ws.length)
+		return float64(ws.prev)*overlap + float64(ws.count)
+	}
+	return float64(ws.count)
+}
+
+// wait returns how long until n more requests fit within limit
+func (ws *windowState) wait(now time.Time, n, limit int) time.Duration {
+	if n > limit {
+		return time.Duration(math.MaxInt64)
+	}
+	switch ws.algorithm {
+	case AlgorithmSlidingWindowLog:
+		// The oldest entries have to leave the window first
+		oldest := ws.log[len(ws.log)+n-limit-1]
+		return oldest.Add(ws.length).Sub(now)
+	case AlgorithmSlidingWindowCounter:
+		if ws.count+n <= limit {
+			// Wait for the previous window's weight to shrink enough
+			frac := 1 - float64(limit-n-ws.count)/float64(ws.prev)
+			return ws.start.Add(time.Duration(frac * float64(ws.length))).Sub(now)
+		}
+		// The current window becomes the previous one first
+		frac := 1 - float64(limit-n)/float64(ws.count)
+		return ws.start.Add(ws.length + time.Duration(frac*float64(ws.length))).Sub(now)
+	}
+	return ws.start.Add(ws.length).Sub(now)
+}
+
+// allow checks whether n more requests fit within limit, counting them if
+// they do and take is set
+func (ws *windowState) allow(now time.Time, n, limit int, take bool) Result {
+	ws.advance(now)
+	used := ws.used(now)
+	res := Result{
+		Allowed: used+float64(n) <= float64(limit),
+		Limit:   rate.Limit(float64(limit) / ws.length.Seconds()),
+		Burst:   limit,
+	}
+	switch {
+	case !res.Allowed:
+		res.RetryAfter = max(0, ws.wait(now, n, limit))
+	case take && ws.algorithm == AlgorithmSlidingWindowLog:
+		for range n {
+			ws.log = append(ws.log, now)
+		}
+		used += float64(n)
+	case take:
+		ws.count += n
+		used += float64(n)
+	}
+	res.Remaining = max(0, float64(limit)-used)
+	return res
+}
+
+// active reports whether the state still affects future decisions, in which
+// case the visitor must outlive MaxIdleTime or its count would reset early
+func (ws *windowState) active(now time.Time) bool {
+	switch ws.algorithm {
+	case AlgorithmSlidingWindowLog:
+		return len(ws.log) > 0 && now.Before(ws.log[len(ws.log)-1].Add(ws.length))
+	case AlgorithmSlidingWindowCounter:
+		return now.Before(ws.start.Add(2 * ws.length))
+	}
+	return now.Before(ws.start.Add(ws.length))
+}
+
+// windowed reports whether a window based algorithm is in use
+func (rl *RateLimiter) windowed() bool {
+	return rl.config.Algorithm != AlgorithmTokenBucket && rl.config.Algorithm != AlgorithmGCRA
+}
+
+// bucketState returns the state of visitor v's bucket at now without
+// taking from it. The caller must hold the lock of the visitor's shard.
+func (rl *RateLimiter) bucketState(v *visitor, now time.Time) Result {
+	switch {
+	case v.window != nil:
+		return v.window.allow(now, 0, v.limiter.Burst(), false)
+	case rl.gcra():
+		_, res := GCRA(v.tat, now, v.limiter.Limit(), v.limiter.Burst(), 0)
+		return res
+	}
+	return limiterResult(v.limiter, now, 1, true)
+}
+
+// takeWindow checks n requests for key against limit requests per Window,
+// counting them if they fit and take is set
+func (rl *RateLimiter) takeWindow(key string, limit, n int, now time.Time, take bool) Result {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(nil, now)
+		shard.add(key, v)
+	}
+	if v.window == nil {
+		v.window = &windowState{algorithm: rl.config.Algorithm, length: rl.config.Window}
+	}
+	return v.window.allow(now, n, limit, take)
+}
@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// banTracker counts violations per key and holds the keys currently banned.
+// Bans apply to a client as a whole rather than to one of its buckets, so
+// they are kept apart from the visitors.
+type banTracker struct {
+	mx         sync.Mutex
+	bans       map[string]time.Time   // key -> end of ban
+	violations map[string][]time.Time // key -> recent denials, oldest first
+	size       atomic.Int64           // len(bans), read without the lock
+}
+
+func newBanTracker() *banTracker {
+	return &banTracker{bans: make(map[string]time.Time), violations: make(map[string][]time.Time)}
+}
+
+// banned returns how much longer key is banned for, zero if it isn't
+func (bt *banTracker) banned(key string, now time.Time) time.Duration {
+	if bt.size.Load() == 0 {
+		return 0
+	}
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+	return max(0, bt.bans[key].Sub(now))
+}
+
+// ban bans key until the given time
+func (bt *banTracker) ban(key string, until time.Time) {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+	bt.bans[key] = until
+	delete(bt.violations, key)
+	bt.size.Store(int64(len(bt.bans)))
+}
+
+// unban lifts key's ban and forgets its violations
+func (bt *banTracker) unban(key string) {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+	delete(bt.bans, key)
+	delete(bt.violations, key)
+	bt.size.Store(int64(len(bt.bans)))
+}
+
+// violate records a denial for key and reports whether key has now been
+// denied threshold times within window
+func (bt *banTracker) violate(key string, now time.Time, threshold int, window time.Duration) bool {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+
+	times := append(bt.violations[key], now)
+	cutoff := now.Add(-window)
+	for len(times) > 0 && !times[0].After(cutoff) {
+		times = times[1:]
+	}
+	if len(times) > threshold {
+		times = times[len(times)-threshold:]
+	}
+	bt.violations[key] = times
+	return len(times) >= threshold
+}
+
+// expire forgets ended bans and violations older than window
+func (bt *banTracker) expire(now time.Time, window time.Duration) {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+
+	for key, until := range bt.bans {
+		if !now.Before(until) {
+			delete(bt.bans, key)
+		}
+	}
+	cutoff := now.Add(-window)
+	for key, times := range bt.violations {
+		if !times[len(times)-1].After(cutoff) {
+			delete(bt.violations, key)
+		}
+	}
+	bt.size.Store(int64(len(bt.bans)))
+}
+
+// active returns the bans still running at now, by key
+func (bt *banTracker) active(now time.Time) map[string]time.Time {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+
+	bans := make(map[string]time.Time, len(bt.bans))
+	for key, until := range bt.bans {
+		if now.Before(until) {
+			bans[key] = until
+		}
+	}
+	return bans
+}
+
+// Ban rejects every request from identity for duration, whatever its
+// remaining tokens. identity is a KeyFunc result or client IP, mapped
+// through KeySecret and HashBuckets like the middleware's keys.
+func (rl *RateLimiter) Ban(identity string, duration time.Duration) {
+	rl.bans.ban(rl.storageKey(identity), rl.now().Add(duration))
+}
+
+// Unban lifts a ban on identity, whether set by Ban or by BanThreshold, and
+// clears its violation count
+func (rl *RateLimiter) Unban(identity string) {
+	rl.bans.unban(rl.storageKey(identity))
+}
+
+// recordViolation counts a denial for key, banning it once it has been
+// denied BanThreshold times within BanWindow
+func (rl *RateLimiter) recordViolation(key string, now time.Time) {
+	if rl.config.BanThreshold == 0 {
+		return
+	}
+	if rl.bans.violate(key, now, rl.config.BanThreshold, rl.config.BanWindow) {
+		until := now.Add(rl.config.BanDuration)
+		rl.bans.ban(key, until)
+		rl.logBan(key, until)
+	}
+}
+
+// denyBanned answers a request from a banned key with BanStatus
+func (rl *RateLimiter) denyBanned(w http.ResponseWriter, r *http.Request, remaining time.Duration) {
+	rl.denyHeaders(w, rl.requestID(r))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(rl.padWait(remaining))))
+	http.Error(w, http.StatusText(rl.config.BanStatus), rl.config.BanStatus)
+}
@@ -0,0 +1,55 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// boost holds the limit a visitor returns to once a temporary boost expires
+type boost struct {
+	until       time.Time
+	revertLimit rate.Limit
+	revertBurst int
+}
+
+// BoostKey temporarily raises the limit for a client identity to rps and
+// burst. After duration the visitor reverts to the limit it had before the
+// first boost. Boosting an already boosted visitor replaces the elevated
+// limit and restarts the timer. Boosted visitors are kept by cleanup until
+// the boost expires.
+func (rl *RateLimiter) BoostKey(identity string, rps float64, burst int, duration time.Duration) {
+	key := rl.storageKey(identity)
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	now := rl.now()
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(rate.NewLimiter(rl.defaultLimit()), now)
+		shard.add(key, v)
+	}
+	if v.limiter == nil {
+		v.limiter = rate.NewLimiter(rl.defaultLimit())
+	}
+	if v.boost == nil {
+		v.boost = &boost{revertLimit: v.limiter.Limit(), revertBurst: v.limiter.Burst()}
+		v.boosted.Store(true)
+	}
+	v.boost.until = now.Add(duration)
+	v.limiter.SetLimitAt(now, rate.Limit(rps))
+	v.limiter.SetBurstAt(now, burst)
+}
+
+// expireBoost reverts the visitor's limit if its boost has run out.
+// The caller must hold the lock of the visitor's shard.
+func (v *visitor) expireBoost(now time.Time) {
+	if v.boost == nil || now.Before(v.boost.until) {
+		return
+	}
+	v.limiter.SetLimitAt(now, v.boost.revertLimit)
+	v.limiter.SetBurstAt(now, v.boost.revertBurst)
+	v.boost = nil
+	v.boosted.Store(false)
+}
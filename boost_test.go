@@ -0,0 +1,53 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoostKeyExpires(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 1, Burst: 1, Clock: clock})
+	defer rl.Close()
+
+	rl.BoostKey("a", 10, 10, time.Minute)
+	clock.Advance(time.Second)
+	for i := range 11 {
+		if got := rl.Allow("a"); got != (i < 10) {
+			t.Fatalf("boosted request %d: allowed = %v", i, got)
+		}
+	}
+	if info, _ := rl.VisitorInfo("a"); info.Limit != 10 {
+		t.Errorf("limit %v during the boost, want 10", info.Limit)
+	}
+
+	clock.Advance(time.Minute)
+	for i := range 2 {
+		if got := rl.Allow("a"); got != (i == 0) {
+			t.Fatalf("request %d after the boost: allowed = %v", i, got)
+		}
+	}
+	if info, _ := rl.VisitorInfo("a"); info.Limit != 1 {
+		t.Errorf("limit %v after the boost, want 1", info.Limit)
+	}
+	clock.Advance(time.Second)
+	if !rl.Allow("a") {
+		t.Error("reverted bucket didn't refill after a second of the fake clock")
+	}
+}
+
+func TestBoostKeyRevertsOnTheLimiterClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 1, Burst: 1, Clock: clock})
+	defer rl.Close()
+
+	// a slow boost: spent at the start, it has refilled less than a token
+	// when it expires
+	rl.BoostKey("a", 0.01, 10, time.Minute)
+	for rl.Allow("a") {
+	}
+	clock.Advance(time.Minute)
+	if rl.Allow("a") {
+		t.Error("reverting the boost refilled the bucket; want tokens as of the fake clock")
+	}
+}
@@ -0,0 +1,155 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the limiter the time. The default is the system clock; tests
+// can supply a ManualClock to advance time without sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks like a time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t realTicker) Stop()               { t.ticker.Stop() }
+
+// ManualClock is a Clock that only moves when told to, for deterministic
+// tests of code using the limiter
+type ManualClock struct {
+	mx      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManualClock creates a ManualClock set to start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the clock's current time
+func (mc *ManualClock) Now() time.Time {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+	return mc.now
+}
+
+// NewTicker returns a Ticker that fires as Advance moves the clock past each
+// period. Like time.Ticker, ticks are dropped if the receiver falls behind.
+func (mc *ManualClock) NewTicker(d time.Duration) Ticker {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+	t := &manualTicker{c: make(chan time.Time, 1), period: d, next: mc.now.Add(d)}
+	mc.tickers = append(mc.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing any tickers that come due
+func (mc *ManualClock) Advance(d time.Duration) {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+	mc.now = mc.now.Add(d)
+	for _, t := range mc.tickers {
+		t.fire(mc.now)
+	}
+}
+
+type manualTicker struct {
+	mx      sync.Mutex
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+func (t *manualTicker) Stop() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.stopped = true
+}
+
+// fire sends a tick if now has reached the next one
+func (t *manualTicker) fire(now time.Time) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.stopped || now.Before(t.next) {
+		return
+	}
+	for !now.Before(t.next) {
+		t.next = t.next.Add(t.period)
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// now returns the current time according to the configured Clock
+func (rl *RateLimiter) now() time.Time {
+	return rl.config.Clock.Now()
+}
+
+// observeStoreTime records how far the shared store's clock is ahead of
+// ours, from the time it reported with a decision. The estimate is off by
+// up to the round trip the decision took.
+func (rl *RateLimiter) observeStoreTime(at time.Time) {
+	if !at.IsZero() {
+		rl.skew.Store(int64(at.Sub(rl.now())))
+	}
+}
+
+// storeNow converts now to the shared store's clock, so every instance
+// using the store agrees on times such as when quota periods start. It is
+// now itself until a store has reported its time.
+func (rl *RateLimiter) storeNow(now time.Time) time.Time {
+	return now.Add(time.Duration(rl.skew.Load()))
+}
+
+// atomicTime is a time.Time that can be loaded and stored concurrently. It
+// keeps the wall time to the nanosecond, dropping the location and the
+// monotonic reading.
+type atomicTime struct {
+	// the Unix time in nanoseconds with its sign bit flipped, so the zero
+	// value stands for the zero time rather than the Unix epoch
+	ns atomic.Int64
+}
+
+func (t *atomicTime) Load() time.Time {
+	ns := t.ns.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns^math.MinInt64)
+}
+
+func (t *atomicTime) Store(at time.Time) {
+	if at.IsZero() {
+		t.ns.Store(0)
+		return
+	}
+	t.ns.Store(at.UnixNano() ^ math.MinInt64)
+}
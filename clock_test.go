@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// timedStore is a Store that admits every request and reports its own
+// clock, running offset ahead of clock
+type timedStore struct {
+	clock  Clock
+	offset time.Duration
+}
+
+func (s *timedStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	return Result{Allowed: true, Remaining: float64(burst - n), Limit: limit, Burst: burst, At: s.clock.Now().Add(s.offset)}, nil
+}
+
+func (s *timedStore) Get(ctx context.Context, key string) (Result, bool, error) {
+	return Result{}, false, nil
+}
+
+func (s *timedStore) Touch(ctx context.Context, key string) error { return nil }
+
+func (s *timedStore) Cleanup(ctx context.Context, maxIdle time.Duration) error { return nil }
+
+func TestQuotaPeriodsFollowStoreClock(t *testing.T) {
+	// our clock is 30s before the hour, the store's 30s past it
+	clock := NewManualClock(time.Unix(3600-30, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 100,
+		Burst:             100,
+		Quotas:            []Quota{{Limit: 1, Period: time.Hour}},
+		Store:             &timedStore{clock: clock, offset: time.Minute},
+		RetryAfterMargin:  2 * time.Second,
+		Clock:             clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	// the first reply tells us the store's time
+	if w := serve(); w.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", w.Code)
+	}
+	if got := rl.storeNow(clock.Now()); !got.Equal(time.Unix(3600+30, 0)) {
+		t.Fatalf("storeNow = %v, want the store's time", got.Unix())
+	}
+
+	// by the store's clock a new hour has begun, with a fresh quota
+	w := serve()
+	if w.Code != http.StatusOK {
+		t.Fatalf("second request: got %d, want a fresh quota in the store's hour", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Quota-Reset"); got != "7202" {
+		t.Errorf("X-RateLimit-Quota-Reset = %q, want the end of the store's hour plus the margin", got)
+	}
+
+	w = serve()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "3572" {
+		t.Errorf("Retry-After = %q, want 3570s to the store's next hour plus the 2s margin", got)
+	}
+}
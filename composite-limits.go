@@ -0,0 +1,229 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limit is one constraint of a composite policy: Requests per Period,
+// refilling continuously like the main rate. A key with several limits,
+// e.g. 1000 per hour and 5000 per day, must pass all of them.
+type Limit struct {
+	// Name identifies the constraint in the X-RateLimit-Constraint header and
+	// LimitInfo. Defaults to the limit itself, such as "1000/1h".
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Requests is the number of requests allowed per Period
+	Requests int `json:"requests" yaml:"requests" toml:"requests"`
+	// Period is the time over which Requests are allowed
+	Period time.Duration `json:"period" yaml:"period" toml:"period"`
+	// Burst is the most requests allowed at once (default: Requests)
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// UnmarshalJSON decodes a limit whose period is given either in nanoseconds
+// or as a duration string like "1h", so config files can write the latter
+func (l *Limit) UnmarshalJSON(data []byte) error {
+	type plain Limit
+	var raw struct {
+		plain
+		Period json.RawMessage `json:"period"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*l = Limit(raw.plain)
+	return unmarshalDuration(raw.Period, &l.Period)
+}
+
+// rate returns the limit as tokens per second
+func (l Limit) rate() rate.Limit {
+	return rate.Limit(float64(l.Requests) / l.Period.Seconds())
+}
+
+// normalizeLimits drops limits without requests or period and fills in
+// default bursts and names
+func normalizeLimits(limits []Limit) []Limit {
+	var valid []Limit
+	for _, l := range limits {
+		if l.Requests <= 0 || l.Period <= 0 {
+			continue
+		}
+		if l.Burst <= 0 {
+			l.Burst = l.Requests
+		}
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("%d/%s", l.Requests, shortDuration(l.Period))
+		}
+		valid = append(valid, l)
+	}
+	return valid
+}
+
+// shortDuration formats d without trailing zero units, e.g. "24h" or "1m"
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// keyLimits is a key's buckets for its composite limits
+type keyLimits struct {
+	limits  []Limit
+	buckets []*rate.Limiter // one per limit
+}
+
+// limitTracker holds the buckets of the composite limits. Like quotas, the
+// limits apply to a client as a whole and may span longer than MaxIdleTime,
+// so they are kept apart from the visitors.
+type limitTracker struct {
+	limits    []Limit
+	mx        sync.Mutex
+	keys      map[string]*keyLimits
+	overrides map[string][]Limit // set by SetLimitsFor
+	custom    atomic.Int64       // len(overrides), read without the lock
+}
+
+func newLimitTracker(limits []Limit) *limitTracker {
+	return &limitTracker{limits: limits, keys: make(map[string]*keyLimits), overrides: make(map[string][]Limit)}
+}
+
+// newKeyLimits returns full buckets for limits at now. Buckets of old with
+// the same name are carried over with their tokens.
+func newKeyLimits(limits []Limit, old *keyLimits, now time.Time) *keyLimits {
+	state := &keyLimits{limits: limits, buckets: make([]*rate.Limiter, len(limits))}
+	for i, l := range limits {
+		if old != nil {
+			for j, prev := range old.limits {
+				if prev.Name == l.Name {
+					state.buckets[i] = old.buckets[j]
+					state.buckets[i].SetLimitAt(now, l.rate())
+					state.buckets[i].SetBurstAt(now, l.Burst)
+				}
+			}
+		}
+		if state.buckets[i] == nil {
+			state.buckets[i] = rate.NewLimiter(l.rate(), l.Burst)
+		}
+	}
+	return state
+}
+
+// reserve takes n tokens for key from every bucket if all of them hold
+// enough, so a limit that denies the request doesn't drain the others.
+// Otherwise it reports the limit that would make the request wait longest.
+func (lt *limitTracker) reserve(key string, n int, now time.Time) (grants []tokenGrant, failed *Limit, wait time.Duration, ok bool) {
+	if len(lt.limits) == 0 && lt.custom.Load() == 0 {
+		return nil, nil, 0, true
+	}
+	lt.mx.Lock()
+	defer lt.mx.Unlock()
+
+	state := lt.keys[key]
+	if state == nil {
+		limits, custom := lt.overrides[key]
+		if !custom {
+			limits = lt.limits
+		}
+		if len(limits) == 0 {
+			return nil, nil, 0, true
+		}
+		state = newKeyLimits(limits, nil, now)
+		lt.keys[key] = state
+	}
+	for i, b := range state.buckets {
+		if n > b.Burst() {
+			return nil, &state.limits[i], time.Duration(math.MaxInt64), false
+		}
+		if w := tokenWait(b.TokensAt(now), b.Limit(), n); w > wait {
+			failed, wait = &state.limits[i], w
+		}
+	}
+	if failed != nil {
+		return nil, failed, wait, false
+	}
+	grants = make([]tokenGrant, len(state.buckets))
+	for i, b := range state.buckets {
+		b.AllowN(now, n)
+		grants[i] = tokenGrant{limiter: b, n: n}
+	}
+	return grants, nil, 0, true
+}
+
+// set replaces key's limits, or restores the configured ones if limits is
+// nil, keeping the tokens of limits with unchanged names
+func (lt *limitTracker) set(key string, limits []Limit, now time.Time) {
+	lt.mx.Lock()
+	defer lt.mx.Unlock()
+
+	if limits == nil {
+		delete(lt.overrides, key)
+		limits = lt.limits
+	} else {
+		lt.overrides[key] = limits
+	}
+	lt.custom.Store(int64(len(lt.overrides)))
+	if old := lt.keys[key]; old != nil {
+		lt.keys[key] = newKeyLimits(limits, old, now)
+	}
+}
+
+// reset forgets key's buckets
+func (lt *limitTracker) reset(key string) {
+	lt.mx.Lock()
+	defer lt.mx.Unlock()
+	delete(lt.keys, key)
+}
+
+// expire forgets keys whose buckets have all refilled, which are no
+// different from new ones
+func (lt *limitTracker) expire(now time.Time) {
+	lt.mx.Lock()
+	defer lt.mx.Unlock()
+
+	for key, state := range lt.keys {
+		full := true
+		for _, b := range state.buckets {
+			if b.TokensAt(now) < float64(b.Burst()) {
+				full = false
+				break
+			}
+		}
+		if full {
+			delete(lt.keys, key)
+		}
+	}
+}
+
+// releaseLimits gives back tokens taken by limitTracker.reserve or
+// reserveDimensions when a later check denies the request after all, or its
+// response is refunded
+func releaseLimits(grants []tokenGrant, at time.Time) {
+	for _, g := range grants {
+		g.release(at)
+	}
+}
+
+// SetLimitsFor gives a client identity its own composite limits in place of
+// Config.Limits, keeping the tokens of limits whose names are unchanged.
+// Calling it without valid limits restores the configured ones. Unlike
+// SetLimitFor, the override lasts until it is removed this way.
+func (rl *RateLimiter) SetLimitsFor(identity string, limits ...Limit) {
+	rl.limits.set(rl.storageKey(identity), normalizeLimits(limits), rl.now())
+}
+
+// compositeLimitInfo describes a request denied by limit
+func compositeLimitInfo(identity string, limit *Limit, wait time.Duration) LimitInfo {
+	return LimitInfo{Key: identity, Limit: limit.rate(), Burst: limit.Burst, RetryAfter: wait, Constraint: limit.Name}
+}
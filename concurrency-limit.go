@@ -0,0 +1,188 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrTooManyInFlight is returned by ConcurrencyLimiter.Acquire when a key
+// already has MaxInFlight requests running and no queue slot is free, or its
+// queue timeout ran out
+var ErrTooManyInFlight = errors.New("ratelimiter: too many concurrent requests")
+
+// ConcurrencyConfig holds the configuration for a ConcurrencyLimiter
+type ConcurrencyConfig struct {
+	// MaxInFlight is the number of requests a key may have running at once
+	MaxInFlight int `json:"max_in_flight" yaml:"max_in_flight" toml:"max_in_flight"`
+	// MaxQueue is the number of further requests per key that wait for a
+	// running one to finish. Zero rejects them straight away.
+	MaxQueue int `json:"max_queue" yaml:"max_queue" toml:"max_queue"`
+	// QueueTimeout is the longest a request waits in the queue (default: 1 second)
+	QueueTimeout time.Duration `json:"queue_timeout" yaml:"queue_timeout" toml:"queue_timeout"`
+	// KeyFunc extracts the key requests are limited by; defaults to the peer
+	// address, or the client behind it when the peer is a trusted proxy
+	KeyFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// TrustedProxies lists the CIDRs (or single IPs) of proxies whose
+	// X-Forwarded-For and X-Real-IP headers the default KeyFunc honours, as
+	// in Config.TrustedProxies. Without it forwarding headers are ignored.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// Validate ensures the configuration has valid values
+func (c *ConcurrencyConfig) Validate() {
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = 10
+	}
+	if c.MaxQueue < 0 {
+		c.MaxQueue = 0
+	}
+	if c.QueueTimeout <= 0 {
+		c.QueueTimeout = time.Second
+	}
+	if c.KeyFunc == nil {
+		c.KeyFunc = newIPList(c.TrustedProxies).peerIP
+	}
+}
+
+// ConcurrencyLimiter caps the number of requests each key has in flight at
+// once. It complements RateLimiter: a rate alone doesn't stop one client from
+// tying up a slow endpoint with many long-running requests.
+type ConcurrencyLimiter struct {
+	config *ConcurrencyConfig
+
+	mx   sync.Mutex
+	keys map[string]*inFlight
+}
+
+// inFlight is a key's running and queued requests. Keys are forgotten as
+// soon as they have neither, so no cleanup is needed.
+type inFlight struct {
+	running int
+	queue   []chan struct{} // waiters, oldest first; closed when handed a slot
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter with the given configuration
+func NewConcurrencyLimiter(cfg *ConcurrencyConfig) *ConcurrencyLimiter {
+	if cfg == nil {
+		cfg = &ConcurrencyConfig{}
+	}
+	cfg.Validate()
+	return &ConcurrencyLimiter{config: cfg, keys: make(map[string]*inFlight)}
+}
+
+// Acquire takes one of key's slots, waiting in its queue if all are in use.
+// The returned function gives the slot back and must be called exactly once.
+// Acquire fails with ErrTooManyInFlight if the queue is full or the wait
+// times out, and with the context's error if ctx is done first.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, key string) (release func(), err error) {
+	cl.mx.Lock()
+	state := cl.keys[key]
+	if state == nil {
+		state = &inFlight{}
+		cl.keys[key] = state
+	}
+	if state.running < cl.config.MaxInFlight {
+		state.running++
+		cl.mx.Unlock()
+		return cl.releaser(key), nil
+	}
+	if len(state.queue) >= cl.config.MaxQueue {
+		cl.mx.Unlock()
+		return nil, ErrTooManyInFlight
+	}
+	ready := make(chan struct{})
+	state.queue = append(state.queue, ready)
+	cl.mx.Unlock()
+
+	timer := time.NewTimer(cl.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return cl.releaser(key), nil
+	case <-timer.C:
+		err = ErrTooManyInFlight
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cl.mx.Lock()
+	defer cl.mx.Unlock()
+	select {
+	case <-ready:
+		// Handed a slot while giving up; pass it on
+		cl.releaseLocked(key)
+	default:
+		for i, ch := range state.queue {
+			if ch == ready {
+				state.queue = append(state.queue[:i], state.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil, err
+}
+
+// releaser returns a function releasing one of key's slots at most once
+func (cl *ConcurrencyLimiter) releaser(key string) func() {
+	var once sync.Once
+	return func() { once.Do(func() { cl.release(key) }) }
+}
+
+// release hands key's slot to the oldest waiter, or frees it
+func (cl *ConcurrencyLimiter) release(key string) {
+	cl.mx.Lock()
+	defer cl.mx.Unlock()
+	cl.releaseLocked(key)
+}
+
+// releaseLocked is release for callers holding cl.mx
+func (cl *ConcurrencyLimiter) releaseLocked(key string) {
+	state := cl.keys[key]
+	if state == nil {
+		return
+	}
+	if len(state.queue) > 0 {
+		close(state.queue[0])
+		state.queue = state.queue[1:]
+		return
+	}
+	state.running--
+	if state.running <= 0 {
+		delete(cl.keys, key)
+	}
+}
+
+// InFlight returns the number of requests key has running
+func (cl *ConcurrencyLimiter) InFlight(key string) int {
+	cl.mx.Lock()
+	defer cl.mx.Unlock()
+	if state := cl.keys[key]; state != nil {
+		return state.running
+	}
+	return 0
+}
+
+// Middleware limits the requests each key has in flight. Requests over the
+// limit, once any queue wait has failed, get 429 Too Many Requests. It can be
+// chained with RateLimiter.Middleware:
+//
+//	handler := limiter.Middleware(concurrency.Middleware(mux))
+func (cl *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := cl.Acquire(r.Context(), cl.config.KeyFunc(r))
+		if err != nil {
+			if errors.Is(err, ErrTooManyInFlight) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			} else {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConcurrencyKeyIgnoresSpoofedForwardedFor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+
+	cl := NewConcurrencyLimiter(nil)
+	if key := cl.config.KeyFunc(r); key != "192.0.2.1" {
+		t.Errorf("key %q, want the peer address: an untrusted peer can't pick its own key", key)
+	}
+	cl = NewConcurrencyLimiter(&ConcurrencyConfig{TrustedProxies: []string{"192.0.2.0/24"}})
+	if key := cl.config.KeyFunc(r); key != "198.51.100.7" {
+		t.Errorf("key %q, want the client behind the trusted proxy", key)
+	}
+}
+
+func TestConcurrencyLimitHoldsAcrossForwardedFor(t *testing.T) {
+	cl := NewConcurrencyLimiter(&ConcurrencyConfig{MaxInFlight: 1})
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := cl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-finish
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+	defer close(finish)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d for a second request with a rotated X-Forwarded-For, want 429", w.Code)
+	}
+}
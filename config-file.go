@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFromReader decodes a Config from r in the given format, "json" or
+// "yaml", and validates it. Fields use the snake_case names from the struct
+// tags, durations may be written as strings like "90s" or "3m", and fields
+// that are left out keep their DefaultConfig values. Function, template and
+// secret fields can't be loaded from a file and must be set in code.
+func ConfigFromReader(r io.Reader, format string) (*Config, error) {
+	raw := make(map[string]any)
+	switch strings.ToLower(format) {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("ratelimiter: decoding json config: %w", err)
+		}
+	case "yaml", "yml":
+		if err := yaml.NewDecoder(r).Decode(&raw); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("ratelimiter: decoding yaml config: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("ratelimiter: unsupported config format %q", format)
+	}
+
+	if err := parseDurations(raw); err != nil {
+		return nil, err
+	}
+
+	// Round-trip through JSON so both formats share the struct tags and
+	// unknown fields are rejected the same way
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimiter: decoding config: %w", err)
+	}
+	cfg := DefaultConfig()
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("ratelimiter: decoding config: %w", err)
+	}
+	cfg.Validate()
+	return cfg, nil
+}
+
+// parseDurations replaces duration strings in raw with their nanosecond values
+func parseDurations(raw map[string]any) error {
+	durationType := reflect.TypeFor[time.Duration]()
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Type != durationType {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		s, ok := raw[name].(string)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("ratelimiter: invalid %s: %w", name, err)
+		}
+		raw[name] = int64(d)
+	}
+	return nil
+}
+
+// unmarshalDuration decodes a duration given either in nanoseconds or as a
+// string like "90s", for nested config structs parseDurations doesn't reach
+func unmarshalDuration(data json.RawMessage, d *time.Duration) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return json.Unmarshal(data, (*int64)(d))
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("ratelimiter: invalid duration %q: %w", s, err)
+	}
+	*d = parsed
+	return nil
+}
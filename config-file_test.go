@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromReader(t *testing.T) {
+	inputs := map[string]string{
+		"json": `{
+			"requests_per_second": 5,
+			"burst": 20,
+			"algorithm": "gcra",
+			"cleanup_interval": "1m",
+			"max_idle_time": "3m",
+			"warmup_duration": 90000000000,
+			"unusual_method_policy": "reject",
+			"quotas": [{"limit": 1000, "period": "24h"}]
+		}`,
+		"yaml": `
+requests_per_second: 5
+burst: 20
+algorithm: gcra
+cleanup_interval: 1m
+max_idle_time: 3m
+warmup_duration: 90000000000
+unusual_method_policy: reject
+quotas:
+  - limit: 1000
+    period: 24h
+`,
+	}
+	for format, input := range inputs {
+		cfg, err := ConfigFromReader(strings.NewReader(input), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if cfg.RequestsPerSecond != 5 || cfg.Burst != 20 || cfg.Algorithm != AlgorithmGCRA {
+			t.Errorf("%s: rate %v, burst %d, algorithm %d", format, cfg.RequestsPerSecond, cfg.Burst, cfg.Algorithm)
+		}
+		if cfg.CleanupInterval != time.Minute || cfg.MaxIdleTime != 3*time.Minute || cfg.WarmupDuration != 90*time.Second {
+			t.Errorf("%s: cleanup %v, idle %v, warmup %v, want 1m, 3m and 1m30s", format, cfg.CleanupInterval, cfg.MaxIdleTime, cfg.WarmupDuration)
+		}
+		if cfg.UnusualMethodPolicy != MethodPolicyReject {
+			t.Errorf("%s: unusual method policy %d", format, cfg.UnusualMethodPolicy)
+		}
+		if len(cfg.Quotas) != 1 || cfg.Quotas[0] != (Quota{Limit: 1000, Period: 24 * time.Hour}) {
+			t.Errorf("%s: quotas %+v", format, cfg.Quotas)
+		}
+		if cfg.RejectionStatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: unset field lost its default", format)
+		}
+	}
+}
+
+func TestConfigFromReaderErrors(t *testing.T) {
+	for _, tt := range []struct{ format, input string }{
+		{"json", `{"max_idle_time": "three minutes"}`},
+		{"json", `{"no_such_field": 1}`},
+		{"yaml", "burst: [1, 2]"},
+		{"toml", "burst = 1"},
+	} {
+		if _, err := ConfigFromReader(strings.NewReader(tt.input), tt.format); err == nil {
+			t.Errorf("%s %q decoded without error", tt.format, tt.input)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ConnectionConfig holds the configuration for a ConnectionLimiter
+type ConnectionConfig struct {
+	// ConnectionsPerSecond, when set, is the rate at which each key may open
+	// new connections
+	ConnectionsPerSecond float64 `json:"connections_per_second" yaml:"connections_per_second" toml:"connections_per_second"`
+	// Burst is the number of connections a key may open at once (default:
+	// one second's worth of ConnectionsPerSecond)
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+	// MaxConnections, when set, is the number of connections a key may have
+	// open at the same time
+	MaxConnections int `json:"max_connections" yaml:"max_connections" toml:"max_connections"`
+}
+
+// Validate ensures the configuration has valid values
+func (c *ConnectionConfig) Validate() {
+	if c.ConnectionsPerSecond < 0 {
+		c.ConnectionsPerSecond = 0
+	}
+	if c.ConnectionsPerSecond > 0 && c.Burst <= 0 {
+		c.Burst = max(1, int(math.Ceil(c.ConnectionsPerSecond)))
+	}
+	if c.MaxConnections < 0 {
+		c.MaxConnections = 0
+	}
+}
+
+// ConnectionLimiter limits long-lived connections such as WebSockets and
+// server-sent event streams, which a request rate doesn't model: one
+// request can hold a connection open for hours. It caps how fast each key
+// opens connections and how many it keeps open. Keys come from the
+// RateLimiter it was created by.
+type ConnectionLimiter struct {
+	rl     *RateLimiter
+	config *ConnectionConfig
+	open   *ConcurrencyLimiter // nil without MaxConnections
+}
+
+// ConnectionLimiter creates a ConnectionLimiter whose keys are extracted
+// like the limiter's own. Connection rate buckets are tracked as visitors
+// under the key with a "|connections" suffix.
+func (rl *RateLimiter) ConnectionLimiter(cfg *ConnectionConfig) *ConnectionLimiter {
+	if cfg == nil {
+		cfg = &ConnectionConfig{}
+	}
+	cfg.Validate()
+	cl := &ConnectionLimiter{rl: rl, config: cfg}
+	if cfg.MaxConnections > 0 {
+		cl.open = NewConcurrencyLimiter(&ConcurrencyConfig{MaxInFlight: cfg.MaxConnections})
+	}
+	return cl
+}
+
+// bucket returns the connection rate bucket of key
+func (cl *ConnectionLimiter) bucket(key string) *rate.Limiter {
+	rl := cl.rl
+	key += "|connections"
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		// custom: SetLimit must leave the connection rate alone
+		v = newVisitor(rate.NewLimiter(rate.Limit(cl.config.ConnectionsPerSecond), cl.config.Burst), rl.now())
+		v.custom = true
+		shard.add(key, v)
+	}
+	v.lastSeen.Store(rl.now())
+	shard.touch(v)
+	v.requests.Add(1)
+	return v.limiter
+}
+
+// Acquire admits a new connection for r, returning the function to call
+// once the connection closes, which must be called exactly once. Handlers
+// that hand a connection over to another goroutine can tie it to the
+// connection's lifetime, e.g. with context.AfterFunc. ok is false if the
+// key opens connections too fast or has too many open; info then describes
+// the denial.
+func (cl *ConnectionLimiter) Acquire(r *http.Request) (release func(), info LimitInfo, ok bool) {
+	rl := cl.rl
+	if rl.closed.Load() || Bypassed(r.Context()) {
+		return func() {}, LimitInfo{}, true
+	}
+	identity := rl.identify(r)
+	key := rl.storageKey(identity)
+	release = func() {}
+	if cl.open != nil {
+		var err error
+		if release, err = cl.open.Acquire(r.Context(), key); err != nil {
+			return nil, LimitInfo{Key: identity, Burst: cl.config.MaxConnections, RetryAfter: time.Second}, false
+		}
+	}
+	if cl.config.ConnectionsPerSecond > 0 {
+		now := rl.now()
+		bucket := cl.bucket(key)
+		if !bucket.AllowN(now, 1) {
+			release()
+			return nil, newLimitInfo(identity, bucket, limiterResult(bucket, now, 1, false)), false
+		}
+	}
+	return release, LimitInfo{}, true
+}
+
+// Open returns the number of connections identity has open. It is always
+// zero without MaxConnections.
+func (cl *ConnectionLimiter) Open(identity string) int {
+	if cl.open == nil {
+		return 0
+	}
+	return cl.open.InFlight(cl.rl.storageKey(identity))
+}
+
+// Middleware limits the connections opened through the wrapped handler,
+// which should serve only long-lived connections such as a WebSocket
+// endpoint. A connection counts as open until the handler returns, which
+// for WebSocket libraries is usually when the connection closes. Denied
+// connections get the limiter's usual rejection response.
+func (cl *ConnectionLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, info, ok := cl.Acquire(r)
+		if !ok {
+			cl.rl.deny(w, r, info)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
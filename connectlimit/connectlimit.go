@@ -0,0 +1,109 @@
+// Package connectlimit applies a ratelimiter.RateLimiter to connect-go
+// handlers, so HTTP, gRPC and Connect services can share one set of limits.
+package connectlimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/gigatar/ratelimiter"
+)
+
+// KeyFunc returns the key a call is limited by. procedure is the RPC's
+// full name, e.g. "/package.Service/Method". Calls for which it returns ""
+// fall back to the peer address.
+type KeyFunc func(ctx context.Context, procedure string, header http.Header) string
+
+// HeaderKey limits calls by the named request header, such as an API key
+// sent as "X-Api-Key"
+func HeaderKey(name string) KeyFunc {
+	return func(ctx context.Context, procedure string, header http.Header) string {
+		return header.Get(name)
+	}
+}
+
+// interceptor limits the calls of the handlers it is added to
+type interceptor struct {
+	rl      *ratelimiter.RateLimiter
+	keyFunc KeyFunc
+}
+
+// NewInterceptor returns an interceptor limiting calls with rl, keyed by a
+// key set with ratelimiter.WithKey, by keyFunc or, failing both, by the
+// peer address. Calls whose context was marked with ratelimiter.WithBypass
+// aren't limited. Denied calls fail with connect.CodeResourceExhausted and a
+// Retry-After header in seconds. Add it to handlers with
+// connect.WithInterceptors; it does nothing on clients.
+func NewInterceptor(rl *ratelimiter.RateLimiter, keyFunc KeyFunc) connect.Interceptor {
+	return &interceptor{rl: rl, keyFunc: keyFunc}
+}
+
+func (i *interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		if err := i.allow(ctx, req.Spec().Procedure, req.Header(), req.Peer()); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler limits the opening of streams the same way unary
+// calls are limited. Messages within an admitted stream aren't limited.
+func (i *interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.allow(ctx, conn.Spec().Procedure, conn.RequestHeader(), conn.Peer()); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+// allow makes the decision for a call, returning the error it fails with
+// when denied
+func (i *interceptor) allow(ctx context.Context, procedure string, header http.Header, peer connect.Peer) error {
+	if ratelimiter.Bypassed(ctx) {
+		return nil
+	}
+	allowed, wait := i.rl.AllowKey(i.callKey(ctx, procedure, header, peer))
+	if allowed {
+		return nil
+	}
+	seconds := retrySeconds(wait)
+	err := connect.NewError(connect.CodeResourceExhausted, errors.New("rate limit exceeded, retry after "+strconv.Itoa(seconds)+"s"))
+	err.Meta().Set("Retry-After", strconv.Itoa(seconds))
+	return err
+}
+
+// callKey returns the key a call is limited by
+func (i *interceptor) callKey(ctx context.Context, procedure string, header http.Header, peer connect.Peer) string {
+	if key, ok := ratelimiter.KeyFromContext(ctx); ok {
+		return key
+	}
+	if i.keyFunc != nil {
+		if key := i.keyFunc(ctx, procedure, header); key != "" {
+			return key
+		}
+	}
+	if host, _, err := net.SplitHostPort(peer.Addr); err == nil {
+		return host
+	}
+	return peer.Addr
+}
+
+// retrySeconds rounds wait up to whole seconds, never returning less than one
+func retrySeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
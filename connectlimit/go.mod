@@ -0,0 +1,16 @@
+module github.com/gigatar/ratelimiter/connectlimit
+
+go 1.24.2
+
+require (
+	connectrpc.com/connect v1.18.1
+	github.com/gigatar/ratelimiter v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/time v0.11.0 // indirect
+	google.golang.org/protobuf v1.36.4 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/gigatar/ratelimiter => ../
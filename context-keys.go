@@ -0,0 +1,40 @@
+package ratelimiter
+
+import "context"
+
+// bypassKey and limitKey are the context keys set by WithBypass and WithKey
+type (
+	bypassKey struct{}
+	limitKey  struct{}
+)
+
+// WithBypass returns a copy of ctx marking its request as exempt from rate
+// limiting, so authentication middleware running before the limiter can let
+// internal service-to-service calls through:
+//
+//	next.ServeHTTP(w, r.WithContext(ratelimiter.WithBypass(r.Context())))
+//
+// Bypassed requests skip every limit, like allowlisted ones; the denylist
+// still applies.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+// Bypassed reports whether ctx was marked by WithBypass
+func Bypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassKey{}).(bool)
+	return bypass
+}
+
+// WithKey returns a copy of ctx carrying the key its request is limited by,
+// such as an authenticated user ID. It takes precedence over KeyFunc and the
+// client IP. An empty key is ignored.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, limitKey{}, key)
+}
+
+// KeyFromContext returns the key set by WithKey, if any
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, _ := ctx.Value(limitKey{}).(string)
+	return key, key != ""
+}
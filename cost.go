@@ -0,0 +1,79 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+)
+
+// ErrCostExceedsBurst is reported for requests costing more tokens than the
+// bucket can ever hold, which would otherwise wait forever
+var ErrCostExceedsBurst = errors.New("ratelimiter: request cost exceeds burst")
+
+// requestWeight returns the number of tokens r costs before any penalties:
+// the larger of its CostFunc and body size costs
+func (rl *RateLimiter) requestWeight(r *http.Request) int {
+	weight := 1
+	if rl.config.CostFunc != nil {
+		weight = max(weight, rl.config.CostFunc(r))
+	}
+	if rl.config.BodyCostBytes > 0 {
+		weight = max(weight, rl.bodyCost(r))
+	}
+	return weight
+}
+
+// bodyCost charges a token per started BodyCostBytes of the request body,
+// capped at MaxBodyCost. Bodies of unknown length are charged
+// UnknownBodyCost, unless MaxMeteredBody has them read and charged for
+// their size.
+func (rl *RateLimiter) bodyCost(r *http.Request) int {
+	if r.ContentLength >= 0 {
+		return rl.bytesCost(r.ContentLength)
+	}
+	if rl.config.MaxMeteredBody == 0 {
+		return rl.config.UnknownBodyCost
+	}
+	n, complete := meterBody(r, rl.config.MaxMeteredBody)
+	if !complete {
+		return max(rl.bytesCost(n), rl.config.UnknownBodyCost)
+	}
+	return rl.bytesCost(n)
+}
+
+// bytesCost returns the tokens a body of size bytes costs
+func (rl *RateLimiter) bytesCost(size int64) int {
+	cost := (size + rl.config.BodyCostBytes - 1) / rl.config.BodyCostBytes
+	if maxCost := int64(rl.config.MaxBodyCost); maxCost > 0 {
+		cost = min(cost, maxCost)
+	}
+	return int(min(cost, math.MaxInt32))
+}
+
+// meterBody reads up to limit bytes of r's body into memory, reporting how
+// many it read and whether that was the whole body. The body is left for
+// the handler to read in full.
+func meterBody(r *http.Request, limit int64) (int64, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return 0, true
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r.Body, limit+1))
+	r.Body = readCloser{io.MultiReader(&buf, r.Body), r.Body}
+	return min(n, limit), err == nil && n <= limit
+}
+
+// weightedCost scales a request's weight by the penalty currently imposed on
+// its key. Penalties are capped at burst, but the weight itself never is.
+func weightedCost(weight, penalty, burst int) int {
+	return max(weight, min(weight*penalty, burst))
+}
+
+// denyCost rejects a request that costs more than its bucket's burst. It can
+// never succeed, so no Retry-After is sent.
+func (rl *RateLimiter) denyCost(w http.ResponseWriter, r *http.Request) {
+	rl.denyHeaders(w, rl.requestID(r))
+	http.Error(w, ErrCostExceedsBurst.Error(), http.StatusTooManyRequests)
+}
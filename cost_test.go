@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChunkedBodyCost(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		metered int64
+		size    int
+		cost    int
+	}{
+		{name: "charged up front", size: 250, cost: 20},
+		{name: "metered", metered: 1000, size: 250, cost: 3},
+		{name: "metered empty", metered: 1000, size: 0, cost: 1},
+		{name: "longer than metered", metered: 1000, size: 5000, cost: 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rl := New(&Config{
+				RequestsPerSecond: 1,
+				Burst:             100,
+				BodyCostBytes:     100,
+				MaxBodyCost:       50,
+				UnknownBodyCost:   20,
+				MaxMeteredBody:    tc.metered,
+				Clock:             NewManualClock(time.Unix(0, 0)),
+			})
+			defer rl.Close()
+			var received []byte
+			h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ = io.ReadAll(r.Body)
+			}))
+
+			body := bytes.Repeat([]byte("x"), tc.size)
+			r := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body))
+			r.ContentLength = -1
+			r.TransferEncoding = []string{"chunked"}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d", w.Code)
+			}
+			if !bytes.Equal(received, body) {
+				t.Errorf("handler read %d bytes, want the whole %d byte body", len(received), len(body))
+			}
+			if got, want := w.Header().Get("RateLimit-Remaining"), strconv.Itoa(100-tc.cost); got != want {
+				t.Errorf("RateLimit-Remaining = %s, want %s after charging %d tokens", got, want, tc.cost)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package ratelimiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// denyRateBuckets is the number of time buckets the deny rate window is split into
+const denyRateBuckets = 60
+
+// denyCounter keeps allow/deny counts in a ring of time buckets so the deny
+// rate over a sliding window can be computed without locking
+type denyCounter struct {
+	width   int64 // bucket width in nanoseconds
+	buckets [denyRateBuckets]denyBucket
+}
+
+type denyBucket struct {
+	slot    atomic.Int64 // absolute bucket number the counts belong to
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+}
+
+func newDenyCounter(window time.Duration) *denyCounter {
+	width := int64(window) / denyRateBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &denyCounter{width: width}
+}
+
+// record counts a single decision in the bucket for the given time
+func (dc *denyCounter) record(now time.Time, denied bool) {
+	slot := now.UnixNano() / dc.width
+	b := &dc.buckets[slot%denyRateBuckets]
+	// The bucket still holds counts from a previous lap of the ring; whoever
+	// wins the swap resets it. A few concurrent increments may be lost here,
+	// which is fine for an approximate rate.
+	if old := b.slot.Load(); old != slot && b.slot.CompareAndSwap(old, slot) {
+		b.allowed.Store(0)
+		b.denied.Store(0)
+	}
+	if denied {
+		b.denied.Add(1)
+	} else {
+		b.allowed.Add(1)
+	}
+}
+
+// totals returns the allowed and denied counts within the window ending at now
+func (dc *denyCounter) totals(now time.Time) (allowed, denied uint64) {
+	current := now.UnixNano() / dc.width
+	for i := range dc.buckets {
+		b := &dc.buckets[i]
+		if current-b.slot.Load() >= denyRateBuckets {
+			continue
+		}
+		allowed += b.allowed.Load()
+		denied += b.denied.Load()
+	}
+	return allowed, denied
+}
+
+// rate returns the fraction of denied requests within the window ending at now
+func (dc *denyCounter) rate(now time.Time) float64 {
+	allowed, denied := dc.totals(now)
+	if allowed+denied == 0 {
+		return 0
+	}
+	return float64(denied) / float64(allowed+denied)
+}
+
+// DenyRate returns the fraction of requests denied over the last DenyRateWindow
+func (rl *RateLimiter) DenyRate() float64 {
+	return rl.denies.rate(rl.now())
+}
@@ -0,0 +1,51 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDenyRateOverSlidingWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             2,
+		DenyRateWindow:    time.Minute,
+		Clock:             clock,
+	})
+	defer rl.Close()
+	request := func(n int) {
+		for range n {
+			rl.Allow("a")
+		}
+	}
+
+	if got := rl.DenyRate(); got != 0 {
+		t.Fatalf("DenyRate with no requests = %v, want 0", got)
+	}
+	request(4) // 2 allowed, 2 denied
+	if got := rl.DenyRate(); got != 0.5 {
+		t.Errorf("DenyRate = %v, want 0.5", got)
+	}
+
+	clock.Advance(30 * time.Second)
+	request(2) // the bucket refilled: 2 more allowed
+	if got := rl.DenyRate(); got != 2.0/6 {
+		t.Errorf("DenyRate = %v, want 2 of 6 requests", got)
+	}
+
+	// the first requests slide out of the window
+	clock.Advance(31 * time.Second)
+	if got := rl.DenyRate(); got != 0 {
+		t.Errorf("DenyRate = %v, want 0 once the denials are older than the window", got)
+	}
+	request(3) // 2 allowed, 1 denied
+	if got := rl.DenyRate(); got != 1.0/5 {
+		t.Errorf("DenyRate = %v, want 1 of 5 requests", got)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if got := rl.DenyRate(); got != 0 {
+		t.Errorf("DenyRate = %v after an idle window, want 0", got)
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Dimension is a further key requests are limited by, with its own rate,
+// on top of the main key. Limiting by account and by IP together catches
+// both a busy NAT shared by many accounts and one account spread over many
+// addresses.
+type Dimension struct {
+	// Name identifies the dimension in the X-RateLimit-Dimension header and
+	// LimitInfo, e.g. "account" or "ip"
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// KeyFunc returns the request's key in this dimension. Requests for
+	// which it returns "" aren't limited by the dimension.
+	KeyFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// RequestsPerSecond is the number of requests allowed per second for
+	// each key
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is the maximum number of requests allowed in a burst (default:
+	// one second's worth of RequestsPerSecond)
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// dimensionBucket returns the bucket of key in dimension d. Like the
+// bandwidth buckets it is tracked as a visitor, under the key with a
+// "|dim:" suffix.
+func (rl *RateLimiter) dimensionBucket(d *Dimension, key string) *rate.Limiter {
+	key = rl.storageKey(key) + "|dim:" + d.Name
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	now := rl.now()
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		// custom: SetLimit must leave the dimension's own rate alone
+		v = newVisitor(rate.NewLimiter(rate.Limit(d.RequestsPerSecond), d.Burst), now)
+		v.custom = true
+		shard.add(key, v)
+	}
+	v.lastSeen.Store(now)
+	shard.touch(v)
+	v.requests.Add(1)
+	return v.limiter
+}
+
+// reserveDimensions takes n tokens from r's bucket in every dimension, or
+// from none of them if one is short, which it then reports along with the
+// request's key in it
+func (rl *RateLimiter) reserveDimensions(r *http.Request, n int, now time.Time) (grants []tokenGrant, failed *Dimension, key string, wait time.Duration, ok bool) {
+	for i := range rl.config.Dimensions {
+		d := &rl.config.Dimensions[i]
+		key := d.KeyFunc(r)
+		if key == "" {
+			continue
+		}
+		bucket := rl.dimensionBucket(d, key)
+		if !bucket.AllowN(now, n) {
+			wait := time.Duration(math.MaxInt64)
+			if n <= bucket.Burst() {
+				wait = tokenWait(bucket.TokensAt(now), bucket.Limit(), n)
+			}
+			releaseLimits(grants, now)
+			return nil, d, key, wait, false
+		}
+		grants = append(grants, tokenGrant{limiter: bucket, n: n})
+	}
+	return grants, nil, "", 0, true
+}
+
+// dimensionLimitInfo describes a request denied by dimension d for key
+func dimensionLimitInfo(d *Dimension, key string, wait time.Duration) LimitInfo {
+	return LimitInfo{Key: key, Limit: rate.Limit(d.RequestsPerSecond), Burst: d.Burst, RetryAfter: wait, Dimension: d.Name}
+}
@@ -0,0 +1,37 @@
+package ratelimiter
+
+import "time"
+
+// admitResource records an access to resource by key and reports whether the
+// key stays within MaxDistinctResources distinct resources over the window.
+// Resources already in the key's set are always admitted; the set never grows
+// beyond the limit, so memory per key stays bounded.
+func (rl *RateLimiter) admitResource(key, resource string, now time.Time) bool {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		return true
+	}
+	if v.resources == nil {
+		v.resources = make(map[string]time.Time)
+	}
+	if _, seen := v.resources[resource]; seen {
+		v.resources[resource] = now
+		return true
+	}
+	if len(v.resources) >= rl.config.MaxDistinctResources {
+		for id, at := range v.resources {
+			if now.Sub(at) >= rl.config.DistinctResourceWindow {
+				delete(v.resources, id)
+			}
+		}
+		if len(v.resources) >= rl.config.MaxDistinctResources {
+			return false
+		}
+	}
+	v.resources[resource] = now
+	return true
+}
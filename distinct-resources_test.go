@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDistinctResources(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:      1000,
+		Burst:                  1000,
+		MaxDistinctResources:   3,
+		DistinctResourceWindow: time.Minute,
+		ResourceFunc: func(r *http.Request) string {
+			return strings.TrimPrefix(r.URL.Path, "/items/")
+		},
+		Clock: clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	get := func(id int, remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/items/"+strconv.Itoa(id), nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for range 50 {
+		if code := get(1, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Fatalf("repeated access to one item: status %d", code)
+		}
+	}
+	for id := 2; id <= 3; id++ {
+		if code := get(id, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Fatalf("item %d of 3: status %d", id, code)
+		}
+	}
+	if code := get(4, "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Fatalf("fourth distinct item: status %d, want 429", code)
+	}
+	if code := get(2, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("item already accessed: status %d after the cap was hit", code)
+	}
+	if code := get(4, "198.51.100.7:1234"); code != http.StatusOK {
+		t.Errorf("another client: status %d", code)
+	}
+
+	clock.Advance(time.Minute)
+	if code := get(4, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("new item after the window: status %d", code)
+	}
+}
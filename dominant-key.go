@@ -0,0 +1,81 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// dominantMinSamples is the number of requests needed in a window before a
+// key can be reported as dominant
+const dominantMinSamples = 100
+
+// dominanceDetector approximates the heaviest keys in the current window using
+// the Misra-Gries summary, which keeps a bounded number of counters no matter
+// how many distinct keys are seen. Counts are never overestimated, so a key is
+// only reported once it really holds at least the configured share.
+type dominanceDetector struct {
+	share    float64
+	window   time.Duration
+	capacity int
+	onWarn   func(key string, share float64)
+
+	mx          sync.Mutex
+	windowStart time.Time
+	total       uint64
+	counts      map[string]uint64
+	warned      map[string]bool
+}
+
+func newDominanceDetector(share float64, window time.Duration, onWarn func(string, float64)) *dominanceDetector {
+	capacity := int(math.Ceil(2 / share))
+	return &dominanceDetector{
+		share:    share,
+		window:   window,
+		capacity: capacity,
+		onWarn:   onWarn,
+		counts:   make(map[string]uint64, capacity),
+		warned:   make(map[string]bool),
+	}
+}
+
+// observe records a request for key and fires the warning the first time in
+// a window that the key's share crosses the threshold
+func (dd *dominanceDetector) observe(key string, now time.Time) {
+	dd.mx.Lock()
+	if now.Sub(dd.windowStart) >= dd.window {
+		dd.windowStart = now
+		dd.total = 0
+		clear(dd.counts)
+		clear(dd.warned)
+	}
+	dd.total++
+
+	switch _, tracked := dd.counts[key]; {
+	case tracked:
+		dd.counts[key]++
+	case len(dd.counts) < dd.capacity:
+		dd.counts[key] = 1
+	default:
+		// Summary is full: decrement every counter and drop the ones that hit zero
+		for k, c := range dd.counts {
+			if c <= 1 {
+				delete(dd.counts, k)
+			} else {
+				dd.counts[k] = c - 1
+			}
+		}
+	}
+
+	count := dd.counts[key]
+	share := float64(count) / float64(dd.total)
+	fire := dd.total >= dominantMinSamples && share >= dd.share && !dd.warned[key]
+	if fire {
+		dd.warned[key] = true
+	}
+	dd.mx.Unlock()
+
+	if fire {
+		dd.onWarn(key, share)
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// dynamicRoutes tracks the paths PerPath routes have given buckets of their
+// own. Once max of them are tracked, requests for any other path share
+// their route's bucket until the cleanup forgets idle paths, so clients
+// requesting random paths can't create limiter state without bound.
+type dynamicRoutes struct {
+	max int
+
+	mx    sync.Mutex
+	paths map[string]time.Time // pattern and path, to when last requested
+}
+
+func newDynamicRoutes(max int) *dynamicRoutes {
+	return &dynamicRoutes{max: max, paths: make(map[string]time.Time)}
+}
+
+// admit reports whether path under the route with pattern gets a bucket of
+// its own, tracking it if there is room
+func (dr *dynamicRoutes) admit(pattern, path string, now time.Time) bool {
+	dr.mx.Lock()
+	defer dr.mx.Unlock()
+
+	id := pattern + "\x00" + path
+	if _, ok := dr.paths[id]; !ok && len(dr.paths) >= dr.max {
+		return false
+	}
+	dr.paths[id] = now
+	return true
+}
+
+// expire forgets paths not requested for maxIdle, making room for others
+func (dr *dynamicRoutes) expire(now time.Time, maxIdle time.Duration) {
+	dr.mx.Lock()
+	defer dr.mx.Unlock()
+	for id, seen := range dr.paths {
+		if now.Sub(seen) >= maxIdle {
+			delete(dr.paths, id)
+		}
+	}
+}
+
+// len returns the number of paths tracked
+func (dr *dynamicRoutes) len() int {
+	dr.mx.Lock()
+	defer dr.mx.Unlock()
+	return len(dr.paths)
+}
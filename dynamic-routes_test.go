@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDynamicRoutesAreCapped(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 10,
+		Burst:             10,
+		Routes: []Route{
+			{Pattern: "/files/*", RequestsPerSecond: 0.001, Burst: 1, PerPath: true},
+			{Pattern: "/static/*", RequestsPerSecond: 0.001, Burst: 1},
+		},
+		MaxDynamicRoutes: 3,
+		Clock:            clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	for i := range 3 {
+		if code := get(fmt.Sprintf("/files/%d", i)); code != http.StatusOK {
+			t.Fatalf("path %d within the cap: got %d", i, code)
+		}
+	}
+	// past the cap, new paths share the route's bucket
+	for i := 3; i < 100; i++ {
+		want := http.StatusTooManyRequests
+		if i == 3 {
+			want = http.StatusOK
+		}
+		if code := get(fmt.Sprintf("/files/%d", i)); code != want {
+			t.Fatalf("path %d past the cap: got %d, want %d", i, code, want)
+		}
+	}
+	if n := rl.dynamic.len(); n != 3 {
+		t.Errorf("%d dynamic paths tracked, want 3", n)
+	}
+	if n := rl.memory.len(); n > 4 {
+		t.Errorf("%d buckets for 100 paths, want at most 4", n)
+	}
+	if code := get("/static/a"); code != http.StatusOK {
+		t.Errorf("static route: got %d", code)
+	}
+
+	// once idle paths are forgotten, new ones get buckets again
+	rl.dynamic.expire(clock.Now().Add(rl.config.MaxIdleTime), rl.config.MaxIdleTime)
+	if code := get("/files/new"); code != http.StatusOK {
+		t.Errorf("new path after expiry: got %d", code)
+	}
+}
@@ -0,0 +1,13 @@
+// Package echoadapter exposes a ratelimiter.RateLimiter as Echo middleware.
+package echoadapter
+
+import (
+	"github.com/gigatar/ratelimiter"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns Echo middleware limiting requests with rl. Denied
+// requests are answered by rl without calling the next handler.
+func Middleware(rl *ratelimiter.RateLimiter) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(rl.Middleware)
+}
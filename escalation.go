@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"time"
+)
+
+// trackDenials updates key's run of consecutive denials, resetting it on
+// success, and reports whether the run has reached EscalationThreshold
+func (rl *RateLimiter) trackDenials(key string, denied bool) bool {
+	if rl.config.EscalationThreshold == 0 {
+		return false
+	}
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		return false
+	}
+	if !denied {
+		v.consecutiveDenials = 0
+		return false
+	}
+	v.consecutiveDenials++
+	return v.consecutiveDenials >= rl.config.EscalationThreshold
+}
+
+// denyKey denies a request over one of its client's own limits, whether
+// its bucket, a quota, a composite limit or a dimension. The denial counts
+// towards escalation, and once key has been denied EscalationThreshold
+// times in a row the response is escalated and told to wait at least
+// EscalationRetryAfter.
+func (rl *RateLimiter) denyKey(w http.ResponseWriter, r *http.Request, key string, info LimitInfo) {
+	if rl.trackDenials(key, true) {
+		info.Escalated = true
+		info.RetryAfter = max(info.RetryAfter, rl.config.EscalationRetryAfter)
+	}
+	rl.deny(w, r, info)
+}
+
+// writeEscalated answers a persistently denied client more harshly: the
+// response is held back for EscalationTarpit and sent with EscalationStatus
+func (rl *RateLimiter) writeEscalated(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rl.config.Clock.(realClock); ok && rl.config.EscalationTarpit > 0 {
+		timer := time.NewTimer(rl.config.EscalationTarpit)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+	status := rl.config.EscalationStatus
+	http.Error(w, http.StatusText(status), status)
+}
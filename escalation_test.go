@@ -0,0 +1,78 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEscalationAfterConsecutiveDenials(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var denied []LimitInfo
+	rl := New(&Config{
+		RequestsPerSecond:    1,
+		Burst:                1,
+		EscalationThreshold:  3,
+		EscalationStatus:     http.StatusForbidden,
+		EscalationRetryAfter: time.Minute,
+		OnDeny:               func(r *http.Request, info LimitInfo) { denied = append(denied, info) },
+		Clock:                clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	serve()
+	for i := 1; i <= 4; i++ {
+		w := serve()
+		escalated := i >= 3
+		want, retry := http.StatusTooManyRequests, "1"
+		if escalated {
+			want, retry = http.StatusForbidden, "60"
+		}
+		if w.Code != want || w.Header().Get("Retry-After") != retry {
+			t.Errorf("denial %d: got %d with Retry-After %q, want %d with %q", i, w.Code, w.Header().Get("Retry-After"), want, retry)
+		}
+		if info := denied[len(denied)-1]; info.Escalated != escalated {
+			t.Errorf("denial %d: OnDeny saw Escalated = %v", i, info.Escalated)
+		}
+	}
+	if len(denied) != 4 {
+		t.Errorf("OnDeny called %d times, want every denial including escalated ones", len(denied))
+	}
+
+	// one admitted request resets the run
+	clock.Advance(time.Second)
+	if w := serve(); w.Code != http.StatusOK {
+		t.Fatalf("got %d after the bucket refilled", w.Code)
+	}
+	if w := serve(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("first denial after a success: got %d, want the normal response", w.Code)
+	}
+}
+
+func TestEscalationCountsQuotaDenials(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond:   100,
+		Burst:               100,
+		Quotas:              []Quota{{Limit: 1, Period: time.Hour}},
+		EscalationThreshold: 2,
+		EscalationStatus:    http.StatusForbidden,
+		Clock:               NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusForbidden, http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != want {
+			t.Errorf("request %d: got %d, want %d", i, w.Code, want)
+		}
+	}
+}
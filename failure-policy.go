@@ -0,0 +1,162 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// FailurePolicy decides what happens to requests while a shared Store fails
+type FailurePolicy int
+
+const (
+	// FailOpen lets requests through unlimited while the store fails
+	FailOpen FailurePolicy = iota
+	// FailClosed denies requests while the store fails
+	FailClosed
+	// FallbackToLocal limits requests with this instance's in-memory buckets
+	// while the store fails, so each instance enforces the full limit on its
+	// own until the store is back
+	FallbackToLocal
+)
+
+// MarshalText encodes the policy as "open", "closed" or "local"
+func (p FailurePolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case FailOpen:
+		return []byte("open"), nil
+	case FailClosed:
+		return []byte("closed"), nil
+	case FallbackToLocal:
+		return []byte("local"), nil
+	}
+	return nil, fmt.Errorf("ratelimiter: unknown failure policy %d", int(p))
+}
+
+// UnmarshalText decodes "open", "closed" or "local" so policies can be
+// written by name in config files
+func (p *FailurePolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "open":
+		*p = FailOpen
+	case "closed":
+		*p = FailClosed
+	case "local":
+		*p = FallbackToLocal
+	default:
+		return fmt.Errorf("ratelimiter: unknown failure policy %q", text)
+	}
+	return nil
+}
+
+// storeHealth tracks failures of a shared store
+type storeHealth struct {
+	errors   atomic.Uint64
+	degraded atomic.Bool // the most recent store operation failed
+	breaker  storeBreaker
+}
+
+// storeBreaker is a circuit breaker that stops calling a failing store. It
+// opens after threshold consecutive errors, then lets a single call through
+// every retry interval (half-open) and closes again once one succeeds.
+type storeBreaker struct {
+	threshold int
+	retry     time.Duration
+
+	mx       sync.Mutex
+	failures int       // consecutive errors
+	openedAt time.Time // when it opened or a probe last failed, zero while closed
+	probing  bool      // a half-open call is in flight
+}
+
+// allow reports whether the store may be called at now, letting one probe
+// through an open breaker once the retry interval has passed
+func (b *storeBreaker) allow(now time.Time) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || now.Sub(b.openedAt) < b.retry {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// failure records a failed call, reporting whether it tripped the breaker.
+// A failed probe keeps it open for another retry interval.
+func (b *storeBreaker) failure(now time.Time) (tripped bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failures++
+	if !b.openedAt.IsZero() {
+		if b.probing {
+			b.probing = false
+			b.openedAt = now
+		}
+		return false
+	}
+	if b.failures >= b.threshold {
+		b.openedAt = now
+		return true
+	}
+	return false
+}
+
+// success records a call that worked, closing the breaker
+func (b *storeBreaker) success() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failures, b.openedAt, b.probing = 0, time.Time{}, false
+}
+
+// open reports whether the breaker is keeping calls from the store
+func (b *storeBreaker) open() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return !b.openedAt.IsZero()
+}
+
+// storeFailed applies the failure policy to an operation on key that failed
+// with err, tripping the circuit breaker after enough of them. take reports
+// whether the operation would have taken n tokens.
+func (rl *RateLimiter) storeFailed(op, key string, err error, limiter *rate.Limiter, n int, now time.Time, take bool) Result {
+	rl.health.errors.Add(1)
+	rl.health.degraded.Store(true)
+	rl.logStoreError(op, key, err)
+	if rl.health.breaker.failure(now) && rl.config.Logger != nil {
+		rl.config.Logger.Warn("ratelimiter: store circuit breaker open",
+			slog.Int("failures", rl.config.StoreBreakerThreshold),
+			slog.Duration("retry_interval", rl.config.StoreRetryInterval),
+		)
+	}
+	return rl.applyFailurePolicy(limiter, n, now, take)
+}
+
+// applyFailurePolicy decides a request the store couldn't. take reports
+// whether the store would have taken n tokens.
+func (rl *RateLimiter) applyFailurePolicy(limiter *rate.Limiter, n int, now time.Time, take bool) Result {
+	switch rl.config.FailurePolicy {
+	case FailClosed:
+		return Result{Limit: limiter.Limit(), Burst: limiter.Burst(), RetryAfter: time.Second, failed: true}
+	case FallbackToLocal:
+		if take {
+			return limiterResult(limiter, now, n, limiter.AllowN(now, n))
+		}
+		return limiterResult(limiter, now, n, limiter.TokensAt(now) >= float64(n))
+	}
+	return Result{Allowed: true}
+}
+
+// storeSucceeded ends degraded operation after a store operation worked
+func (rl *RateLimiter) storeSucceeded() {
+	rl.health.breaker.success()
+	if rl.health.degraded.CompareAndSwap(true, false) && rl.config.Logger != nil {
+		rl.config.Logger.Info("ratelimiter: store recovered", slog.Uint64("errors", rl.health.errors.Load()))
+	}
+}
@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// flakyStore is a Store that always admits requests, or fails every call
+// while down
+type flakyStore struct {
+	mx    sync.Mutex
+	down  bool
+	calls int
+}
+
+func (s *flakyStore) call() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.calls++
+	if s.down {
+		return errors.New("store unavailable")
+	}
+	return nil
+}
+
+func (s *flakyStore) set(down bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.down = down
+}
+
+func (s *flakyStore) count() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.calls
+}
+
+func (s *flakyStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	if err := s.call(); err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: true, Remaining: float64(burst), Limit: limit, Burst: burst}, nil
+}
+
+func (s *flakyStore) Get(ctx context.Context, key string) (Result, bool, error) {
+	return Result{}, false, s.call()
+}
+
+func (s *flakyStore) Touch(ctx context.Context, key string) error { return nil }
+
+func (s *flakyStore) Cleanup(ctx context.Context, maxIdle time.Duration) error { return nil }
+
+func newBreakerLimiter(t *testing.T) (*RateLimiter, *flakyStore, *ManualClock) {
+	t.Helper()
+	store := &flakyStore{}
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:     0.001,
+		Burst:                 1,
+		Store:                 store,
+		FailurePolicy:         FallbackToLocal,
+		StoreBreakerThreshold: 2,
+		StoreRetryInterval:    time.Second,
+		Clock:                 clock,
+	})
+	t.Cleanup(func() { rl.Close() })
+	return rl, store, clock
+}
+
+func TestStoreBreakerTripsAndFallsBackToLocal(t *testing.T) {
+	rl, store, _ := newBreakerLimiter(t)
+	store.set(true)
+
+	if !rl.Allow("a") {
+		t.Fatal("first request should be admitted by the local bucket")
+	}
+	if rl.Allow("a") {
+		t.Fatal("second request should be denied by the local bucket")
+	}
+	if !rl.Metrics().BreakerOpen {
+		t.Fatal("breaker should be open after 2 failures")
+	}
+	for range 5 {
+		if rl.Allow("a") {
+			t.Fatal("local bucket should keep denying while the breaker is open")
+		}
+	}
+	if calls := store.count(); calls != 2 {
+		t.Errorf("store called %d times, want 2: an open breaker mustn't call it", calls)
+	}
+}
+
+func TestStoreBreakerHalfOpenProbe(t *testing.T) {
+	rl, store, clock := newBreakerLimiter(t)
+	store.set(true)
+	rl.Allow("a")
+	rl.Allow("a")
+
+	// a failed probe keeps the breaker open for another interval
+	clock.Advance(time.Second)
+	rl.Allow("a")
+	rl.Allow("a")
+	if calls := store.count(); calls != 3 {
+		t.Errorf("store called %d times, want one probe after the retry interval", calls)
+	}
+	if !rl.Metrics().BreakerOpen {
+		t.Error("breaker should stay open after a failed probe")
+	}
+	clock.Advance(500 * time.Millisecond)
+	rl.Allow("a")
+	if calls := store.count(); calls != 3 {
+		t.Errorf("store called %d times before the next retry interval", calls)
+	}
+}
+
+func TestStoreBreakerRecovers(t *testing.T) {
+	rl, store, clock := newBreakerLimiter(t)
+	store.set(true)
+	rl.Allow("a")
+	rl.Allow("a")
+
+	store.set(false)
+	clock.Advance(time.Second)
+	if !rl.Allow("a") {
+		t.Fatal("the probe should reach the recovered store and be admitted")
+	}
+	m := rl.Metrics()
+	if m.BreakerOpen || m.Degraded {
+		t.Errorf("breaker open = %v, degraded = %v after recovery", m.BreakerOpen, m.Degraded)
+	}
+	for range 3 {
+		if !rl.Allow("a") {
+			t.Fatal("requests should go to the store again, which admits them")
+		}
+	}
+	if calls := store.count(); calls != 6 {
+		t.Errorf("store called %d times, want every request after recovery", calls)
+	}
+}
@@ -0,0 +1,15 @@
+// Package fiberadapter exposes a ratelimiter.RateLimiter as Fiber middleware.
+package fiberadapter
+
+import (
+	"github.com/gigatar/ratelimiter"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// Middleware returns Fiber middleware limiting requests with rl. Fiber runs
+// on fasthttp, so every request is converted to a net/http request first;
+// denied requests are answered by rl without calling the next handler.
+func Middleware(rl *ratelimiter.RateLimiter) fiber.Handler {
+	return adaptor.HTTPMiddleware(rl.Middleware)
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// GCRAStore is a Store that can run AlgorithmGCRA itself, keeping a single
+// timestamp per key. Stores that don't implement it are used as token
+// buckets, which admit exactly the same requests.
+type GCRAStore interface {
+	Store
+	// AllowGCRA admits n requests for key if they conform to limit and
+	// burst, and reports the key's state afterwards. n is zero to only read
+	// the state.
+	AllowGCRA(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error)
+}
+
+// GCRA applies the generic cell rate algorithm to a key whose theoretical
+// arrival time is tat. Every request pushes tat forward by one emission
+// interval, 1/limit, and requests that would push it more than burst
+// intervals past now are denied. It returns the new theoretical arrival
+// time, to be stored if the requests were allowed, and the key's state.
+// Stores implementing GCRAStore can use it to do the arithmetic.
+func GCRA(tat, now time.Time, limit rate.Limit, burst, n int) (time.Time, Result) {
+	res := Result{Limit: limit, Burst: burst}
+	switch {
+	case limit == rate.Inf:
+		res.Allowed, res.Remaining = true, float64(burst)
+		return tat, res
+	case limit <= 0 || n > burst:
+		res.RetryAfter = time.Duration(math.MaxInt64)
+		return tat, res
+	}
+	interval := time.Duration(float64(time.Second) / float64(limit))
+	tolerance := time.Duration(burst) * interval
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(time.Duration(n) * interval)
+	if allowAt := next.Add(-tolerance); allowAt.After(now) {
+		res.RetryAfter = allowAt.Sub(now)
+	} else {
+		res.Allowed, tat = true, next
+	}
+	res.Remaining = max(0, float64(tolerance-tat.Sub(now))/float64(interval))
+	return tat, res
+}
+
+// gcra reports whether AlgorithmGCRA is in use
+func (rl *RateLimiter) gcra() bool {
+	return rl.config.Algorithm == AlgorithmGCRA
+}
+
+// takeGCRA checks n requests for key against limiter's rate and burst,
+// advancing the key's theoretical arrival time if they conform and take is
+// set
+func (rl *RateLimiter) takeGCRA(key string, limiter *rate.Limiter, n int, now time.Time, take bool) Result {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(limiter, now)
+		shard.add(key, v)
+	}
+	tat, res := GCRA(v.tat, now, limiter.Limit(), limiter.Burst(), n)
+	if res.Allowed && take {
+		v.tat = tat
+	}
+	return res
+}
+
+// refundGCRA moves key's theoretical arrival time back by n requests
+func (rl *RateLimiter) refundGCRA(key string, limiter *rate.Limiter, n int) {
+	if limiter.Limit() <= 0 || limiter.Limit() == rate.Inf {
+		return
+	}
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	if v, exists := shard.visitors.get(key); exists && !v.tat.IsZero() {
+		v.tat = v.tat.Add(-time.Duration(n) * time.Duration(float64(time.Second)/float64(limiter.Limit())))
+	}
+}
+
+// storeAllow takes n tokens for key from the shared store, using its GCRA
+// implementation if it has one and AlgorithmGCRA is in use
+func (rl *RateLimiter) storeAllow(ctx context.Context, key string, limiter *rate.Limiter, n int) (Result, error) {
+	var res Result
+	var err error
+	if gs, ok := rl.store.(GCRAStore); ok && rl.gcra() {
+		res, err = gs.AllowGCRA(ctx, key, limiter.Limit(), limiter.Burst(), n)
+	} else {
+		res, err = rl.store.Allow(ctx, key, limiter.Limit(), limiter.Burst(), n)
+	}
+	if err == nil {
+		rl.observeStoreTime(res.At)
+	}
+	return res, err
+}
+
+// storeGet returns key's state in the shared store, like Store.Get
+func (rl *RateLimiter) storeGet(ctx context.Context, key string, limiter *rate.Limiter) (Result, bool, error) {
+	if gs, ok := rl.store.(GCRAStore); ok && rl.gcra() {
+		res, err := gs.AllowGCRA(ctx, key, limiter.Limit(), limiter.Burst(), 0)
+		if err == nil {
+			rl.observeStoreTime(res.At)
+		}
+		return res, err == nil, err
+	}
+	res, ok, err := rl.store.Get(ctx, key)
+	if ok && err == nil {
+		rl.observeStoreTime(res.At)
+	}
+	return res, ok, err
+}
@@ -0,0 +1,51 @@
+// Package ginadapter exposes a ratelimiter.RateLimiter as Gin middleware.
+package ginadapter
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware returns Gin middleware limiting requests with rl. Denied
+// requests are answered by rl and the handler chain is aborted.
+func Middleware(rl *ratelimiter.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admitted := false
+		original := c.Writer
+		rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admitted = true
+			c.Request = r
+			if w != http.ResponseWriter(original) {
+				// The limiter wrapped the writer to see the response status
+				c.Writer = &responseWriter{ResponseWriter: original, w: w}
+				defer func() { c.Writer = original }()
+			}
+			c.Next()
+		})).ServeHTTP(original, c.Request)
+		if !admitted {
+			c.Abort()
+		}
+	}
+}
+
+// responseWriter sends writes through the limiter's wrapper while keeping
+// the rest of gin.ResponseWriter
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.w.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	return rw.w.Write(b)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	return io.WriteString(rw.w, s)
+}
@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+)
+
+// reserveGlobal takes n tokens from the global bucket ahead of the
+// per-client check. ok is false, with the time until they are available,
+// if the tokens can't be had right away. Without a global limit it always
+// succeeds with a nil grant.
+func (rl *RateLimiter) reserveGlobal(now time.Time, n int) (grant *tokenGrant, wait time.Duration, ok bool) {
+	if rl.global == nil {
+		return nil, 0, true
+	}
+	if n > rl.global.Burst() {
+		return nil, time.Duration(math.MaxInt64), false
+	}
+	if wait = tokenWait(rl.global.TokensAt(now), rl.global.Limit(), n); wait > 0 {
+		return nil, wait, false
+	}
+	if !rl.global.AllowN(now, n) {
+		// another request took the tokens in between
+		return nil, tokenWait(rl.global.TokensAt(now), rl.global.Limit(), n), false
+	}
+	return &tokenGrant{limiter: rl.global, n: n}, 0, true
+}
+
+// releaseGlobal returns global tokens taken by reserveGlobal when a later
+// check denies the request after all, or its response is refunded
+func releaseGlobal(grant *tokenGrant, at time.Time) {
+	if grant != nil {
+		grant.release(at)
+	}
+}
+
+// globalLimitInfo describes a request denied by the global limit
+func (rl *RateLimiter) globalLimitInfo(identity string, wait time.Duration) LimitInfo {
+	remaining := int(max(0, rl.global.TokensAt(rl.now())))
+	return LimitInfo{Key: identity, Limit: rl.global.Limit(), Burst: rl.global.Burst(), Remaining: remaining, RetryAfter: wait, Global: true}
+}
@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefundRestoresGlobalAndCompositeTokens(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:       10,
+		Burst:                   10,
+		GlobalRequestsPerSecond: 1,
+		GlobalBurst:             2,
+		Limits:                  []Limit{{Name: "hourly", Requests: 2, Period: time.Hour}},
+		RefundStatuses:          []int{http.StatusServiceUnavailable},
+		Clock:                   clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response finishes well after the tokens were taken
+		clock.Advance(500 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d", w.Code)
+	}
+	if tokens := rl.global.TokensAt(clock.Now()); tokens != 2 {
+		t.Errorf("global bucket holds %v tokens after the refund, want 2", tokens)
+	}
+	state := rl.limits.keys[rl.storageKey("192.0.2.1")]
+	if state == nil {
+		t.Fatal("no composite limit state for the client")
+	}
+	if tokens := state.buckets[0].TokensAt(clock.Now()); tokens < 2 {
+		t.Errorf("composite bucket holds %v tokens after the refund, want 2", tokens)
+	}
+}
+
+func TestDeniedRequestReleasesGlobalTokens(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:       1,
+		Burst:                   1,
+		GlobalRequestsPerSecond: 1,
+		GlobalBurst:             5,
+		Clock:                   clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i := range 5 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if want := map[bool]int{true: http.StatusOK, false: http.StatusTooManyRequests}[i == 0]; w.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i, w.Code, want)
+		}
+	}
+	if tokens := rl.global.TokensAt(clock.Now()); tokens != 4 {
+		t.Errorf("global bucket holds %v tokens, want 4: requests denied per key must not spend it", tokens)
+	}
+}
+
+func TestPerKeyDenialsLeaveGlobalLimitAlone(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:       1,
+		Burst:                   2,
+		GlobalRequestsPerSecond: 0.001,
+		GlobalBurst:             10,
+		Limits:                  []Limit{{Name: "hourly", Requests: 1, Period: time.Hour}},
+		Clock:                   clock,
+	})
+	defer rl.Close()
+
+	// the composite limit admits one request; the rest fail it after the
+	// global token was reserved
+	for i := range 5 {
+		if got := rl.Allow("a"); got != (i == 0) {
+			t.Fatalf("request %d: allowed = %v", i, got)
+		}
+	}
+	if tokens := rl.global.TokensAt(clock.Now()); tokens != 9 {
+		t.Errorf("global bucket holds %v tokens after 1 admitted request, want 9", tokens)
+	}
+}
@@ -2,4 +2,13 @@ module github.com/gigatar/ratelimiter
 
 go 1.24.2
 
-require golang.org/x/time v0.11.0
+require (
+	golang.org/x/time v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/rogpeppe/go-internal v1.13.1 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
@@ -0,0 +1,20 @@
+module github.com/gigatar/ratelimiter/grpclimit
+
+go 1.24.2
+
+require (
+	github.com/gigatar/ratelimiter v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.71.0
+)
+
+require (
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/time v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+	google.golang.org/protobuf v1.36.4 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/gigatar/ratelimiter => ../
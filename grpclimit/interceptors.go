@@ -0,0 +1,104 @@
+// Package grpclimit applies a ratelimiter.RateLimiter to gRPC servers, so
+// HTTP and gRPC services can share one set of limits.
+package grpclimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// KeyFunc returns the key a call is limited by. fullMethod is the RPC's
+// full name, e.g. "/package.Service/Method". Calls for which it returns ""
+// fall back to the peer address.
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// MetadataKey limits calls by the first value of the named metadata entry,
+// such as an API key sent as "x-api-key"
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		if values := metadata.ValueFromIncomingContext(ctx, name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+}
+
+// UnaryServerInterceptor limits unary calls with rl, keyed by a key set with
+// ratelimiter.WithKey, by keyFunc or, failing both, by the peer address.
+// Calls whose context was marked with ratelimiter.WithBypass aren't
+// limited. Denied calls fail with codes.ResourceExhausted and a retry-after
+// header in seconds.
+func UnaryServerInterceptor(rl *ratelimiter.RateLimiter, keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ratelimiter.Bypassed(ctx) {
+			return handler(ctx, req)
+		}
+		if allowed, wait := rl.AllowKey(callKey(ctx, info.FullMethod, keyFunc)); !allowed {
+			grpc.SetHeader(ctx, retryAfter(wait))
+			return nil, exhausted(wait)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor limits the opening of streams the same way
+// UnaryServerInterceptor limits unary calls. Messages within an admitted
+// stream aren't limited.
+func StreamServerInterceptor(rl *ratelimiter.RateLimiter, keyFunc KeyFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ratelimiter.Bypassed(ss.Context()) {
+			return handler(srv, ss)
+		}
+		if allowed, wait := rl.AllowKey(callKey(ss.Context(), info.FullMethod, keyFunc)); !allowed {
+			ss.SetHeader(retryAfter(wait))
+			return exhausted(wait)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// callKey returns the key a call is limited by
+func callKey(ctx context.Context, fullMethod string, keyFunc KeyFunc) string {
+	if key, ok := ratelimiter.KeyFromContext(ctx); ok {
+		return key
+	}
+	if keyFunc != nil {
+		if key := keyFunc(ctx, fullMethod); key != "" {
+			return key
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// retrySeconds rounds wait up to whole seconds, never returning less than one
+func retrySeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
+
+// retryAfter is the header sent with denied calls
+func retryAfter(wait time.Duration) metadata.MD {
+	return metadata.Pairs("retry-after", strconv.Itoa(retrySeconds(wait)))
+}
+
+// exhausted is the error denied calls fail with
+func exhausted(wait time.Duration) error {
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", retrySeconds(wait))
+}
@@ -0,0 +1,53 @@
+package grpclimit
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/gigatar/ratelimiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetadataKeyLimitsByMetadata(t *testing.T) {
+	rl := ratelimiter.New(&ratelimiter.Config{RequestsPerSecond: 0.001, Burst: 1})
+	defer rl.Close()
+	var keys []string
+	interceptor := UnaryServerInterceptor(rl, func(ctx context.Context, fullMethod string) string {
+		key := MetadataKey("x-api-key")(ctx, fullMethod)
+		keys = append(keys, key)
+		return key
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Method"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	// every call comes from the same peer, so only the metadata tells them apart
+	call := func(apiKey string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}})
+		if apiKey != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", apiKey))
+		}
+		_, err := interceptor(ctx, nil, info, handler)
+		return err
+	}
+
+	if err := call("key-a"); err != nil {
+		t.Fatalf("first call for key-a: %v", err)
+	}
+	if err := call("key-a"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second call for key-a: got %v, want ResourceExhausted", err)
+	}
+	if err := call("key-b"); err != nil {
+		t.Errorf("key-b shares a peer with key-a but has its own bucket: %v", err)
+	}
+	if err := call(""); err != nil {
+		t.Errorf("call without metadata falls back to the peer address: %v", err)
+	}
+	if want := []string{"key-a", "key-a", "key-b", ""}; !slices.Equal(keys, want) {
+		t.Errorf("KeyFunc returned %q, want %q", keys, want)
+	}
+}
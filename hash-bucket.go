@@ -0,0 +1,24 @@
+package ratelimiter
+
+import "strconv"
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// fnv32a hashes s with FNV-1a without the allocations of hash/fnv
+func fnv32a(s string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// hashBucket maps key onto one of n buckets. FNV-1a is used so the mapping
+// is stable across restarts and instances.
+func hashBucket(key string, n int) string {
+	return "bucket:" + strconv.FormatUint(uint64(fnv32a(key)%uint32(n)), 10)
+}
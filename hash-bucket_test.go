@@ -0,0 +1,35 @@
+package ratelimiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashBucketsCapKeys(t *testing.T) {
+	cfg := &Config{RequestsPerSecond: 1000, Burst: 1000, HashBuckets: 16, Clock: NewManualClock(time.Unix(0, 0))}
+	rl := New(cfg)
+	defer rl.Close()
+	for i := range 1000 {
+		rl.Allow("client-" + strconv.Itoa(i))
+	}
+
+	counts := rl.KeyCounts()
+	if len(counts) > 16 {
+		t.Errorf("1000 keys landed in %d buckets, want at most 16", len(counts))
+	}
+	var total uint64
+	for _, n := range counts {
+		total += n
+	}
+	if total != 1000 {
+		t.Errorf("buckets counted %d requests, want 1000", total)
+	}
+
+	// the mapping must agree between instances
+	other := New(cfg)
+	defer other.Close()
+	if a, b := rl.DeriveKey("client-7"), other.DeriveKey("client-7"); a != b {
+		t.Errorf("client-7 maps to %q and %q on two instances", a, b)
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// writeLimitHeaders describes the bucket behind a decision using the
+// RateLimit header fields from the IETF httpapi draft. Limit is the bucket's
+// capacity, Remaining the whole tokens left and Reset the seconds until the
+// bucket is full again. Nothing is written if the bucket state is unknown.
+// An unlimited bucket gets no headers, or only RateLimit-Limit: unlimited
+// with MarkUnlimited, and a bucket that never refills gets no Reset.
+func (rl *RateLimiter) writeLimitHeaders(h http.Header, res Result) {
+	if rl.config.OmitHeaders {
+		return
+	}
+	if unlimited(res.Limit) {
+		if rl.config.MarkUnlimited {
+			h.Set("RateLimit-Limit", "unlimited")
+		}
+		return
+	}
+	if res.Burst == 0 {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(int(res.Remaining)))
+	if res.Limit > 0 {
+		reset := resetAfter(res)
+		if reset > 0 {
+			reset = rl.padWait(reset)
+		}
+		h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	}
+}
+
+// unlimited reports whether a bucket refilling at limit never runs out
+func unlimited(limit rate.Limit) bool {
+	return limit == rate.Inf || math.IsInf(float64(limit), 1)
+}
+
+// resetAfter returns the time until the bucket refills completely
+func resetAfter(res Result) time.Duration {
+	missing := float64(res.Burst) - res.Remaining
+	if missing <= 0 || res.Limit <= 0 || unlimited(res.Limit) {
+		return 0
+	}
+	return time.Duration(missing / float64(res.Limit) * float64(time.Second))
+}
+
+// padWait jitters a wait sent to clients and adds RetryAfterMargin, so a
+// client retrying on time doesn't reach an instance whose clock lags a
+// little and be turned away again
+func (rl *RateLimiter) padWait(d time.Duration) time.Duration {
+	if d >= math.MaxInt64/2 {
+		return d
+	}
+	return rl.jitter(d) + rl.config.RetryAfterMargin
+}
+
+// jitter randomly lengthens or shortens d by up to RetryAfterJitter of it.
+// Waits that never end are left alone.
+func (rl *RateLimiter) jitter(d time.Duration) time.Duration {
+	if rl.config.RetryAfterJitter == 0 || d <= 0 || d >= math.MaxInt64/2 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + rl.config.RetryAfterJitter*(2*rand.Float64()-1)))
+}
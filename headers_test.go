@@ -0,0 +1,141 @@
+package ratelimiter
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitHeadersForUnlimitedAndZeroRates(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rps       float64
+		mark      bool
+		limit     string
+		remaining string
+		reset     string
+	}{
+		{name: "normal", rps: 1, limit: "5", remaining: "4", reset: "1"},
+		{name: "unlimited", rps: math.Inf(1)},
+		{name: "unlimited marked", rps: math.Inf(1), mark: true, limit: "unlimited"},
+		{name: "zero rate", rps: 0, limit: "5", remaining: "4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rl := New(&Config{
+				RequestsPerSecond: 1,
+				Burst:             5,
+				MarkUnlimited:     tc.mark,
+				Clock:             NewManualClock(time.Unix(0, 0)),
+			})
+			defer rl.Close()
+			rl.SetVisitorLimit("192.0.2.1", tc.rps, 5)
+			h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d", w.Code)
+			}
+			for header, want := range map[string]string{
+				"RateLimit-Limit":     tc.limit,
+				"RateLimit-Remaining": tc.remaining,
+				"RateLimit-Reset":     tc.reset,
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfterMargin(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		RetryAfterMargin:  2 * time.Second,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want the 1s wait plus the 2s margin", got)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got != "3" {
+		t.Errorf("RateLimit-Reset = %q, want the 1s refill plus the 2s margin", got)
+	}
+}
+
+func TestInstanceIDOnDenials(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             1,
+		InstanceID:        "eu-west-1a",
+		Denylist:          []string{"198.51.100.0/24"},
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := serve("192.0.2.1:1234"); rec.Header().Get("X-RateLimit-Instance") != "" {
+		t.Errorf("allowed request carries instance %q", rec.Header().Get("X-RateLimit-Instance"))
+	}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"rate limited": serve("192.0.2.1:1234"),
+		"denylisted":   serve("198.51.100.7:1234"),
+	} {
+		if rec.Code == http.StatusOK {
+			t.Fatalf("%s request allowed", name)
+		}
+		if got := rec.Header().Get("X-RateLimit-Instance"); got != "eu-west-1a" {
+			t.Errorf("%s request: instance %q, want eu-west-1a", name, got)
+		}
+	}
+}
+
+func TestDocsLink(t *testing.T) {
+	const want = `<https://example.com/docs/rate-limits>; rel="help"`
+	for _, always := range []bool{false, true} {
+		rl := New(&Config{
+			RequestsPerSecond: 0.001,
+			Burst:             1,
+			DocsURL:           "https://example.com/docs/rate-limits",
+			AlwaysLinkDocs:    always,
+			Clock:             NewManualClock(time.Unix(0, 0)),
+		})
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		for _, wantCode := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != wantCode {
+				t.Fatalf("always %v: status %d, want %d", always, rec.Code, wantCode)
+			}
+			links := rec.Header().Values("Link")
+			switch {
+			case wantCode == http.StatusOK && !always:
+				if len(links) != 0 {
+					t.Errorf("allowed request linked %v", links)
+				}
+			case len(links) != 1 || links[0] != want:
+				t.Errorf("always %v, status %d: Link %q, want %q", always, wantCode, links, want)
+			}
+		}
+		rl.Close()
+	}
+}
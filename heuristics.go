@@ -0,0 +1,39 @@
+package ratelimiter
+
+import "time"
+
+// heuristicCost feeds a request for key into the regularity and acceleration
+// detectors and returns the token cost they impose, capped at burst. Keys
+// that trip neither detector cost a single token.
+func (rl *RateLimiter) heuristicCost(key string, now time.Time, burst int) int {
+	if rl.config.RegularityThreshold <= 0 && rl.config.AccelerationThreshold <= 0 {
+		return 1
+	}
+
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		return 1
+	}
+	cost := 1
+	if rl.config.RegularityThreshold > 0 {
+		if v.arrivals == nil {
+			v.arrivals = &arrivalStats{}
+		}
+		if v.arrivals.observe(now, rl.config.RegularityThreshold) {
+			cost = max(cost, rl.config.RegularityPenalty)
+		}
+	}
+	if rl.config.AccelerationThreshold > 0 {
+		if v.trend == nil {
+			v.trend = &rateTrend{}
+		}
+		if v.trend.observe(now, rl.config.AccelerationWindow, rl.config.AccelerationThreshold) {
+			cost = max(cost, rl.config.AccelerationPenalty)
+		}
+	}
+	return min(cost, burst)
+}
@@ -0,0 +1,40 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DenyPageData is passed to Config.HTMLTemplate when rendering a denied request
+type DenyPageData struct {
+	// RetryAfter is the number of seconds until the client may retry
+	RetryAfter int
+}
+
+// acceptsHTML reports whether the client prefers an HTML response
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// retryAfterSeconds rounds d up to whole seconds, never returning less than one
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// writeHTMLDeny renders the configured HTML template, reporting false if it
+// couldn't be rendered so the caller can fall back to plain text
+func (rl *RateLimiter) writeHTMLDeny(w http.ResponseWriter, wait time.Duration) bool {
+	var buf bytes.Buffer
+	data := DenyPageData{RetryAfter: retryAfterSeconds(wait)}
+	if err := rl.config.HTMLTemplate.Execute(&buf, data); err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(rl.config.RejectionStatusCode)
+	w.Write(buf.Bytes())
+	return true
+}
@@ -0,0 +1,48 @@
+package ratelimiter
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDenyNegotiatesHTML(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond:    0.1,
+		Burst:                1,
+		HTMLTemplate:         template.Must(template.New("page").Parse(`<p>Try again in {{.RetryAfter}}s</p>`)),
+		RejectionBody:        `{"error":"rate limited","retry_after":{{.RetryAfter}}}`,
+		RejectionContentType: "application/json",
+		Clock:                NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	tests := []struct {
+		accept, contentType, body string
+	}{
+		{"text/html,application/xhtml+xml;q=0.9", "text/html; charset=utf-8", `<p>Try again in 10s</p>`},
+		{"application/json", "application/json", `{"error":"rate limited","retry_after":10}`},
+		{"", "application/json", `{"error":"rate limited","retry_after":10}`},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Accept %q: status %d, want 429", tt.accept, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("Accept %q: Content-Type %q, want %q", tt.accept, ct, tt.contentType)
+		}
+		if body := rec.Body.String(); body != tt.body {
+			t.Errorf("Accept %q: body %q, want %q", tt.accept, body, tt.body)
+		}
+	}
+}
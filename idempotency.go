@@ -0,0 +1,154 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxIdempotentBody is the largest request body hashed into an idempotency
+// entry; requests with larger bodies are always charged
+const maxIdempotentBody = 1 << 20
+
+// idempotencyEntry is a charged request whose retries are free
+type idempotencyEntry struct {
+	key      string
+	bodyHash string
+	at       time.Time // when the request was charged
+	replays  int       // free retries so far
+}
+
+// idempotencyCache remembers recently charged Idempotency-Key values so
+// retries of the same logical operation aren't charged again. The least
+// recently used entries are dropped once it holds size of them.
+type idempotencyCache struct {
+	ttl        time.Duration
+	size       int
+	maxReplays int
+
+	mx      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newIdempotencyCache(ttl time.Duration, size, maxReplays int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:        ttl,
+		size:       size,
+		maxReplays: maxReplays,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// cacheKey binds the request's Idempotency-Key to the visitor, method and
+// path, so the key can't be reused for a different operation. It returns ""
+// if the request has no key. The body is only hashed by replay and remember,
+// once there is an entry to compare against or the request was admitted, so
+// clients can't make the limiter buffer bodies by sending made-up keys.
+func (ic *idempotencyCache) cacheKey(visitorKey string, r *http.Request) string {
+	idem := r.Header.Get("Idempotency-Key")
+	if idem == "" {
+		return ""
+	}
+	return visitorKey + "\x00" + r.Method + "\x00" + r.URL.Path + "\x00" + idem
+}
+
+// bodyHash hashes r's body, leaving it for the handler to read in full. ok
+// is false if the body is too large to hash.
+func bodyHash(r *http.Request) (hash string, ok bool) {
+	h := sha256.New()
+	if r.Body != nil && r.Body != http.NoBody {
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, io.LimitReader(r.Body, maxIdempotentBody+1))
+		r.Body = readCloser{io.MultiReader(&buf, r.Body), r.Body}
+		if err != nil || n > maxIdempotentBody {
+			return "", false
+		}
+		h.Write(buf.Bytes())
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// replay reports whether key was charged within the TTL for a request with
+// the same body as r, and may be retried for free once more, counting the
+// retry if so
+func (ic *idempotencyCache) replay(key string, r *http.Request, now time.Time) bool {
+	ic.mx.Lock()
+	elem, ok := ic.entries[key]
+	if !ok {
+		ic.mx.Unlock()
+		return false
+	}
+	e := elem.Value.(*idempotencyEntry)
+	if now.Sub(e.at) >= ic.ttl {
+		ic.lru.Remove(elem)
+		delete(ic.entries, key)
+		ic.mx.Unlock()
+		return false
+	}
+	if e.replays >= ic.maxReplays {
+		ic.mx.Unlock()
+		return false
+	}
+	want := e.bodyHash
+	ic.mx.Unlock()
+
+	// hashing can take a while, so it happens outside the lock and the
+	// entry is looked up again afterwards
+	if hash, ok := bodyHash(r); !ok || hash != want {
+		return false
+	}
+	ic.mx.Lock()
+	defer ic.mx.Unlock()
+	elem, ok = ic.entries[key]
+	if !ok {
+		return false
+	}
+	e = elem.Value.(*idempotencyEntry)
+	if e.bodyHash != want || e.replays >= ic.maxReplays {
+		return false
+	}
+	e.replays++
+	ic.lru.MoveToFront(elem)
+	return true
+}
+
+// remember records that key was charged for r, evicting the least recently
+// used entry if the cache is full. A key that has used up its free retries
+// keeps its entry, so charging it again doesn't grant more; reusing it with
+// a different body replaces the entry. Requests whose bodies are too large
+// to hash aren't remembered.
+func (ic *idempotencyCache) remember(key string, r *http.Request, now time.Time) {
+	hash, ok := bodyHash(r)
+	if !ok {
+		return
+	}
+	ic.mx.Lock()
+	defer ic.mx.Unlock()
+
+	if elem, ok := ic.entries[key]; ok {
+		ic.lru.MoveToFront(elem)
+		if e := elem.Value.(*idempotencyEntry); e.bodyHash != hash {
+			*e = idempotencyEntry{key: key, bodyHash: hash, at: now}
+		}
+		return
+	}
+	ic.entries[key] = ic.lru.PushFront(&idempotencyEntry{key: key, bodyHash: hash, at: now})
+	if ic.lru.Len() > ic.size {
+		oldest := ic.lru.Back()
+		ic.lru.Remove(oldest)
+		delete(ic.entries, oldest.Value.(*idempotencyEntry).key)
+	}
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(t *testing.T, h http.Handler, method, path, key, body string) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestIdempotencyKeyBoundToRequest(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		IdempotencyKeyTTL: time.Minute,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	var bodies []string
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+
+	if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", `{"n":1}`); code != http.StatusOK {
+		t.Fatalf("first request: got %d", code)
+	}
+	if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", `{"n":1}`); code != http.StatusOK {
+		t.Fatalf("retry: got %d, want free pass", code)
+	}
+	if bodies[1] != `{"n":1}` {
+		t.Fatalf("handler got body %q after hashing", bodies[1])
+	}
+	for _, tc := range []struct{ name, method, path, body string }{
+		{"body", http.MethodPost, "/orders", `{"n":2}`},
+		{"path", http.MethodPost, "/refunds", `{"n":1}`},
+		{"method", http.MethodPut, "/orders", `{"n":1}`},
+	} {
+		if code := idempotentRequest(t, h, tc.method, tc.path, "k1", tc.body); code != http.StatusTooManyRequests {
+			t.Errorf("reused key with different %s: got %d, want %d", tc.name, code, http.StatusTooManyRequests)
+		}
+	}
+}
+
+func TestIdempotencyKeyReplayCap(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:     1,
+		Burst:                 1,
+		IdempotencyKeyTTL:     time.Minute,
+		IdempotencyMaxReplays: 2,
+		Clock:                 clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i := range 3 {
+		if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", "x"); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", "x"); code != http.StatusTooManyRequests {
+		t.Fatalf("retry past the cap: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// charging the key again once tokens refill doesn't grant more retries
+	clock.Advance(time.Second)
+	if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", "x"); code != http.StatusOK {
+		t.Fatalf("charged retry: got %d", code)
+	}
+	if code := idempotentRequest(t, h, http.MethodPost, "/orders", "k1", "x"); code != http.StatusTooManyRequests {
+		t.Fatalf("retry after recharge: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestIdempotencyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ic := newIdempotencyCache(time.Minute, 2, 3)
+	now := time.Unix(0, 0)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	ic.remember("a", r, now)
+	ic.remember("b", r, now)
+	if !ic.replay("a", r, now) {
+		t.Fatal("a should replay")
+	}
+	ic.remember("c", r, now)
+	if ic.replay("b", r, now) {
+		t.Error("b should have been evicted as least recently used")
+	}
+	if !ic.replay("a", r, now) || !ic.replay("c", r, now) {
+		t.Error("a and c should still replay")
+	}
+	if ic.replay("a", r, now.Add(time.Minute)) {
+		t.Error("a should have expired")
+	}
+	if len(ic.entries) != ic.lru.Len() {
+		t.Errorf("map has %d entries, list %d", len(ic.entries), ic.lru.Len())
+	}
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestIdempotencyKeyDeniedBodyNotRead(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             1,
+		IdempotencyKeyTTL: time.Minute,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	body := &countingReader{Reader: strings.NewReader(strings.Repeat("x", 1<<20))}
+	r := httptest.NewRequest(http.MethodPost, "/orders", body)
+	r.Header.Set("Idempotency-Key", "made-up")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if body.n > 0 {
+		t.Errorf("read %d bytes of a denied request's body", body.n)
+	}
+}
@@ -0,0 +1,142 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// parsePrefix parses a CIDR or a bare IP, which becomes a single address prefix
+func parsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("ratelimiter: invalid IP or CIDR %q", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parsePrefixes parses CIDRs and bare IPs, skipping invalid entries
+func parsePrefixes(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := parsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// containsAddr reports whether ip falls within any of prefixes
+func containsAddr(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipList is a set of IP ranges that can be changed while requests are served
+type ipList struct {
+	mx       sync.RWMutex
+	prefixes []netip.Prefix
+}
+
+func newIPList(entries []string) *ipList {
+	return &ipList{prefixes: parsePrefixes(entries)}
+}
+
+// empty reports whether the list has no entries
+func (l *ipList) empty() bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return len(l.prefixes) == 0
+}
+
+// contains reports whether ip is within one of the list's ranges
+func (l *ipList) contains(ip string) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return containsAddr(l.prefixes, ip)
+}
+
+// add adds entry to the list unless it is already there
+func (l *ipList) add(entry string) error {
+	prefix, err := parsePrefix(entry)
+	if err != nil {
+		return err
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if !slices.Contains(l.prefixes, prefix) {
+		l.prefixes = append(l.prefixes, prefix)
+	}
+	return nil
+}
+
+// remove removes entry from the list. Ranges are matched exactly, so
+// removing a single IP doesn't punch a hole in a CIDR containing it.
+func (l *ipList) remove(entry string) error {
+	prefix, err := parsePrefix(entry)
+	if err != nil {
+		return err
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.prefixes = slices.DeleteFunc(l.prefixes, func(p netip.Prefix) bool { return p == prefix })
+	return nil
+}
+
+// set replaces the list's entries
+func (l *ipList) set(prefixes []netip.Prefix) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.prefixes = prefixes
+}
+
+// AddToAllowlist exempts an IP or CIDR range from limiting
+func (rl *RateLimiter) AddToAllowlist(entry string) error {
+	return rl.allowlist.add(entry)
+}
+
+// RemoveFromAllowlist removes an IP or CIDR range previously allowlisted
+func (rl *RateLimiter) RemoveFromAllowlist(entry string) error {
+	return rl.allowlist.remove(entry)
+}
+
+// AddToDenylist rejects every request from an IP or CIDR range
+func (rl *RateLimiter) AddToDenylist(entry string) error {
+	return rl.denylist.add(entry)
+}
+
+// RemoveFromDenylist removes an IP or CIDR range previously denylisted
+func (rl *RateLimiter) RemoveFromDenylist(entry string) error {
+	return rl.denylist.remove(entry)
+}
+
+// listed checks the peer address of r against the denylist and allowlist.
+// The denylist takes precedence when an address is on both.
+func (rl *RateLimiter) listed(r *http.Request) (denied, allowed bool) {
+	denyEmpty, allowEmpty := rl.denylist.empty(), rl.allowlist.empty()
+	if denyEmpty && allowEmpty {
+		return false, false
+	}
+	ip := rl.peerIP(r)
+	if !denyEmpty && rl.denylist.contains(ip) {
+		return true, false
+	}
+	return false, !allowEmpty && rl.allowlist.contains(ip)
+}
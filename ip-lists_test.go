@@ -0,0 +1,137 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func listRequest(h http.Handler, remoteAddr, forwardedFor string) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestListsIgnoreSpoofedForwardedFor(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		Allowlist:         []string{"10.0.0.1"},
+		Denylist:          []string{"203.0.113.0/24"},
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	if code := listRequest(h, "203.0.113.5:1234", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("denylisted peer claiming another address: got %d, want %d", code, http.StatusForbidden)
+	}
+	listRequest(h, "198.51.100.7:1234", "10.0.0.1")
+	if code := listRequest(h, "198.51.100.7:1234", "10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("peer claiming an allowlisted address: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	for range 3 {
+		if code := listRequest(h, "10.0.0.1:1234", ""); code != http.StatusOK {
+			t.Fatalf("allowlisted peer: got %d", code)
+		}
+	}
+}
+
+func TestListsHonourTrustedProxies(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		Allowlist:         []string{"10.0.0.1"},
+		Denylist:          []string{"203.0.113.0/24"},
+		TrustedProxies:    []string{"192.168.0.0/16"},
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for range 3 {
+		if code := listRequest(h, "192.168.1.1:1234", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("allowlisted client behind a trusted proxy: got %d", code)
+		}
+	}
+	if code := listRequest(h, "192.168.1.1:1234", "203.0.113.5"); code != http.StatusForbidden {
+		t.Errorf("denylisted client behind a trusted proxy: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := listRequest(h, "203.0.113.5:1234", "10.0.0.1"); code != http.StatusForbidden {
+		t.Errorf("denylisted peer that isn't a proxy: got %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestListsChangeAtRuntime(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 0.001, Burst: 1})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	const peer = "198.51.100.7:1234"
+
+	for _, step := range []struct {
+		name   string
+		change func() error
+		want   int
+	}{
+		{name: "unlisted", change: func() error { return nil }, want: http.StatusOK},
+		{name: "bucket empty", change: func() error { return nil }, want: http.StatusTooManyRequests},
+		{name: "denylisted", change: func() error { return rl.AddToDenylist("198.51.100.0/24") }, want: http.StatusForbidden},
+		{name: "removing a single IP leaves the range", change: func() error { return rl.RemoveFromDenylist("198.51.100.7") }, want: http.StatusForbidden},
+		{name: "range removed", change: func() error { return rl.RemoveFromDenylist("198.51.100.0/24") }, want: http.StatusTooManyRequests},
+		{name: "allowlisted", change: func() error { return rl.AddToAllowlist("198.51.100.7") }, want: http.StatusOK},
+		{name: "still allowlisted", change: func() error { return nil }, want: http.StatusOK},
+		{name: "denylist wins", change: func() error { return rl.AddToDenylist("198.51.100.7/32") }, want: http.StatusForbidden},
+		{name: "both removed", change: func() error {
+			if err := rl.RemoveFromDenylist("198.51.100.7/32"); err != nil {
+				return err
+			}
+			return rl.RemoveFromAllowlist("198.51.100.7")
+		}, want: http.StatusTooManyRequests},
+	} {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if code := listRequest(h, peer, ""); code != step.want {
+			t.Errorf("%s: got %d, want %d", step.name, code, step.want)
+		}
+	}
+	if err := rl.AddToDenylist("not an address"); err == nil {
+		t.Error("AddToDenylist accepted an invalid entry")
+	}
+}
+
+func TestListsChangeConcurrently(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 1000, Burst: 1000})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				switch code := listRequest(h, "198.51.100.7:1234", ""); code {
+				case http.StatusOK, http.StatusForbidden, http.StatusTooManyRequests:
+				default:
+					t.Errorf("unexpected status %d", code)
+				}
+			}
+		}()
+	}
+	for range 200 {
+		rl.AddToDenylist("198.51.100.0/24")
+		rl.AddToAllowlist("198.51.100.7")
+		rl.RemoveFromDenylist("198.51.100.0/24")
+		rl.RemoveFromAllowlist("198.51.100.7")
+	}
+	wg.Wait()
+
+	if code := listRequest(h, "198.51.100.7:1234", ""); code == http.StatusForbidden {
+		t.Error("peer still denied after its range was removed")
+	}
+}
@@ -0,0 +1,26 @@
+package ratelimiter
+
+import "net/netip"
+
+// prefixKey maps a client IP to the network it is limited by, so a client
+// can't dodge its limit by rotating through the addresses of its own /64.
+// Addresses limited individually are returned unchanged.
+func (rl *RateLimiter) prefixKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := rl.config.IPv4PrefixBits
+	if addr.Is6() {
+		bits = rl.config.IPv6PrefixBits
+	}
+	if bits >= addr.BitLen() {
+		return ip
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
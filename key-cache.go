@@ -0,0 +1,97 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keyCacheEntry is a KeyFunc result remembered for a cheap request attribute
+type keyCacheEntry struct {
+	attr string
+	key  string
+	at   time.Time // when KeyFunc returned key
+}
+
+// keyCache remembers KeyFunc results by the KeyCacheFunc attribute of the
+// request they came from, for ttl. The least recently used entries are
+// dropped once it holds size of them.
+type keyCache struct {
+	ttl  time.Duration
+	size int
+
+	mx      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newKeyCache(ttl time.Duration, size int) *keyCache {
+	return &keyCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the key remembered for attr if it is younger than the TTL
+func (kc *keyCache) get(attr string, now time.Time) (string, bool) {
+	kc.mx.Lock()
+	defer kc.mx.Unlock()
+
+	elem, ok := kc.entries[attr]
+	if !ok {
+		return "", false
+	}
+	e := elem.Value.(*keyCacheEntry)
+	if now.Sub(e.at) >= kc.ttl {
+		kc.lru.Remove(elem)
+		delete(kc.entries, attr)
+		return "", false
+	}
+	kc.lru.MoveToFront(elem)
+	return e.key, true
+}
+
+// put remembers key for attr, evicting the least recently used entry if the
+// cache is full
+func (kc *keyCache) put(attr, key string, now time.Time) {
+	kc.mx.Lock()
+	defer kc.mx.Unlock()
+
+	if elem, ok := kc.entries[attr]; ok {
+		e := elem.Value.(*keyCacheEntry)
+		e.key, e.at = key, now
+		kc.lru.MoveToFront(elem)
+		return
+	}
+	kc.entries[attr] = kc.lru.PushFront(&keyCacheEntry{attr: attr, key: key, at: now})
+	if kc.lru.Len() > kc.size {
+		oldest := kc.lru.Back()
+		kc.lru.Remove(oldest)
+		delete(kc.entries, oldest.Value.(*keyCacheEntry).attr)
+	}
+}
+
+// keyFor returns KeyFunc's key for r, reusing the one found for an earlier
+// request with the same KeyCacheFunc attribute within KeyCacheTTL. Empty
+// results aren't cached.
+func (rl *RateLimiter) keyFor(r *http.Request) string {
+	if rl.keys == nil {
+		return rl.config.KeyFunc(r)
+	}
+	attr := rl.config.KeyCacheFunc(r)
+	if attr == "" {
+		return rl.config.KeyFunc(r)
+	}
+	now := rl.now()
+	if key, ok := rl.keys.get(attr, now); ok {
+		return key
+	}
+	key := rl.config.KeyFunc(r)
+	if key != "" {
+		rl.keys.put(attr, key, now)
+	}
+	return key
+}
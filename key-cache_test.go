@@ -0,0 +1,70 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyCacheReusesKeyFuncForCorrelatedRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		ttl   time.Duration
+		calls int64
+	}{
+		{name: "uncached", calls: 10},
+		{name: "cached", ttl: time.Second, calls: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			var calls atomic.Int64
+			rl := New(&Config{
+				RequestsPerSecond: 100,
+				Burst:             100,
+				KeyFunc: func(r *http.Request) string {
+					calls.Add(1)
+					return r.Header.Get("Authorization")
+				},
+				KeyCacheFunc: func(r *http.Request) string { return r.RemoteAddr },
+				KeyCacheTTL:  tc.ttl,
+				Clock:        clock,
+			})
+			defer rl.Close()
+			h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			for i := range 10 {
+				if i == 5 {
+					clock.Advance(time.Second)
+				}
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("Authorization", "alice")
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			if n := calls.Load(); n != tc.calls {
+				t.Errorf("KeyFunc called %d times for 10 requests, want %d", n, tc.calls)
+			}
+			if info, ok := rl.VisitorInfo("alice"); !ok || info.Requests != 10 {
+				t.Errorf("VisitorInfo(alice) = %+v, %v, want all 10 requests charged to her", info, ok)
+			}
+		})
+	}
+}
+
+func TestKeyCacheExpiresAndEvicts(t *testing.T) {
+	now := time.Unix(0, 0)
+	kc := newKeyCache(time.Second, 2)
+	kc.put("a", "alice", now)
+	kc.put("b", "bob", now)
+	if key, ok := kc.get("a", now.Add(999*time.Millisecond)); !ok || key != "alice" {
+		t.Fatalf("get(a) = %q, %v within the TTL", key, ok)
+	}
+	kc.put("c", "carol", now)
+	if _, ok := kc.get("b", now); ok {
+		t.Error("b should have been evicted as least recently used")
+	}
+	if _, ok := kc.get("a", now.Add(time.Second)); ok {
+		t.Error("a should have expired after the TTL")
+	}
+}
@@ -0,0 +1,26 @@
+package ratelimiter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// DeriveKey returns the key the limiter stores state under for identity, as
+// reported by KeyCounts and the visitor hooks. Without KeySecret and
+// HashBuckets that is the identity itself. Per-key methods such as
+// SetKeyRate and BoostKey take identities and derive the key themselves.
+func (rl *RateLimiter) DeriveKey(identity string) string {
+	return rl.storageKey(identity)
+}
+
+// secretKey returns identity, or a truncated HMAC-SHA256 of it with
+// KeySecret
+func (rl *RateLimiter) secretKey(identity string) string {
+	if len(rl.config.KeySecret) == 0 {
+		return identity
+	}
+	mac := hmac.New(sha256.New, rl.config.KeySecret)
+	mac.Write([]byte(identity))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func newDerivingLimiter(t *testing.T) (*RateLimiter, *ManualClock) {
+	t.Helper()
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             1,
+		KeySecret:         []byte("secret"),
+		HashBuckets:       1024,
+		Clock:             clock,
+	})
+	t.Cleanup(func() { rl.Close() })
+	return rl, clock
+}
+
+func TestDeriveKeyAppliesSecretAndHashBuckets(t *testing.T) {
+	rl, _ := newDerivingLimiter(t)
+	if got, want := rl.DeriveKey("203.0.113.7"), hashBucket(rl.secretKey("203.0.113.7"), 1024); got != want {
+		t.Errorf("DeriveKey = %q, want the hash bucket %q", got, want)
+	}
+	rl.Allow("203.0.113.7")
+	if n := rl.KeyCounts()[rl.DeriveKey("203.0.113.7")]; n != 1 {
+		t.Errorf("KeyCounts has %d requests under the derived key, want 1", n)
+	}
+}
+
+func TestPerKeyMethodsTakeIdentities(t *testing.T) {
+	t.Run("SetKeyRate", func(t *testing.T) {
+		rl, _ := newDerivingLimiter(t)
+		rl.SetKeyRate("a", 3, time.Second, 3)
+		for i := range 4 {
+			if got := rl.Allow("a"); got != (i < 3) {
+				t.Fatalf("request %d: allowed = %v", i, got)
+			}
+		}
+	})
+	t.Run("SetLimitFor", func(t *testing.T) {
+		rl, _ := newDerivingLimiter(t)
+		rl.SetLimitFor("a", 3, 3)
+		for i := range 4 {
+			if got := rl.Allow("a"); got != (i < 3) {
+				t.Fatalf("request %d: allowed = %v", i, got)
+			}
+		}
+	})
+	t.Run("SetLimitsFor", func(t *testing.T) {
+		rl, _ := newDerivingLimiter(t)
+		rl.SetLimitFor("a", 10, 10)
+		rl.SetLimitsFor("a", Limit{Name: "hourly", Requests: 1, Period: time.Hour})
+		if _, ok := rl.limits.overrides[rl.storageKey("a")]; !ok {
+			t.Fatal("no composite limits under the identity's key")
+		}
+		rl.Allow("a")
+		if rl.Allow("a") {
+			t.Error("second request admitted by a limit of 1 an hour")
+		}
+	})
+	t.Run("BoostKey", func(t *testing.T) {
+		rl, clock := newDerivingLimiter(t)
+		rl.BoostKey("a", 5, 5, time.Hour)
+		// a boost raises the burst; the bucket fills up at the boosted rate
+		clock.Advance(time.Second)
+		for i := range 6 {
+			if got := rl.Allow("a"); got != (i < 5) {
+				t.Fatalf("request %d: allowed = %v", i, got)
+			}
+		}
+	})
+}
+
+func TestDrainDoesNotCountRequests(t *testing.T) {
+	rl, _ := newDerivingLimiter(t)
+	rl.SetLimitFor("a", 5, 5)
+	rl.Drain("a")
+	if rl.Allow("a") {
+		t.Fatal("request after Drain was admitted")
+	}
+	info, ok := rl.VisitorInfo("a")
+	if !ok {
+		t.Fatal("no visitor for the drained identity")
+	}
+	if info.Requests != 1 {
+		t.Errorf("visitor has %d requests, want only the one after Drain", info.Requests)
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// KeyState is a point-in-time view of a tracked key's bucket
+type KeyState struct {
+	Key       string    `json:"key"`
+	Remaining int       `json:"remaining"`
+	Limit     float64   `json:"limit"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// KeyStates returns the state of tracked keys, most recently seen first.
+// At most maxEntries are returned unless maxEntries is zero or negative.
+// Keys tracked in CountOnly mode have no bucket and are left out.
+func (rl *RateLimiter) KeyStates(maxEntries int) []KeyState {
+	now := rl.now()
+	states := make([]KeyState, 0)
+	rl.memory.each(func(key string, v *visitor) {
+		if v.limiter == nil {
+			return
+		}
+		res := rl.bucketState(v, now)
+		states = append(states, KeyState{
+			Key:       key,
+			Remaining: max(0, int(math.Floor(res.Remaining))),
+			Limit:     float64(res.Limit),
+			LastSeen:  v.lastSeen.Load(),
+		})
+	})
+
+	slices.SortFunc(states, func(a, b KeyState) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	if maxEntries > 0 && len(states) > maxEntries {
+		states = states[:maxEntries]
+	}
+	return states
+}
+
+// KeyStatesHandler serves KeyStates as JSON for dashboards. When hashKeys is
+// set, keys are replaced by a truncated SHA-256 so client identities such as
+// IP addresses aren't exposed.
+func (rl *RateLimiter) KeyStatesHandler(maxEntries int, hashKeys bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states := rl.KeyStates(maxEntries)
+		if hashKeys {
+			for i := range states {
+				sum := sha256.Sum256([]byte(states[i].Key))
+				states[i].Key = hex.EncodeToString(sum[:8])
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	})
+}
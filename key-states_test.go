@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeyStatesHandler(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	rl := New(&Config{RequestsPerSecond: 2, Burst: 5, Clock: clock})
+	defer rl.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		rl.Allow(key)
+		clock.Advance(time.Millisecond)
+	}
+	rl.Allow("c")
+
+	for _, hashKeys := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		rl.KeyStatesHandler(2, hashKeys).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q", ct)
+		}
+		var entries []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("hashKeys %v: %d entries with a cap of 2", hashKeys, len(entries))
+		}
+		for _, e := range entries {
+			if len(e) != 4 || e["key"] == nil || e["remaining"] == nil || e["limit"] == nil || e["lastSeen"] == nil {
+				t.Errorf("entry %v, want exactly key, remaining, limit and lastSeen", e)
+			}
+		}
+		// most recently seen first
+		newest := entries[0]
+		if key := newest["key"].(string); hashKeys == (key == "c") || hashKeys && len(key) != 16 {
+			t.Errorf("hashKeys %v: newest key %q", hashKeys, key)
+		}
+		if newest["remaining"] != 3.0 || newest["limit"] != 2.0 {
+			t.Errorf("newest entry %v, want 3 remaining at a limit of 2", newest)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, newest["lastSeen"].(string)); err != nil {
+			t.Errorf("lastSeen: %v", err)
+		}
+	}
+	if n := len(rl.KeyStates(0)); n != 3 {
+		t.Errorf("KeyStates(0) returned %d entries, want all 3", n)
+	}
+}
@@ -0,0 +1,270 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrConflict is returned by KVStore when a bucket kept changing under it
+// and every compare-and-swap attempt lost
+var ErrConflict = errors.New("ratelimiter: too many concurrent updates")
+
+// ErrBucketEncoding is returned when decoding a bucket that wasn't written by
+// Bucket.MarshalBinary, or by a newer version of it
+var ErrBucketEncoding = errors.New("ratelimiter: invalid bucket encoding")
+
+// maxBucketTTL bounds how long buckets that never refill are kept
+const maxBucketTTL = 24 * time.Hour
+
+// bucketVersion prefixes encoded buckets so the format can change later
+const bucketVersion = 1
+
+// bucketSize is the length of an encoded bucket: version, tokens, update
+// time, limit and burst
+const bucketSize = 1 + 8 + 8 + 8 + 8
+
+// Bucket is a token bucket's stored state. It does the refill arithmetic for
+// stores built on a plain key-value database, which then only need to load
+// and save it.
+type Bucket struct {
+	// Tokens held at Updated
+	Tokens float64
+	// Updated is when the bucket was last refilled
+	Updated time.Time
+	// Limit is the refill rate in tokens per second
+	Limit rate.Limit
+	// Burst is the bucket's capacity
+	Burst int
+}
+
+// NewBucket returns a full bucket
+func NewBucket(limit rate.Limit, burst int, now time.Time) Bucket {
+	return Bucket{Tokens: float64(burst), Updated: now, Limit: limit, Burst: burst}
+}
+
+// TokensAt returns the tokens the bucket holds at now
+func (b Bucket) TokensAt(now time.Time) float64 {
+	if b.Limit == rate.Inf {
+		return float64(b.Burst)
+	}
+	elapsed := max(0, now.Sub(b.Updated))
+	return min(float64(b.Burst), b.Tokens+elapsed.Seconds()*float64(b.Limit))
+}
+
+// Take refills the bucket up to now and takes n tokens if it holds them.
+// limit and burst replace the stored ones, so per-key limits changed on the
+// RateLimiter reach the store.
+func (b *Bucket) Take(now time.Time, limit rate.Limit, burst, n int) Result {
+	tokens := b.TokensAt(now)
+	b.Limit, b.Burst = limit, burst
+	tokens = min(tokens, float64(burst))
+	allowed := limit == rate.Inf || tokens >= float64(n)
+	if allowed && limit != rate.Inf {
+		tokens -= float64(n)
+	}
+	b.Tokens, b.Updated = tokens, now
+
+	res := Result{Allowed: allowed, Remaining: max(0, tokens), Limit: limit, Burst: burst}
+	if !allowed {
+		res.RetryAfter = tokenWait(tokens, limit, n)
+	}
+	return res
+}
+
+// Result returns the bucket's state at now without taking tokens
+func (b Bucket) Result(now time.Time) Result {
+	return Result{Allowed: true, Remaining: b.TokensAt(now), Limit: b.Limit, Burst: b.Burst}
+}
+
+// TTL returns how long an untouched bucket takes to refill completely, after
+// which it is indistinguishable from a new one and can be expired
+func (b Bucket) TTL() time.Duration {
+	if b.Limit <= 0 {
+		return maxBucketTTL
+	}
+	refill := time.Duration(float64(b.Burst) / float64(b.Limit) * float64(time.Second))
+	return min(maxBucketTTL, refill+time.Second)
+}
+
+// MarshalBinary encodes the bucket in a compact, versioned form
+func (b Bucket) MarshalBinary() ([]byte, error) {
+	data := make([]byte, bucketSize)
+	data[0] = bucketVersion
+	binary.BigEndian.PutUint64(data[1:], math.Float64bits(b.Tokens))
+	binary.BigEndian.PutUint64(data[9:], uint64(b.Updated.UnixNano()))
+	binary.BigEndian.PutUint64(data[17:], math.Float64bits(float64(b.Limit)))
+	binary.BigEndian.PutUint64(data[25:], uint64(b.Burst))
+	return data, nil
+}
+
+// UnmarshalBinary decodes a bucket written by MarshalBinary
+func (b *Bucket) UnmarshalBinary(data []byte) error {
+	if len(data) != bucketSize || data[0] != bucketVersion {
+		return ErrBucketEncoding
+	}
+	b.Tokens = math.Float64frombits(binary.BigEndian.Uint64(data[1:]))
+	b.Updated = time.Unix(0, int64(binary.BigEndian.Uint64(data[9:])))
+	b.Limit = rate.Limit(math.Float64frombits(binary.BigEndian.Uint64(data[17:])))
+	b.Burst = int(binary.BigEndian.Uint64(data[25:]))
+	return nil
+}
+
+// KV is the little a key-value database has to offer for KVStore to keep
+// token buckets in it: reads, compare-and-swap writes and expiry. Memcached,
+// etcd, DynamoDB conditional writes and SQL with a version column all fit.
+type KV interface {
+	// Get returns the value stored under key and an opaque version to pass
+	// to CompareAndSwap. value is nil if the key doesn't exist.
+	Get(ctx context.Context, key string) (value []byte, version any, err error)
+	// CompareAndSwap stores value under key, expiring after ttl, if the key
+	// is still at version; a nil version means the key must not exist yet.
+	// swapped is false if another writer got there first.
+	CompareAndSwap(ctx context.Context, key string, value []byte, version any, ttl time.Duration) (swapped bool, err error)
+	// Touch pushes key's expiry back to ttl from now. Missing keys are not
+	// an error.
+	Touch(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// KVStore is a Store keeping Buckets in any KV, updating them with
+// optimistic compare-and-swap. Time comes from the local clock, so the
+// instances sharing a KV should keep theirs in sync.
+type KVStore struct {
+	kv      KV
+	prefix  string
+	retries int
+}
+
+var _ GCRAStore = (*KVStore)(nil)
+
+// conflictBackoff is the longest a KVStore waits before its first retry
+// after losing a compare-and-swap. Each later retry may wait twice as long.
+const conflictBackoff = time.Millisecond
+
+// NewKVStore creates a KVStore over kv. Keys are stored under prefix, which
+// lets several limiters share one database without colliding.
+func NewKVStore(kv KV, prefix string) *KVStore {
+	return &KVStore{kv: kv, prefix: prefix, retries: 10}
+}
+
+// load reads and decodes key's bucket. ok is false if there is none.
+func (s *KVStore) load(ctx context.Context, key string) (b Bucket, version any, ok bool, err error) {
+	data, version, err := s.kv.Get(ctx, s.prefix+key)
+	if err != nil || data == nil {
+		return Bucket{}, nil, false, err
+	}
+	if err := b.UnmarshalBinary(data); err != nil {
+		return Bucket{}, nil, false, err
+	}
+	return b, version, true, nil
+}
+
+// backoff waits a random time before retry attempt, growing with each
+// attempt, so writers that collided don't collide again. The first attempt
+// doesn't wait.
+func (s *KVStore) backoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return nil
+	}
+	t := time.NewTimer(rand.N(conflictBackoff << (attempt - 1)))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Allow implements Store. Concurrent updates to the same key are retried
+// after a short random backoff, failing with ErrConflict if they keep
+// colliding.
+func (s *KVStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	for attempt := range s.retries {
+		if err := s.backoff(ctx, attempt); err != nil {
+			return Result{}, err
+		}
+		now := time.Now()
+		b, version, ok, err := s.load(ctx, key)
+		if err != nil {
+			return Result{}, err
+		}
+		if !ok {
+			b = NewBucket(limit, burst, now)
+		}
+		res := b.Take(now, limit, burst, n)
+		data, _ := b.MarshalBinary()
+		swapped, err := s.kv.CompareAndSwap(ctx, s.prefix+key, data, version, b.TTL())
+		if err != nil {
+			return Result{}, err
+		}
+		if swapped {
+			return res, nil
+		}
+	}
+	return Result{}, ErrConflict
+}
+
+// AllowGCRA implements GCRAStore, keeping each key's theoretical arrival
+// time in an 8 byte value next to its bucket
+func (s *KVStore) AllowGCRA(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	key = s.prefix + key + "|gcra"
+	for attempt := range s.retries {
+		if err := s.backoff(ctx, attempt); err != nil {
+			return Result{}, err
+		}
+		now := time.Now()
+		data, version, err := s.kv.Get(ctx, key)
+		if err != nil {
+			return Result{}, err
+		}
+		var tat time.Time
+		if len(data) == 8 {
+			tat = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		} else if data != nil {
+			return Result{}, ErrBucketEncoding
+		}
+		next, res := GCRA(tat, now, limit, burst, n)
+		if !res.Allowed || n == 0 {
+			return res, nil
+		}
+		data = binary.BigEndian.AppendUint64(nil, uint64(next.UnixNano()))
+		swapped, err := s.kv.CompareAndSwap(ctx, key, data, version, min(maxBucketTTL, next.Sub(now)+time.Second))
+		if err != nil {
+			return Result{}, err
+		}
+		if swapped {
+			return res, nil
+		}
+	}
+	return Result{}, ErrConflict
+}
+
+// Get implements Store
+func (s *KVStore) Get(ctx context.Context, key string) (Result, bool, error) {
+	b, _, ok, err := s.load(ctx, key)
+	if err != nil || !ok {
+		return Result{}, false, err
+	}
+	return b.Result(time.Now()), true, nil
+}
+
+// Touch implements Store by extending the key's expiry
+func (s *KVStore) Touch(ctx context.Context, key string) error {
+	b, _, ok, err := s.load(ctx, key)
+	if err != nil || !ok {
+		return err
+	}
+	return s.kv.Touch(ctx, s.prefix+key, b.TTL())
+}
+
+// Cleanup implements Store. Buckets expire in the KV on their own, so there
+// is nothing to do.
+func (s *KVStore) Cleanup(ctx context.Context, maxIdle time.Duration) error {
+	return nil
+}
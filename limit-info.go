@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LimitInfo describes a denied request to Config.OnLimitExceeded and OnDeny
+type LimitInfo struct {
+	// Key is the identity the request was limited by: the KeyFunc result or
+	// the client IP
+	Key string
+	// Limit is the rate the key's bucket refills at, in requests per second
+	Limit rate.Limit
+	// Burst is the key's bucket size
+	Burst int
+	// Remaining is the number of whole requests the bucket had left, fewer
+	// than the denied request needed
+	Remaining int
+	// RetryAfter is how long the client should wait before retrying
+	RetryAfter time.Duration
+	// Global is set when the request was denied by the global limit rather
+	// than the client's own. Limit and Burst then describe the global limit.
+	Global bool
+	// Quota is the quota that denied the request, nil if it was a rate.
+	// Limit and Burst then describe the quota.
+	Quota *Quota
+	// Constraint is the name of the composite Limit that denied the request,
+	// empty if it was another limit. Limit and Burst then describe it.
+	Constraint string
+	// Dimension is the name of the Dimension that denied the request, empty
+	// if it was another limit. Key, Limit and Burst then describe the
+	// request's key and limit in that dimension.
+	Dimension string
+	// Escalated is set when the client has been denied EscalationThreshold
+	// times in a row, so the response is held back for EscalationTarpit and
+	// sent with EscalationStatus, and RetryAfter is at least
+	// EscalationRetryAfter
+	Escalated bool
+	// RequestID is the ID sent back in Config.RequestIDHeader: the one the
+	// request carried, or the one generated for it. It is empty without a
+	// RequestIDHeader.
+	RequestID string
+}
+
+// newLimitInfo describes the bucket state res of identity's denied request
+func newLimitInfo(identity string, limiter *rate.Limiter, res Result) LimitInfo {
+	info := LimitInfo{Key: identity, Limit: limiter.Limit(), Burst: limiter.Burst(), Remaining: int(max(0, res.Remaining)), RetryAfter: res.RetryAfter}
+	if res.Burst > 0 {
+		info.Limit, info.Burst = res.Limit, res.Burst
+	}
+	return info
+}
+
+// writeDenial sends the response for a denied request, handing it to
+// OnLimitExceeded when one is configured
+func (rl *RateLimiter) writeDenial(w http.ResponseWriter, r *http.Request, info LimitInfo) {
+	if rl.config.OnLimitExceeded != nil {
+		rl.config.OnLimitExceeded(w, r, info)
+		return
+	}
+	if rl.config.HTMLTemplate != nil && acceptsHTML(r) && rl.writeHTMLDeny(w, info.RetryAfter) {
+		return
+	}
+	rl.writeRejection(w, info.RetryAfter)
+}
+
+// writeRejection writes the configured rejection status and body
+func (rl *RateLimiter) writeRejection(w http.ResponseWriter, wait time.Duration) {
+	status := rl.config.RejectionStatusCode
+	body := []byte(http.StatusText(status) + "\n")
+	if rl.config.RejectionBody != "" {
+		body = []byte(rl.config.RejectionBody)
+		var buf bytes.Buffer
+		if rl.rejectionBody != nil && rl.rejectionBody.Execute(&buf, DenyPageData{RetryAfter: retryAfterSeconds(wait)}) == nil {
+			body = buf.Bytes()
+		}
+	}
+	w.Header().Set("Content-Type", rl.config.RejectionContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
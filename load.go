@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// loadRecovery is the fraction of LoadThreshold the load must fall below
+// before limits are relaxed, so the controller doesn't flap around the
+// threshold
+const loadRecovery = 0.8
+
+// loadController tightens limits while the server is overloaded.
+//
+// The control loop is: every LoadInterval the LoadFunc signal is sampled.
+// While it is above LoadThreshold each sample raises the number of tokens a
+// request costs by one, up to LoadMaxPenalty, dividing every visitor's
+// effective rate further. Once it falls below loadRecovery of the threshold
+// each sample lowers the cost by one until requests cost a single token
+// again. In between the cost stays where it is.
+type loadController struct {
+	penalty atomic.Int64
+}
+
+// sample updates the penalty for one load reading, reporting whether it
+// changed
+func (lc *loadController) sample(load, threshold float64, maxPenalty int) bool {
+	penalty := lc.penalty.Load()
+	switch {
+	case load > threshold && penalty < int64(maxPenalty):
+		penalty = max(2, penalty+1)
+	case load < threshold*loadRecovery && penalty > 0:
+		penalty--
+		if penalty == 1 {
+			penalty = 0
+		}
+	default:
+		return false
+	}
+	lc.penalty.Store(penalty)
+	return true
+}
+
+// watchLoad samples LoadFunc every LoadInterval until Close is called
+func (rl *RateLimiter) watchLoad() {
+	ticker := rl.config.Clock.NewTicker(rl.config.LoadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			load := rl.config.LoadFunc()
+			if rl.load.sample(load, rl.config.LoadThreshold, rl.config.LoadMaxPenalty) && rl.config.Logger != nil {
+				rl.config.Logger.Info("ratelimiter: load penalty changed",
+					slog.Float64("load", load),
+					slog.Int("penalty", rl.LoadPenalty()),
+				)
+			}
+		case <-rl.done:
+			return
+		}
+	}
+}
+
+// LoadPenalty returns the number of tokens each request currently costs
+// because of server load, or zero while limits aren't tightened
+func (rl *RateLimiter) LoadPenalty() int {
+	if rl.load == nil {
+		return 0
+	}
+	return int(rl.load.penalty.Load())
+}
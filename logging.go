@@ -0,0 +1,63 @@
+package ratelimiter
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logDenial logs a request denied by a rate limit, or one that would have
+// been in dry run mode or during warmup
+func (rl *RateLimiter) logDenial(r *http.Request, info LimitInfo, enforced bool) {
+	if rl.config.Logger == nil {
+		return
+	}
+	msg := "ratelimiter: request throttled"
+	if !enforced {
+		msg = "ratelimiter: request would be throttled"
+	}
+	id := info.RequestID
+	if id == "" && rl.config.RequestIDHeader != "" {
+		// dry run denials send no response, so only a carried ID is known
+		id = r.Header.Get(rl.config.RequestIDHeader)
+	}
+	rl.config.Logger.LogAttrs(r.Context(), slog.LevelInfo, msg,
+		slog.String("key", info.Key),
+		slog.String("request_id", id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Float64("limit", float64(info.Limit)),
+		slog.Int("burst", info.Burst),
+		slog.Int("remaining", info.Remaining),
+		slog.Duration("retry_after", info.RetryAfter),
+		slog.Bool("global", info.Global),
+		slog.Bool("escalated", info.Escalated),
+	)
+}
+
+// logBan logs a key banned for repeated denials
+func (rl *RateLimiter) logBan(key string, until time.Time) {
+	if rl.config.Logger == nil {
+		return
+	}
+	rl.config.Logger.Warn("ratelimiter: key banned",
+		slog.String("key", key),
+		slog.Int("violations", rl.config.BanThreshold),
+		slog.Time("until", until),
+	)
+}
+
+// logStoreError logs a failed store operation, which is handled according
+// to the FailurePolicy
+func (rl *RateLimiter) logStoreError(op, key string, err error) {
+	if rl.config.Logger == nil {
+		return
+	}
+	policy, _ := rl.config.FailurePolicy.MarshalText()
+	rl.config.Logger.Warn("ratelimiter: store error",
+		slog.String("op", op),
+		slog.String("policy", string(policy)),
+		slog.String("key", key),
+		slog.Any("error", err),
+	)
+}
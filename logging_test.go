@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogDenialReportsRemaining(t *testing.T) {
+	var logs bytes.Buffer
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             5,
+		CostFunc:          func(*http.Request) int { return 3 },
+		Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != want {
+			t.Fatalf("got %d, want %d", w.Code, want)
+		}
+	}
+	if !strings.Contains(logs.String(), "remaining=2") {
+		t.Errorf("denial log doesn't report the 2 tokens left:\n%s", logs.String())
+	}
+}
+
+func TestDominantKeyWarnings(t *testing.T) {
+	var std bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&std)
+
+	for _, withLogger := range []bool{false, true} {
+		var logs bytes.Buffer
+		cfg := &Config{
+			RequestsPerSecond: 1000,
+			Burst:             1000,
+			DominantKeyShare:  0.5,
+			Clock:             NewManualClock(time.Unix(0, 0)),
+		}
+		if withLogger {
+			cfg.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+		}
+		rl := New(cfg)
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		for range 200 {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+		rl.Close()
+
+		if warned := strings.Contains(logs.String(), "dominant key"); warned != withLogger {
+			t.Errorf("with logger %v: warned = %v:\n%s", withLogger, warned, logs.String())
+		}
+	}
+	if std.Len() > 0 {
+		t.Errorf("the standard logger was written to:\n%s", std.String())
+	}
+}
+
+func TestNoDominantKeyWarningForEvenTraffic(t *testing.T) {
+	var logs bytes.Buffer
+	rl := New(&Config{
+		RequestsPerSecond: 1000,
+		Burst:             1000,
+		DominantKeyShare:  0.1,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+		Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	// 20 clients taking 5% each, half the share that counts as dominant
+	for i := range 1000 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i%20+1)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if strings.Contains(logs.String(), "dominant key") {
+		t.Errorf("warned about evenly spread traffic:\n%s", logs.String())
+	}
+}
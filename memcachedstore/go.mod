@@ -0,0 +1,15 @@
+module github.com/gigatar/ratelimiter/memcachedstore
+
+go 1.24.2
+
+require (
+	github.com/bradfitz/gomemcache v0.0.0-20260422231931-4d751bb6e37c
+	github.com/gigatar/ratelimiter v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/time v0.11.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/gigatar/ratelimiter => ../
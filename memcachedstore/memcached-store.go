@@ -0,0 +1,91 @@
+// Package memcachedstore provides a memcached backed ratelimiter.Store so
+// several instances of a service can share one set of token buckets.
+package memcachedstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gigatar/ratelimiter"
+)
+
+// maxKeyLength is the longest key memcached accepts
+const maxKeyLength = 250
+
+// kv adapts a memcache.Client to ratelimiter.KV using gets/cas
+type kv struct {
+	client *memcache.Client
+}
+
+// New creates a Store using client. Keys are stored under prefix, which lets
+// several limiters share one memcached without colliding.
+func New(client *memcache.Client, prefix string) *ratelimiter.KVStore {
+	return ratelimiter.NewKVStore(&kv{client: client}, prefix)
+}
+
+// Get implements ratelimiter.KV. The item itself is the version, as it
+// carries the CAS ID memcached compares against.
+func (m *kv) Get(ctx context.Context, key string) ([]byte, any, error) {
+	item, err := m.client.Get(safeKey(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return item.Value, item, nil
+}
+
+// CompareAndSwap implements ratelimiter.KV with add for new keys and cas for
+// existing ones
+func (m *kv) CompareAndSwap(ctx context.Context, key string, value []byte, version any, ttl time.Duration) (bool, error) {
+	var err error
+	if item, ok := version.(*memcache.Item); ok {
+		item.Value, item.Expiration = value, expiration(ttl)
+		err = m.client.CompareAndSwap(item)
+	} else {
+		err = m.client.Add(&memcache.Item{Key: safeKey(key), Value: value, Expiration: expiration(ttl)})
+	}
+	if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCacheMiss) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Touch implements ratelimiter.KV
+func (m *kv) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	err := m.client.Touch(safeKey(key), expiration(ttl))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// expiration converts ttl to memcached's whole seconds, never zero, which
+// would mean no expiry
+func expiration(ttl time.Duration) int32 {
+	return int32(max(1, (ttl+time.Second-1)/time.Second))
+}
+
+// safeKey returns key if memcached accepts it, otherwise its SHA-256, so
+// client identities with spaces or of any length can be stored
+func safeKey(key string) string {
+	if len(key) <= maxKeyLength {
+		valid := true
+		for i := 0; i < len(key); i++ {
+			if key[i] <= ' ' || key[i] == 0x7f {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return key
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
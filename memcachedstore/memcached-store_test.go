@@ -0,0 +1,133 @@
+package memcachedstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gigatar/ratelimiter"
+	"github.com/gigatar/ratelimiter/storetest"
+)
+
+func TestStoreConformance(t *testing.T) {
+	client := memcache.New(newTestServer(t))
+	client.MaxIdleConns = 64
+	storetest.Run(t, func(t *testing.T) ratelimiter.Store {
+		return New(client, t.Name()+":")
+	})
+}
+
+func TestSafeKey(t *testing.T) {
+	for _, key := range []string{"rl:10.0.0.1", "rl:user name", "rl:" + strings.Repeat("k", 300)} {
+		got := safeKey(key)
+		if len(got) > maxKeyLength || strings.ContainsAny(got, " \x00\x7f") {
+			t.Errorf("safeKey(%q) = %q, which memcached rejects", key, got)
+		}
+	}
+	if got := safeKey("rl:10.0.0.1"); got != "rl:10.0.0.1" {
+		t.Errorf("safeKey changed a valid key to %q", got)
+	}
+}
+
+// newTestServer starts the part of memcached's text protocol the store uses
+// (gets, add, cas and touch) and returns its address. Expiry is ignored.
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &testServer{items: map[string]testItem{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+type testServer struct {
+	mx    sync.Mutex
+	items map[string]testItem
+	cas   uint64
+}
+
+type testItem struct {
+	value []byte
+	cas   uint64
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			return
+		}
+		switch f[0] {
+		case "gets":
+			s.mx.Lock()
+			for _, key := range f[1:] {
+				if it, ok := s.items[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(it.value), it.cas, it.value)
+				}
+			}
+			s.mx.Unlock()
+			rw.WriteString("END\r\n")
+		case "add", "cas":
+			size, _ := strconv.Atoi(f[4])
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				return
+			}
+			rw.WriteString(s.store(f, value[:size]))
+		case "touch":
+			s.mx.Lock()
+			_, ok := s.items[f[1]]
+			s.mx.Unlock()
+			rw.WriteString(map[bool]string{false: "NOT_FOUND\r\n", true: "TOUCHED\r\n"}[ok])
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		if rw.Flush() != nil {
+			return
+		}
+	}
+}
+
+// store handles add and cas, returning the response line
+func (s *testServer) store(f []string, value []byte) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	it, exists := s.items[f[1]]
+	if f[0] == "add" && exists {
+		return "NOT_STORED\r\n"
+	}
+	if f[0] == "cas" {
+		if !exists {
+			return "NOT_FOUND\r\n"
+		}
+		if strconv.FormatUint(it.cas, 10) != f[5] {
+			return "EXISTS\r\n"
+		}
+	}
+	s.cas++
+	s.items[f[1]] = testItem{value: value, cas: s.cas}
+	return "STORED\r\n"
+}
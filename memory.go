@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"unsafe"
+
+	"golang.org/x/time/rate"
+)
+
+// averageKeyBytes is the allocation behind a typical key: an IPv4 address
+// of up to 15 characters
+const averageKeyBytes = 16
+
+// allocSize rounds n up to the allocator's 16 byte granularity
+func allocSize(n uintptr) int {
+	return int((n + 15) &^ 15)
+}
+
+// mapEntryBytes returns what one entry with slot bytes of key and value
+// costs a map on average. Maps keep a control byte per slot and double once
+// 7/8 full, so measured with runtime.ReadMemStats their slots are about 70%
+// used.
+func mapEntryBytes(slot uintptr) int {
+	return int((slot + 1) * 10 / 7)
+}
+
+// syncMapEntryBytes is what one visitor costs a sync.Map on average: its
+// entry node, the key boxed in an interface and its share of the 16-way
+// interior nodes of the hash trie, measured with runtime.ReadMemStats
+const syncMapEntryBytes = 48 + 16 + 60
+
+// EstimateMemory returns the approximate number of bytes used to track
+// numVisitors distinct visitors with the limiter's configuration, including
+// their quota usage and composite limit buckets. It is meant for capacity
+// planning; real usage varies with key length and map growth. State kept
+// only for misbehaving clients, such as bans and the penalty box, and the
+// capped idempotency and key caches aren't counted. With MaxVisitors set, no
+// more than that many visitors are counted.
+func (rl *RateLimiter) EstimateMemory(numVisitors int) int {
+	var key string
+	var ptr uintptr = unsafe.Sizeof(&visitor{})
+	perVisitor := averageKeyBytes + allocSize(unsafe.Sizeof(visitor{}))
+	if rl.config.SyncVisitorMap {
+		perVisitor += syncMapEntryBytes
+	} else {
+		perVisitor += mapEntryBytes(unsafe.Sizeof(key) + ptr)
+	}
+	if !rl.config.CountOnly {
+		perVisitor += allocSize(unsafe.Sizeof(rate.Limiter{}))
+	}
+	if rl.config.MaxVisitors > 0 {
+		// the LRU element and the key boxed in its Value
+		perVisitor += allocSize(unsafe.Sizeof(list.Element{})) + allocSize(unsafe.Sizeof(key))
+		numVisitors = min(numVisitors, rl.config.MaxVisitors)
+	}
+	if n := uintptr(len(rl.config.Quotas)); n > 0 {
+		var counts []quotaCount
+		perVisitor += mapEntryBytes(unsafe.Sizeof(key)+unsafe.Sizeof(counts)) + allocSize(n*unsafe.Sizeof(quotaCount{}))
+	}
+	if n := uintptr(len(rl.config.Limits)); n > 0 {
+		perVisitor += mapEntryBytes(unsafe.Sizeof(key)+ptr) + allocSize(unsafe.Sizeof(keyLimits{})) +
+			allocSize(n*ptr) + int(n)*allocSize(unsafe.Sizeof(rate.Limiter{}))
+	}
+	return numVisitors * perVisitor
+}
@@ -0,0 +1,52 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// heapInUse returns the bytes of live heap objects after a full collection
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestEstimateMemoryMatchesHeap(t *testing.T) {
+	const visitors = 50000
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "token bucket", cfg: Config{}},
+		{name: "sync visitor map", cfg: Config{SyncVisitorMap: true}},
+		{name: "count only", cfg: Config{CountOnly: true}},
+		{name: "max visitors", cfg: Config{MaxVisitors: 1 << 20}},
+		{name: "quotas", cfg: Config{Quotas: []Quota{{Limit: 100, Period: time.Hour}, {Limit: 1000, Period: 24 * time.Hour}}}},
+		{name: "composite limits", cfg: Config{Limits: []Limit{{Name: "hourly", Requests: 100, Period: time.Hour}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.RequestsPerSecond, cfg.Burst = 1, 5
+			cfg.Clock = NewManualClock(time.Unix(0, 0))
+			rl := New(&cfg)
+			defer rl.Close()
+
+			before := heapInUse()
+			for i := range visitors {
+				rl.Allow(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255))
+			}
+			used := float64(heapInUse() - before)
+			runtime.KeepAlive(rl)
+
+			estimate := float64(rl.EstimateMemory(visitors))
+			if ratio := estimate / used; ratio < 0.85 || ratio > 1.15 {
+				t.Errorf("estimated %.0f bytes per visitor, measured %.0f", estimate/visitors, used/visitors)
+			}
+		})
+	}
+}
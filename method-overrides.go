@@ -0,0 +1,32 @@
+package ratelimiter
+
+import "strings"
+
+// LimitSpec is a rate and burst used in place of the enclosing one
+type LimitSpec struct {
+	// RequestsPerSecond is the number of requests allowed per second.
+	// Defaults to the enclosing rate.
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is the maximum number of requests allowed in a burst. Defaults
+	// to the enclosing burst.
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// validateOverrides upper-cases the methods of overrides and fills in unset
+// rates and bursts from the enclosing limit
+func validateOverrides(overrides map[string]LimitSpec, rps float64, burst int) map[string]LimitSpec {
+	if len(overrides) == 0 {
+		return overrides
+	}
+	valid := make(map[string]LimitSpec, len(overrides))
+	for method, spec := range overrides {
+		if spec.RequestsPerSecond <= 0 {
+			spec.RequestsPerSecond = rps
+		}
+		if spec.Burst <= 0 {
+			spec.Burst = burst
+		}
+		valid[strings.ToUpper(method)] = spec
+	}
+	return valid
+}
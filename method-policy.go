@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MethodPolicy controls how requests with unusual HTTP methods are handled
+type MethodPolicy int
+
+const (
+	// MethodPolicyLimit limits unusual methods like any other request
+	MethodPolicyLimit MethodPolicy = iota
+	// MethodPolicyReject rejects unusual methods with 405 Method Not Allowed
+	MethodPolicyReject
+	// MethodPolicyExempt passes unusual methods through without limiting
+	MethodPolicyExempt
+)
+
+// isUnusualMethod reports whether method is TRACE, CONNECT or non-standard
+func isUnusualMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// MarshalText encodes the policy as "limit", "reject" or "exempt"
+func (p MethodPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case MethodPolicyLimit:
+		return []byte("limit"), nil
+	case MethodPolicyReject:
+		return []byte("reject"), nil
+	case MethodPolicyExempt:
+		return []byte("exempt"), nil
+	}
+	return nil, fmt.Errorf("ratelimiter: unknown method policy %d", int(p))
+}
+
+// UnmarshalText decodes "limit", "reject" or "exempt" so policies can be
+// written by name in config files
+func (p *MethodPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "limit":
+		*p = MethodPolicyLimit
+	case "reject":
+		*p = MethodPolicyReject
+	case "exempt":
+		*p = MethodPolicyExempt
+	default:
+		return fmt.Errorf("ratelimiter: unknown method policy %q", text)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceUnderEachMethodPolicy(t *testing.T) {
+	tests := []struct {
+		policy MethodPolicy
+		want   int // status of a TRACE once the client's bucket is empty
+	}{
+		{MethodPolicyLimit, http.StatusTooManyRequests},
+		{MethodPolicyReject, http.StatusMethodNotAllowed},
+		{MethodPolicyExempt, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rl := New(&Config{
+			RequestsPerSecond:   0.001,
+			Burst:               1,
+			UnusualMethodPolicy: tt.policy,
+			Clock:               NewManualClock(time.Unix(0, 0)),
+		})
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		for range 2 {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodTrace, "/", nil))
+			if rec.Code != tt.want {
+				name, _ := tt.policy.MarshalText()
+				t.Errorf("%s: TRACE status %d, want %d", name, rec.Code, tt.want)
+			}
+		}
+		rl.Close()
+	}
+}
+
+func TestMethodPolicyText(t *testing.T) {
+	for _, p := range []MethodPolicy{MethodPolicyLimit, MethodPolicyReject, MethodPolicyExempt} {
+		text, err := p.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got MethodPolicy
+		if err := got.UnmarshalText(text); err != nil || got != p {
+			t.Errorf("%s round-tripped to %d, %v", text, got, err)
+		}
+	}
+	var p MethodPolicy
+	if err := p.UnmarshalText([]byte("allow")); err == nil {
+		t.Error("unknown policy name accepted")
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+// NetworkLimit holds the limits for clients within an IP range, such as a
+// partner's egress range or a known scraper's network
+type NetworkLimit struct {
+	// CIDR is the range, e.g. "10.0.0.0/8". A bare IP covers one address.
+	CIDR string `json:"cidr" yaml:"cidr" toml:"cidr"`
+	// RequestsPerSecond is the number of requests allowed per second.
+	// Defaults to Config.RequestsPerSecond.
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is the maximum number of requests allowed in a burst. Defaults
+	// to Config.Burst.
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// networkLimit is a NetworkLimit with its range parsed
+type networkLimit struct {
+	NetworkLimit
+	prefix netip.Prefix
+}
+
+// newNetworkLimits parses limits, most specific range first so the first
+// match is the longest prefix match
+func newNetworkLimits(limits []NetworkLimit) []networkLimit {
+	var networks []networkLimit
+	for _, limit := range limits {
+		if prefix, err := parsePrefix(limit.CIDR); err == nil {
+			networks = append(networks, networkLimit{NetworkLimit: limit, prefix: prefix})
+		}
+	}
+	slices.SortStableFunc(networks, func(a, b networkLimit) int {
+		return cmp.Compare(b.prefix.Bits(), a.prefix.Bits())
+	})
+	return networks
+}
+
+// matchNetwork returns the most specific NetworkLimit covering r's peer IP,
+// or nil if none does. Like the allow and deny lists it only looks behind
+// trusted proxies, so a forged X-Forwarded-For can't claim a range.
+func (rl *RateLimiter) matchNetwork(r *http.Request) *networkLimit {
+	if len(rl.networks) == 0 {
+		return nil
+	}
+	addr, err := netip.ParseAddr(rl.peerIP(r))
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	for i := range rl.networks {
+		if rl.networks[i].prefix.Contains(addr) {
+			return &rl.networks[i]
+		}
+	}
+	return nil
+}
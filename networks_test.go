@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNetworkLimitsIgnoreSpoofedForwardedFor(t *testing.T) {
+	for _, trusted := range []bool{false, true} {
+		cfg := &Config{
+			RequestsPerSecond: 0.001,
+			Burst:             1,
+			NetworkLimits:     []NetworkLimit{{CIDR: "10.0.0.0/8", RequestsPerSecond: 100, Burst: 100}},
+			Clock:             NewManualClock(time.Unix(0, 0)),
+		}
+		if trusted {
+			cfg.TrustedProxies = []string{"192.0.2.0/24"}
+		}
+		rl := New(cfg)
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		allowed := 0
+		for range 3 {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Forwarded-For", "10.0.0.1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				allowed++
+			}
+		}
+		rl.Close()
+
+		// only a trusted proxy may vouch for the internal address
+		if want := map[bool]int{false: 1, true: 3}[trusted]; allowed != want {
+			t.Errorf("trusted proxy %v: %d requests allowed, want %d", trusted, allowed, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OffenderStat describes the denials of one of the keys denied most often
+type OffenderStat struct {
+	Key string `json:"key"`
+	// Denials is the number of requests denied within OffenderWindow,
+	// estimated like the sliding window counter algorithm
+	Denials uint64 `json:"denials"`
+	// FirstDenied is the first denial since the key started being tracked.
+	// Keys are forgotten once they go a whole OffenderWindow without one.
+	FirstDenied time.Time `json:"first_denied"`
+	// LastDenied is the most recent denial
+	LastDenied time.Time `json:"last_denied"`
+}
+
+// offense is a key's denials in the current and previous window
+type offense struct {
+	start       time.Time // start of the current window
+	count, prev uint64
+	first, last time.Time
+}
+
+// offenderTracker counts denials per client over a rolling window. Like
+// bans, the counts apply to a client as a whole and may span longer than
+// MaxIdleTime, so they are kept apart from the visitors.
+type offenderTracker struct {
+	window time.Duration
+	mx     sync.Mutex
+	keys   map[string]*offense
+}
+
+func newOffenderTracker(window time.Duration) *offenderTracker {
+	return &offenderTracker{window: window, keys: make(map[string]*offense)}
+}
+
+// roll moves o's windows forward to now
+func (ot *offenderTracker) roll(o *offense, now time.Time) {
+	start := now.Truncate(ot.window)
+	switch {
+	case start.Equal(o.start):
+	case start.Sub(o.start) == ot.window:
+		o.start, o.prev, o.count = start, o.count, 0
+	default:
+		o.start, o.prev, o.count = start, 0, 0
+	}
+}
+
+// record counts a denial for key at now
+func (ot *offenderTracker) record(key string, now time.Time) {
+	ot.mx.Lock()
+	defer ot.mx.Unlock()
+
+	o := ot.keys[key]
+	if o == nil {
+		o = &offense{start: now.Truncate(ot.window), first: now}
+		ot.keys[key] = o
+	}
+	ot.roll(o, now)
+	o.count++
+	o.last = now
+}
+
+// top returns up to n keys with the most denials at now, most first
+func (ot *offenderTracker) top(n int, now time.Time) []OffenderStat {
+	ot.mx.Lock()
+	stats := make([]OffenderStat, 0, len(ot.keys))
+	for key, o := range ot.keys {
+		ot.roll(o, now)
+		elapsed := float64(now.Sub(o.start)) / float64(ot.window)
+		denials := uint64(math.Round(float64(o.count) + float64(o.prev)*(1-elapsed)))
+		if denials > 0 {
+			stats = append(stats, OffenderStat{Key: key, Denials: denials, FirstDenied: o.first, LastDenied: o.last})
+		}
+	}
+	ot.mx.Unlock()
+
+	slices.SortFunc(stats, func(a, b OffenderStat) int {
+		if c := cmp.Compare(b.Denials, a.Denials); c != 0 {
+			return c
+		}
+		return b.LastDenied.Compare(a.LastDenied)
+	})
+	if n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// reset forgets key's denials
+func (ot *offenderTracker) reset(key string) {
+	ot.mx.Lock()
+	defer ot.mx.Unlock()
+	delete(ot.keys, key)
+}
+
+// expire forgets keys without denials in the last window
+func (ot *offenderTracker) expire(now time.Time) {
+	ot.mx.Lock()
+	defer ot.mx.Unlock()
+
+	for key, o := range ot.keys {
+		if now.Sub(o.last) >= ot.window {
+			delete(ot.keys, key)
+		}
+	}
+}
+
+// recordOffense counts a denial for client key when offenders are tracked
+func (rl *RateLimiter) recordOffense(key string, now time.Time) {
+	if rl.offenders != nil {
+		rl.offenders.record(key, now)
+	}
+}
+
+// TopOffenders returns up to n of the keys denied most often within
+// OffenderWindow, most first. Keys are clients as stored, after KeySecret
+// and HashBuckets. It returns nil unless OffenderWindow is set.
+func (rl *RateLimiter) TopOffenders(n int) []OffenderStat {
+	if rl.offenders == nil || n <= 0 {
+		return nil
+	}
+	return rl.offenders.top(n, rl.now())
+}
+
+// reportOffenders passes the top offenders to OnOffenders every
+// OffenderReportInterval until Close is called. Nothing is reported while no
+// key has been denied within OffenderWindow.
+func (rl *RateLimiter) reportOffenders() {
+	ticker := rl.config.Clock.NewTicker(rl.config.OffenderReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if stats := rl.TopOffenders(rl.config.OffenderReportSize); len(stats) > 0 {
+				rl.config.OnOffenders(stats)
+			}
+		case <-rl.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Option configures a RateLimiter built with NewWithOptions. Unlike setting
+// Config fields, whose invalid values Validate quietly replaces, an Option
+// rejects bad values with an error.
+type Option func(*Config) error
+
+// NewWithOptions creates a RateLimiter from DefaultConfig with opts applied
+// in order. Invalid values and conflicting options are returned as errors
+// rather than corrected:
+//
+//	limiter, err := ratelimiter.NewWithOptions(
+//		ratelimiter.WithRate(10),
+//		ratelimiter.WithBurst(20),
+//		ratelimiter.WithKeyFunc(apiKey),
+//	)
+func NewWithOptions(opts ...Option) (*RateLimiter, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if _, local := cfg.Store.(*MemoryStore); cfg.Store != nil && !local && cfg.Algorithm != AlgorithmTokenBucket && cfg.Algorithm != AlgorithmGCRA {
+		return nil, errors.New("ratelimiter: window based algorithms need the MemoryStore")
+	}
+	return New(cfg), nil
+}
+
+// WithRate sets the number of requests allowed per second
+func WithRate(rps float64) Option {
+	return func(c *Config) error {
+		if rps <= 0 {
+			return fmt.Errorf("ratelimiter: rate must be positive, got %v", rps)
+		}
+		c.RequestsPerSecond = rps
+		return nil
+	}
+}
+
+// WithBurst sets the maximum number of requests allowed in a burst
+func WithBurst(burst int) Option {
+	return func(c *Config) error {
+		if burst <= 0 {
+			return fmt.Errorf("ratelimiter: burst must be positive, got %d", burst)
+		}
+		c.Burst = burst
+		return nil
+	}
+}
+
+// WithStore sets where token buckets are kept
+func WithStore(store Store) Option {
+	return func(c *Config) error {
+		if store == nil {
+			return errors.New("ratelimiter: store must not be nil")
+		}
+		c.Store = store
+		return nil
+	}
+}
+
+// WithKeyFunc sets the function extracting the key requests are limited by
+func WithKeyFunc(fn func(*http.Request) string) Option {
+	return func(c *Config) error {
+		if fn == nil {
+			return errors.New("ratelimiter: key func must not be nil")
+		}
+		c.KeyFunc = fn
+		return nil
+	}
+}
+
+// WithAlgorithm sets how requests are counted. window is the window length
+// for the window based algorithms; zero uses Burst / RequestsPerSecond.
+func WithAlgorithm(algorithm Algorithm, window time.Duration) Option {
+	return func(c *Config) error {
+		if _, err := algorithm.MarshalText(); err != nil {
+			return err
+		}
+		if window < 0 {
+			return fmt.Errorf("ratelimiter: window must not be negative, got %v", window)
+		}
+		c.Algorithm, c.Window = algorithm, window
+		return nil
+	}
+}
+
+// WithCleanup sets how often idle visitors are removed and how long a
+// visitor may be idle before it is
+func WithCleanup(interval, maxIdle time.Duration) Option {
+	return func(c *Config) error {
+		if interval < time.Second {
+			return fmt.Errorf("ratelimiter: cleanup interval must be at least 1s, got %v", interval)
+		}
+		if maxIdle <= 0 {
+			return fmt.Errorf("ratelimiter: max idle time must be positive, got %v", maxIdle)
+		}
+		c.CleanupInterval, c.MaxIdleTime = interval, maxIdle
+		return nil
+	}
+}
+
+// WithRoutes adds routes with their own rate and burst
+func WithRoutes(routes ...Route) Option {
+	return func(c *Config) error {
+		for _, route := range routes {
+			if route.Pattern == "" {
+				return errors.New("ratelimiter: route pattern must not be empty")
+			}
+			if route.RequestsPerSecond < 0 || route.Burst < 0 {
+				return fmt.Errorf("ratelimiter: route %q has a negative rate or burst", route.Pattern)
+			}
+		}
+		c.Routes = append(c.Routes, routes...)
+		return nil
+	}
+}
+
+// WithGlobalLimit caps the total rate across all clients
+func WithGlobalLimit(rps float64, burst int) Option {
+	return func(c *Config) error {
+		if rps <= 0 || burst <= 0 {
+			return fmt.Errorf("ratelimiter: global rate and burst must be positive, got %v and %d", rps, burst)
+		}
+		c.GlobalRequestsPerSecond, c.GlobalBurst = rps, burst
+		return nil
+	}
+}
+
+// WithTrustedProxies sets the proxies whose forwarding headers are honoured
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(c *Config) error {
+		for _, cidr := range cidrs {
+			if _, err := parsePrefix(cidr); err != nil {
+				return err
+			}
+		}
+		c.TrustedProxies = append(c.TrustedProxies, cidrs...)
+		return nil
+	}
+}
+
+// WithMaxVisitors caps the number of tracked keys
+func WithMaxVisitors(n int) Option {
+	return func(c *Config) error {
+		if n <= 0 {
+			return fmt.Errorf("ratelimiter: max visitors must be positive, got %d", n)
+		}
+		c.MaxVisitors = n
+		return nil
+	}
+}
+
+// WithClock replaces the system clock
+func WithClock(clock Clock) Option {
+	return func(c *Config) error {
+		if clock == nil {
+			return errors.New("ratelimiter: clock must not be nil")
+		}
+		c.Clock = clock
+		return nil
+	}
+}
+
+// WithLogger sets the logger throttled requests, bans, cleanup sweeps and
+// store errors are logged to
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) error {
+		if logger == nil {
+			return errors.New("ratelimiter: logger must not be nil")
+		}
+		c.Logger = logger
+		return nil
+	}
+}
+
+// WithFailurePolicy sets what happens to requests while the store fails
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(c *Config) error {
+		if _, err := policy.MarshalText(); err != nil {
+			return err
+		}
+		c.FailurePolicy = policy
+		return nil
+	}
+}
+
+// WithQuotas adds per-period request quotas, such as 10,000 per day
+func WithQuotas(quotas ...Quota) Option {
+	return func(c *Config) error {
+		for _, q := range quotas {
+			if q.Limit <= 0 || q.Period <= 0 {
+				return fmt.Errorf("ratelimiter: quota limit and period must be positive, got %d per %v", q.Limit, q.Period)
+			}
+		}
+		c.Quotas = append(c.Quotas, quotas...)
+		return nil
+	}
+}
+
+// WithLimits adds further rates every client must pass, such as 1000 per
+// hour and 5000 per day
+func WithLimits(limits ...Limit) Option {
+	return func(c *Config) error {
+		for _, l := range limits {
+			if l.Requests <= 0 || l.Period <= 0 || l.Burst < 0 {
+				return fmt.Errorf("ratelimiter: limit %d per %v with burst %d is invalid", l.Requests, l.Period, l.Burst)
+			}
+		}
+		c.Limits = append(c.Limits, limits...)
+		return nil
+	}
+}
+
+// WithDimensions limits requests by further keys at once, each at its own
+// rate, such as per account and per IP
+func WithDimensions(dims ...Dimension) Option {
+	return func(c *Config) error {
+		for _, d := range dims {
+			if d.KeyFunc == nil {
+				return fmt.Errorf("ratelimiter: dimension %q has no key func", d.Name)
+			}
+			if d.RequestsPerSecond <= 0 || d.Burst < 0 {
+				return fmt.Errorf("ratelimiter: dimension %q has an invalid rate or burst", d.Name)
+			}
+		}
+		c.Dimensions = append(c.Dimensions, dims...)
+		return nil
+	}
+}
+
+// WithBodyCost charges a token per started bytesPerToken of a request's
+// body, up to maxCost tokens; zero leaves the cost uncapped
+func WithBodyCost(bytesPerToken int64, maxCost int) Option {
+	return func(c *Config) error {
+		if bytesPerToken <= 0 {
+			return fmt.Errorf("ratelimiter: bytes per token must be positive, got %d", bytesPerToken)
+		}
+		if maxCost < 0 {
+			return fmt.Errorf("ratelimiter: max body cost must not be negative, got %d", maxCost)
+		}
+		c.BodyCostBytes, c.MaxBodyCost = bytesPerToken, maxCost
+		return nil
+	}
+}
+
+// WithOffenders tracks the clients denied most often over window, for
+// TopOffenders
+func WithOffenders(window time.Duration) Option {
+	return func(c *Config) error {
+		if window <= 0 {
+			return fmt.Errorf("ratelimiter: offender window must be positive, got %v", window)
+		}
+		c.OffenderWindow = window
+		return nil
+	}
+}
+
+// WithOffenderReports calls fn with up to n top offenders every interval
+func WithOffenderReports(interval time.Duration, n int, fn func([]OffenderStat)) Option {
+	return func(c *Config) error {
+		if fn == nil {
+			return errors.New("ratelimiter: offender report func must not be nil")
+		}
+		if interval < time.Second {
+			return fmt.Errorf("ratelimiter: offender report interval must be at least 1s, got %v", interval)
+		}
+		if n <= 0 {
+			return fmt.Errorf("ratelimiter: offender report size must be positive, got %d", n)
+		}
+		c.OnOffenders, c.OffenderReportInterval, c.OffenderReportSize = fn, interval, n
+		return nil
+	}
+}
+
+// WithBypassSecret exempts requests carrying a bypass header signed with
+// secret by SignBypass
+func WithBypassSecret(secret []byte) Option {
+	return func(c *Config) error {
+		if len(secret) == 0 {
+			return errors.New("ratelimiter: bypass secret must not be empty")
+		}
+		c.BypassSecret = secret
+		return nil
+	}
+}
+
+// WithRetryAfterJitter randomly lengthens or shortens Retry-After and
+// RateLimit-Reset by up to fraction of their value
+func WithRetryAfterJitter(fraction float64) Option {
+	return func(c *Config) error {
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("ratelimiter: retry-after jitter must be in (0, 1], got %v", fraction)
+		}
+		c.RetryAfterJitter = fraction
+		return nil
+	}
+}
+
+// WithOverrideTTL keeps visitors given their own limit by SetVisitorLimit
+// for ttl, however long they are idle
+func WithOverrideTTL(ttl time.Duration) Option {
+	return func(c *Config) error {
+		if ttl <= 0 {
+			return fmt.Errorf("ratelimiter: override TTL must be positive, got %v", ttl)
+		}
+		c.OverrideTTL = ttl
+		return nil
+	}
+}
+
+// WithLoad tightens limits while fn, sampled every second, reports a load
+// above threshold
+func WithLoad(fn func() float64, threshold float64) Option {
+	return func(c *Config) error {
+		if fn == nil {
+			return errors.New("ratelimiter: load func must not be nil")
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("ratelimiter: load threshold must be positive, got %v", threshold)
+		}
+		c.LoadFunc, c.LoadThreshold = fn, threshold
+		return nil
+	}
+}
+
+// WithVisitorHooks calls onNew when a key is first tracked and onEvict when
+// it is removed. Either may be nil.
+func WithVisitorHooks(onNew, onEvict func(VisitorEvent)) Option {
+	return func(c *Config) error {
+		if onNew == nil && onEvict == nil {
+			return errors.New("ratelimiter: visitor hooks must not both be nil")
+		}
+		c.OnNewVisitor, c.OnEvict = onNew, onEvict
+		return nil
+	}
+}
+
+// WithNetworkLimits gives clients within IP ranges their own limits
+func WithNetworkLimits(limits ...NetworkLimit) Option {
+	return func(c *Config) error {
+		for _, limit := range limits {
+			if _, err := parsePrefix(limit.CIDR); err != nil {
+				return err
+			}
+		}
+		c.NetworkLimits = append(c.NetworkLimits, limits...)
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+// Package otellimit reports a ratelimiter's decisions to OpenTelemetry.
+//
+// Denied requests are recorded on the request's span and in a retry-after
+// histogram, and the limiter's Metrics are exposed as observable instruments
+// mirroring the prommetrics set. Providers default to the global ones from
+// the otel package.
+package otellimit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gigatar/ratelimiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scope is the instrumentation scope name of the tracer and meter
+const scope = "github.com/gigatar/ratelimiter/otellimit"
+
+// Option configures Instrument and RegisterMetrics
+type Option func(*options)
+
+type options struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	name           string
+}
+
+// WithTracerProvider sets the TracerProvider used when a denied request has
+// no recording span of its own
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tracerProvider = tp }
+}
+
+// WithMeterProvider sets the MeterProvider instruments are created from
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) { o.meterProvider = mp }
+}
+
+// WithName labels every metric with a limiter attribute, so several limiters
+// can report side by side
+func WithName(name string) Option {
+	return func(o *options) { o.name = name }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	if o.meterProvider == nil {
+		o.meterProvider = otel.GetMeterProvider()
+	}
+	return o
+}
+
+// Instrument chains onto cfg.OnDeny so every denied request adds a
+// "ratelimiter.throttled" event to the span in its context and records its
+// retry-after in the ratelimiter.retry_after histogram. Requests without a
+// recording span get a short span of their own. Call it before New:
+//
+//	cfg := &ratelimiter.Config{RequestsPerSecond: 10, Burst: 20}
+//	if err := otellimit.Instrument(cfg); err != nil {
+//		return err
+//	}
+//	limiter := ratelimiter.New(cfg)
+func Instrument(cfg *ratelimiter.Config, opts ...Option) error {
+	o := newOptions(opts)
+	tracer := o.tracerProvider.Tracer(scope)
+	retryAfter, err := o.meterProvider.Meter(scope).Float64Histogram(
+		"ratelimiter.retry_after",
+		metric.WithDescription("Retry-After sent with denied requests."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return err
+	}
+	limiterAttr := attribute.String("limiter", o.name)
+
+	next := cfg.OnDeny
+	cfg.OnDeny = func(r *http.Request, info ratelimiter.LimitInfo) {
+		attrs := []attribute.KeyValue{
+			attribute.String("ratelimiter.key", info.Key),
+			attribute.Float64("ratelimiter.limit", float64(info.Limit)),
+			attribute.Int("ratelimiter.burst", info.Burst),
+			attribute.Float64("ratelimiter.retry_after", info.RetryAfter.Seconds()),
+			attribute.Bool("ratelimiter.global", info.Global),
+		}
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetAttributes(attribute.Bool("ratelimiter.throttled", true))
+			span.AddEvent("ratelimiter.throttled", trace.WithAttributes(attrs...))
+		} else {
+			_, span := tracer.Start(r.Context(), "ratelimiter.throttled", trace.WithAttributes(attrs...))
+			span.End()
+		}
+		retryAfter.Record(r.Context(), info.RetryAfter.Seconds(), metric.WithAttributes(limiterAttr))
+		if next != nil {
+			next(r, info)
+		}
+	}
+	return nil
+}
+
+// RegisterMetrics exposes rl's Metrics as observable instruments, read on
+// every collection:
+//
+//   - ratelimiter.requests.allowed and ratelimiter.requests.denied, counters
+//     with a route attribute
+//   - ratelimiter.active_visitors, a gauge of keys tracked in memory
+//   - ratelimiter.cleanup.duration, a gauge of the most recent cleanup run
+//   - ratelimiter.store.errors, a counter of failed store operations
+//   - ratelimiter.store.degraded, 1 while the store fails, else 0
+//
+// Unregister the returned Registration to stop reporting.
+func RegisterMetrics(rl *ratelimiter.RateLimiter, opts ...Option) (metric.Registration, error) {
+	o := newOptions(opts)
+	meter := o.meterProvider.Meter(scope)
+
+	allowed, err := meter.Int64ObservableCounter(
+		"ratelimiter.requests.allowed",
+		metric.WithDescription("Requests allowed by the rate limiter."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := meter.Int64ObservableCounter(
+		"ratelimiter.requests.denied",
+		metric.WithDescription("Requests denied by the rate limiter, including would-be denials during warmup."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	visitors, err := meter.Int64ObservableGauge(
+		"ratelimiter.active_visitors",
+		metric.WithDescription("Keys currently tracked in memory."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cleanup, err := meter.Float64ObservableGauge(
+		"ratelimiter.cleanup.duration",
+		metric.WithDescription("Duration of the most recent cleanup run."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	storeErrors, err := meter.Int64ObservableCounter(
+		"ratelimiter.store.errors",
+		metric.WithDescription("Failed store operations."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	degraded, err := meter.Int64ObservableGauge(
+		"ratelimiter.store.degraded",
+		metric.WithDescription("1 while the store fails and requests are handled by the failure policy, else 0."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	limiterAttr := attribute.String("limiter", o.name)
+	return meter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		m := rl.Metrics()
+		for route, counts := range m.Decisions {
+			attrs := metric.WithAttributes(limiterAttr, attribute.String("route", route))
+			obs.ObserveInt64(allowed, int64(counts.Allowed), attrs)
+			obs.ObserveInt64(denied, int64(counts.Denied), attrs)
+		}
+		obs.ObserveInt64(visitors, int64(m.Visitors), metric.WithAttributes(limiterAttr))
+		obs.ObserveFloat64(cleanup, m.LastCleanup.Seconds(), metric.WithAttributes(limiterAttr))
+		obs.ObserveInt64(storeErrors, int64(m.StoreErrors), metric.WithAttributes(limiterAttr))
+		var d int64
+		if m.Degraded {
+			d = 1
+		}
+		obs.ObserveInt64(degraded, d, metric.WithAttributes(limiterAttr))
+		return nil
+	}, allowed, denied, visitors, cleanup, storeErrors, degraded)
+}
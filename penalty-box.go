@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// penalty is what the penalty box keeps of an evicted visitor
+type penalty struct {
+	key                string
+	limit              rate.Limit
+	burst              int
+	custom             bool
+	consecutiveDenials int
+	denials            uint64
+	lastSeen           time.Time
+}
+
+// penaltyBox remembers the limits and denial history of evicted visitors
+// that had been denied or given a limit of their own, for longer than
+// MaxIdleTime, so a client going quiet doesn't wipe its record. It holds at
+// most size of them, dropping the least recently evicted first.
+type penaltyBox struct {
+	size int
+	ttl  time.Duration
+
+	mx      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newPenaltyBox(size int, ttl time.Duration) *penaltyBox {
+	return &penaltyBox{size: size, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// keep records visitor v of key as it is evicted, if it has a record worth
+// keeping
+func (pb *penaltyBox) keep(key string, v *visitor) {
+	if v.limiter == nil || v.denials == 0 && !v.custom {
+		return
+	}
+	p := &penalty{
+		key:                key,
+		limit:              v.limiter.Limit(),
+		burst:              v.limiter.Burst(),
+		custom:             v.custom,
+		consecutiveDenials: v.consecutiveDenials,
+		denials:            v.denials,
+		lastSeen:           v.lastSeen.Load(),
+	}
+
+	pb.mx.Lock()
+	defer pb.mx.Unlock()
+	if elem, ok := pb.entries[key]; ok {
+		elem.Value = p
+		pb.lru.MoveToFront(elem)
+		return
+	}
+	pb.entries[key] = pb.lru.PushFront(p)
+	if pb.lru.Len() > pb.size {
+		oldest := pb.lru.Back()
+		pb.lru.Remove(oldest)
+		delete(pb.entries, oldest.Value.(*penalty).key)
+	}
+}
+
+// restore gives v, just created for key, the record key left behind when
+// it was evicted within the retention period
+func (pb *penaltyBox) restore(key string, v *visitor, now time.Time) {
+	pb.mx.Lock()
+	elem, ok := pb.entries[key]
+	if ok {
+		pb.lru.Remove(elem)
+		delete(pb.entries, key)
+	}
+	pb.mx.Unlock()
+	if !ok {
+		return
+	}
+	p := elem.Value.(*penalty)
+	if now.Sub(p.lastSeen) >= pb.ttl {
+		return
+	}
+	if p.custom {
+		v.limiter = rate.NewLimiter(p.limit, p.burst)
+		v.custom = true
+	}
+	v.consecutiveDenials = p.consecutiveDenials
+	v.denials = p.denials
+}
+
+// len returns the number of records kept
+func (pb *penaltyBox) len() int {
+	pb.mx.Lock()
+	defer pb.mx.Unlock()
+	return len(pb.entries)
+}
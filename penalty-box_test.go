@@ -0,0 +1,82 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newPenaltyLimiter(t *testing.T, size int) (*RateLimiter, *ManualClock) {
+	t.Helper()
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 10,
+		Burst:             10,
+		MaxIdleTime:       time.Minute,
+		PenaltyBoxSize:    size,
+		PenaltyBoxTTL:     time.Hour,
+		Clock:             clock,
+	})
+	t.Cleanup(func() { rl.Close() })
+	return rl, clock
+}
+
+// evictIdle advances the clock past MaxIdleTime and runs the cleanup
+func evictIdle(rl *RateLimiter, clock *ManualClock) {
+	clock.Advance(2 * rl.config.MaxIdleTime)
+	rl.memory.Cleanup(context.Background(), rl.config.MaxIdleTime)
+}
+
+func TestPenaltyBoxRestoresStricterLimit(t *testing.T) {
+	rl, clock := newPenaltyLimiter(t, 10)
+	rl.SetVisitorLimit("abuser", 0.01, 1)
+	rl.Allow("abuser")
+	rl.Allow("abuser")
+
+	evictIdle(rl, clock)
+	if _, ok := rl.VisitorInfo("abuser"); ok {
+		t.Fatal("abuser should have been evicted")
+	}
+	if !rl.Allow("abuser") {
+		t.Fatal("returning abuser's first request should fit its burst of 1")
+	}
+	if rl.Allow("abuser") {
+		t.Error("returning abuser got a fresh default bucket instead of its stricter limit")
+	}
+	info, _ := rl.VisitorInfo("abuser")
+	if info.Limit != 0.01 || info.Denials != 2 {
+		t.Errorf("returning abuser has limit %v and %d denials, want 0.01 and 2", info.Limit, info.Denials)
+	}
+}
+
+func TestPenaltyBoxExpiresAndIsCapped(t *testing.T) {
+	rl, clock := newPenaltyLimiter(t, 1)
+	for _, id := range []string{"a", "b"} {
+		rl.SetVisitorLimit(id, 0.01, 1)
+		rl.Allow(id)
+		rl.Allow(id)
+	}
+	rl.Allow("clean")
+	evictIdle(rl, clock)
+	if n := rl.penalties.len(); n != 1 {
+		t.Fatalf("penalty box holds %d records, want its cap of 1", n)
+	}
+
+	clock.Advance(2 * time.Hour)
+	rl.Allow("a")
+	rl.Allow("b")
+	for _, id := range []string{"a", "b"} {
+		if info, _ := rl.VisitorInfo(id); info.Limit != 10 {
+			t.Errorf("%s came back with limit %v after its record expired or was dropped, want the default", id, info.Limit)
+		}
+	}
+}
+
+func TestPenaltyBoxSkipsResets(t *testing.T) {
+	rl, _ := newPenaltyLimiter(t, 10)
+	rl.SetVisitorLimit("a", 0.01, 1)
+	rl.ResetVisitor("a")
+	if n := rl.penalties.len(); n != 0 {
+		t.Errorf("reset left %d records in the penalty box", n)
+	}
+}
@@ -0,0 +1,90 @@
+// Package prommetrics exports a ratelimiter's metrics to Prometheus.
+package prommetrics
+
+import (
+	"github.com/gigatar/ratelimiter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	allowedDesc = prometheus.NewDesc(
+		"ratelimiter_requests_allowed_total",
+		"Requests allowed by the rate limiter.",
+		[]string{"limiter", "route"}, nil,
+	)
+	deniedDesc = prometheus.NewDesc(
+		"ratelimiter_requests_denied_total",
+		"Requests denied by the rate limiter, including would-be denials during warmup.",
+		[]string{"limiter", "route"}, nil,
+	)
+	visitorsDesc = prometheus.NewDesc(
+		"ratelimiter_active_visitors",
+		"Keys currently tracked in memory.",
+		[]string{"limiter"}, nil,
+	)
+	shardSkewDesc = prometheus.NewDesc(
+		"ratelimiter_shard_skew",
+		"Visitors in the fullest in-memory shard relative to the average shard.",
+		[]string{"limiter"}, nil,
+	)
+	cleanupDesc = prometheus.NewDesc(
+		"ratelimiter_cleanup_duration_seconds",
+		"Duration of the most recent cleanup run.",
+		[]string{"limiter"}, nil,
+	)
+	storeErrorsDesc = prometheus.NewDesc(
+		"ratelimiter_store_errors_total",
+		"Failed store operations.",
+		[]string{"limiter"}, nil,
+	)
+	degradedDesc = prometheus.NewDesc(
+		"ratelimiter_store_degraded",
+		"1 while the store fails and requests are handled by the failure policy, else 0.",
+		[]string{"limiter"}, nil,
+	)
+)
+
+// Collector is a prometheus.Collector reading a limiter's Metrics on every
+// scrape. Decisions are labelled with the route pattern they matched, or an
+// empty route for requests matching none.
+type Collector struct {
+	limiter *ratelimiter.RateLimiter
+	name    string
+}
+
+// NewCollector returns a Collector for rl, labelling every metric with name
+// so several limiters can be registered side by side:
+//
+//	prometheus.MustRegister(prommetrics.NewCollector(limiter, "api"))
+func NewCollector(rl *ratelimiter.RateLimiter, name string) *Collector {
+	return &Collector{limiter: rl, name: name}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- allowedDesc
+	ch <- deniedDesc
+	ch <- visitorsDesc
+	ch <- shardSkewDesc
+	ch <- cleanupDesc
+	ch <- storeErrorsDesc
+	ch <- degradedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.limiter.Metrics()
+	for route, counts := range m.Decisions {
+		ch <- prometheus.MustNewConstMetric(allowedDesc, prometheus.CounterValue, float64(counts.Allowed), c.name, route)
+		ch <- prometheus.MustNewConstMetric(deniedDesc, prometheus.CounterValue, float64(counts.Denied), c.name, route)
+	}
+	ch <- prometheus.MustNewConstMetric(visitorsDesc, prometheus.GaugeValue, float64(m.Visitors), c.name)
+	ch <- prometheus.MustNewConstMetric(shardSkewDesc, prometheus.GaugeValue, m.ShardSkew, c.name)
+	ch <- prometheus.MustNewConstMetric(cleanupDesc, prometheus.GaugeValue, m.LastCleanup.Seconds(), c.name)
+	ch <- prometheus.MustNewConstMetric(storeErrorsDesc, prometheus.CounterValue, float64(m.StoreErrors), c.name)
+	var degraded float64
+	if m.Degraded {
+		degraded = 1
+	}
+	ch <- prometheus.MustNewConstMetric(degradedDesc, prometheus.GaugeValue, degraded, c.name)
+}
@@ -0,0 +1,210 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature prefixes every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Length is the longest valid v1 header, including the CRLF
+const maxProxyV1Length = 107
+
+// defaultProxyHeaderTimeout bounds the wait for a PROXY header when
+// NewProxyListener is given no timeout
+const defaultProxyHeaderTimeout = 10 * time.Second
+
+var errInvalidProxyHeader = errors.New("ratelimiter: invalid PROXY protocol header")
+
+// proxyListener wraps accepted connections so their RemoteAddr reports the
+// client address from the PROXY protocol header
+type proxyListener struct {
+	net.Listener
+	headerTimeout time.Duration
+}
+
+// NewProxyListener wraps l so connections from an L4 load balancer speaking
+// the PROXY protocol (v1 or v2) report the real client address as their
+// RemoteAddr. net/http copies that into Request.RemoteAddr, where the
+// middleware picks it up. Every connection must start with a PROXY header;
+// connections without one fail on their first read. The header is read
+// lazily in the connection's own goroutine, and headerTimeout bounds how long
+// a client may take to send it (default 10 seconds).
+func NewProxyListener(l net.Listener, headerTimeout time.Duration) net.Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = defaultProxyHeaderTimeout
+	}
+	return &proxyListener{Listener: l, headerTimeout: headerTimeout}
+}
+
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: pl.headerTimeout}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+
+	mx       sync.Mutex
+	deadline time.Time // the read deadline set by the caller
+}
+
+// readHeader consumes the PROXY header the first time it is called. The
+// header wait is bounded by headerTimeout, or by the caller's read deadline
+// if that comes first, which is restored afterwards.
+func (pc *proxyConn) readHeader() {
+	pc.once.Do(func() {
+		pc.mx.Lock()
+		deadline := time.Now().Add(pc.headerTimeout)
+		if !pc.deadline.IsZero() && pc.deadline.Before(deadline) {
+			deadline = pc.deadline
+		}
+		pc.Conn.SetReadDeadline(deadline)
+		pc.mx.Unlock()
+		defer func() {
+			pc.mx.Lock()
+			pc.Conn.SetReadDeadline(pc.deadline)
+			pc.mx.Unlock()
+		}()
+		pc.remote, pc.err = readProxyHeader(pc.reader)
+	})
+}
+
+func (pc *proxyConn) SetDeadline(t time.Time) error {
+	pc.mx.Lock()
+	defer pc.mx.Unlock()
+	pc.deadline = t
+	return pc.Conn.SetDeadline(t)
+}
+
+func (pc *proxyConn) SetReadDeadline(t time.Time) error {
+	pc.mx.Lock()
+	defer pc.mx.Unlock()
+	pc.deadline = t
+	return pc.Conn.SetReadDeadline(t)
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	pc.readHeader()
+	if pc.err != nil {
+		return 0, pc.err
+	}
+	return pc.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the
+// connection's own address for LOCAL and UNKNOWN headers
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	pc.readHeader()
+	if pc.remote != nil {
+		return pc.remote
+	}
+	return pc.Conn.RemoteAddr()
+}
+
+// readProxyHeader parses a v1 or v2 PROXY header. A nil address with a nil
+// error means the header carried no client address.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Valid v1 headers are longer than the v2 signature, so this never cuts one short
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errInvalidProxyHeader
+}
+
+// readProxyV1 parses a header like "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errInvalidProxyHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary v2 header
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	// version 2, and a LOCAL (0) or PROXY (1) command
+	if hdr[12]>>4 != 2 || hdr[12]&0x0f > 1 {
+		return nil, errInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errInvalidProxyHeader
+	}
+
+	// LOCAL connections come from the proxy itself, e.g. health checks
+	if hdr[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, errInvalidProxyHeader
+	}
+	ip := net.IP(payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+	if hdr[13]&0x0f == 2 { // DGRAM
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(command byte) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, 0x20|command, 0x11, 0, 12)
+	hdr = append(hdr, 192, 0, 2, 1, 192, 0, 2, 2)
+	return append(hdr, 0xdc, 0x04, 0x01, 0xbb)
+}
+
+func TestReadProxyV2Commands(t *testing.T) {
+	addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(proxyV2Header(1))))
+	if err != nil || addr.String() != "192.0.2.1:56324" {
+		t.Errorf("PROXY command: %v, %v", addr, err)
+	}
+	if addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(proxyV2Header(0)))); addr != nil || err != nil {
+		t.Errorf("LOCAL command: %v, %v, want no address", addr, err)
+	}
+	for _, command := range []byte{2, 0xf} {
+		if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader(proxyV2Header(command)))); !errors.Is(err, errInvalidProxyHeader) {
+			t.Errorf("command %#x: err = %v, want errInvalidProxyHeader", command, err)
+		}
+	}
+}
+
+func TestProxyListenerTimesOutSilentClients(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := NewProxyListener(ln, 0).(*proxyListener)
+	defer pl.Close()
+	if pl.headerTimeout != defaultProxyHeaderTimeout {
+		t.Errorf("header timeout %v, want the default", pl.headerTimeout)
+	}
+	pl.headerTimeout = 50 * time.Millisecond
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := make(chan net.Addr)
+	go func() { done <- conn.RemoteAddr() }()
+	select {
+	case addr := <-done:
+		if addr.String() != client.LocalAddr().String() {
+			t.Errorf("RemoteAddr = %v, want the connection's own address", addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RemoteAddr blocked on a client that sent nothing")
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, errInvalidProxyHeader) {
+		t.Errorf("Read = %v, want errInvalidProxyHeader", err)
+	}
+}
+
+func TestReadProxyV1(t *testing.T) {
+	for _, tc := range []struct {
+		header, addr string
+		err          bool
+	}{
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", addr: "192.0.2.1:56324"},
+		{header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", addr: "[2001:db8::1]:56324"},
+		{header: "PROXY UNKNOWN\r\n"},
+		{header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n", err: true},
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n", err: true},
+		{header: "PROXY UDP4 192.0.2.1 192.0.2.2 56324 443\r\n", err: true},
+		{header: "PROXY TCP4 not-an-ip 192.0.2.2 56324 443\r\n", err: true},
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n", err: true},
+		{header: "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", err: true},
+		{header: "GET / HTTP/1.1\r\n", err: true},
+	} {
+		addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(tc.header + "GET / HTTP/1.1\r\n")))
+		if tc.err {
+			if !errors.Is(err, errInvalidProxyHeader) {
+				t.Errorf("%q: err = %v, want errInvalidProxyHeader", tc.header, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.header, err)
+			continue
+		}
+		if got := fmt.Sprint(addr); (addr == nil && tc.addr != "") || (addr != nil && got != tc.addr) {
+			t.Errorf("%q: address %v, want %q", tc.header, addr, tc.addr)
+		}
+	}
+}
+
+// deadlineConn records the read deadlines set on a connection
+type deadlineConn struct {
+	net.Conn
+	deadlines []time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func TestProxyConnKeepsCallerDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &deadlineConn{Conn: server}
+	pc := &proxyConn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: time.Minute}
+	defer pc.Close()
+
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	deadline := time.Now().Add(time.Hour)
+	pc.SetReadDeadline(deadline)
+	if addr := pc.RemoteAddr(); addr.String() != "192.0.2.1:56324" {
+		t.Fatalf("RemoteAddr = %v", addr)
+	}
+	n := len(conn.deadlines)
+	if n < 3 || !conn.deadlines[n-1].Equal(deadline) {
+		t.Errorf("read deadlines %v, want the header wait followed by the caller's %v", conn.deadlines, deadline)
+	}
+	if wait := conn.deadlines[n-2]; !wait.Before(deadline) {
+		t.Errorf("header wait ends at %v, want the header timeout before the caller's deadline", wait)
+	}
+}
@@ -0,0 +1,226 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Quota caps the requests a client may make per period, such as 10,000 per
+// day, on top of its rate. Periods are fixed windows aligned to UTC, so a
+// daily quota resets at midnight UTC and an hourly one on the hour.
+type Quota struct {
+	// Limit is the number of requests allowed per period
+	Limit int `json:"limit" yaml:"limit" toml:"limit"`
+	// Period is the window length, e.g. time.Hour or 24 * time.Hour
+	Period time.Duration `json:"period" yaml:"period" toml:"period"`
+}
+
+// UnmarshalJSON decodes a quota whose period is given either in nanoseconds
+// or as a duration string like "1h", so config files can write the latter
+func (q *Quota) UnmarshalJSON(data []byte) error {
+	type plain Quota
+	var raw struct {
+		plain
+		Period json.RawMessage `json:"period"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*q = Quota(raw.plain)
+	return unmarshalDuration(raw.Period, &q.Period)
+}
+
+// quotaCount is a key's usage of one quota
+type quotaCount struct {
+	start time.Time // start of the current period
+	count int
+}
+
+// quotaUsage describes the most constrained of a key's quotas after a check
+type quotaUsage struct {
+	quota     *Quota
+	remaining int
+	reset     time.Time // end of the current period
+}
+
+// quotaTracker counts requests per key against the configured quotas. Like
+// bans, quotas apply to a client as a whole rather than to one of its
+// buckets, and they must outlive MaxIdleTime, so they are kept apart from
+// the visitors.
+type quotaTracker struct {
+	quotas []Quota
+	mx     sync.Mutex
+	counts map[string][]quotaCount // key -> usage, in the order of quotas
+}
+
+func newQuotaTracker(quotas []Quota) *quotaTracker {
+	return &quotaTracker{quotas: quotas, counts: make(map[string][]quotaCount)}
+}
+
+// current returns key's usage with every period rolled forward to now. The
+// caller must hold qt.mx.
+func (qt *quotaTracker) current(key string, now time.Time) []quotaCount {
+	counts := qt.counts[key]
+	if counts == nil {
+		counts = make([]quotaCount, len(qt.quotas))
+		qt.counts[key] = counts
+	}
+	for i, q := range qt.quotas {
+		if start := now.Truncate(q.Period); !start.Equal(counts[i].start) {
+			counts[i] = quotaCount{start: start}
+		}
+	}
+	return counts
+}
+
+// take counts n requests for key against every quota if all of them have
+// room, and reports the quota that failed or, if none did, the one with the
+// least room left
+func (qt *quotaTracker) take(key string, n int, now time.Time) (quotaUsage, bool) {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+
+	counts := qt.current(key, now)
+	var usage quotaUsage
+	for i := range qt.quotas {
+		q := &qt.quotas[i]
+		remaining := q.Limit - counts[i].count
+		if remaining < n {
+			return quotaUsage{quota: q, reset: counts[i].start.Add(q.Period)}, false
+		}
+		if usage.quota == nil || remaining-n < usage.remaining {
+			usage = quotaUsage{quota: q, remaining: remaining - n, reset: counts[i].start.Add(q.Period)}
+		}
+	}
+	for i := range counts {
+		counts[i].count += n
+	}
+	return usage, true
+}
+
+// refund gives back n requests taken at now, for requests the rate limit
+// went on to deny. Periods that have ended since are left alone.
+func (qt *quotaTracker) refund(key string, n int, now time.Time) {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+
+	counts := qt.counts[key]
+	for i := range counts {
+		if counts[i].start.Equal(now.Truncate(qt.quotas[i].Period)) {
+			counts[i].count = max(0, counts[i].count-n)
+		}
+	}
+}
+
+// reset forgets key's usage
+func (qt *quotaTracker) reset(key string) {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+	delete(qt.counts, key)
+}
+
+// expire forgets keys whose periods have all ended
+func (qt *quotaTracker) expire(now time.Time) {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+
+	for key, counts := range qt.counts {
+		ended := true
+		for i, q := range qt.quotas {
+			if now.Before(counts[i].start.Add(q.Period)) {
+				ended = false
+				break
+			}
+		}
+		if ended {
+			delete(qt.counts, key)
+		}
+	}
+}
+
+// quotaSnapshot is a key's usage of the quota with Period
+type quotaSnapshot struct {
+	Period time.Duration `json:"period"`
+	Start  time.Time     `json:"start"`
+	Count  int           `json:"count"`
+}
+
+// active returns the usage in periods still running at now, by key
+func (qt *quotaTracker) active(now time.Time) map[string][]quotaSnapshot {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+
+	usage := make(map[string][]quotaSnapshot)
+	for key, counts := range qt.counts {
+		for i, q := range qt.quotas {
+			if counts[i].count > 0 && now.Before(counts[i].start.Add(q.Period)) {
+				usage[key] = append(usage[key], quotaSnapshot{Period: q.Period, Start: counts[i].start, Count: counts[i].count})
+			}
+		}
+	}
+	return usage
+}
+
+// restore loads usage saved by active. Usage of periods that have ended, or
+// of quotas no longer configured, is dropped.
+func (qt *quotaTracker) restore(usage map[string][]quotaSnapshot, now time.Time) {
+	qt.mx.Lock()
+	defer qt.mx.Unlock()
+
+	for key, saved := range usage {
+		counts := qt.current(key, now)
+		for _, qs := range saved {
+			for i, q := range qt.quotas {
+				if q.Period == qs.Period && counts[i].start.Equal(qs.Start) {
+					counts[i].count = qs.Count
+				}
+			}
+		}
+	}
+}
+
+// takeQuota counts n requests for key against the configured quotas.
+// Periods follow the shared store's clock, if it reports one, so instances
+// with skewed clocks start them together.
+func (rl *RateLimiter) takeQuota(key string, n int, now time.Time) (quotaUsage, bool) {
+	if rl.quotas == nil {
+		return quotaUsage{}, true
+	}
+	return rl.quotas.take(key, n, rl.storeNow(now))
+}
+
+// refundQuota gives back n requests taken by takeQuota
+func (rl *RateLimiter) refundQuota(key string, n int, now time.Time) {
+	if rl.quotas != nil {
+		rl.quotas.refund(key, n, rl.storeNow(now))
+	}
+}
+
+// quotaLimitInfo describes a request denied by a quota
+func (rl *RateLimiter) quotaLimitInfo(identity string, usage quotaUsage, now time.Time) LimitInfo {
+	return LimitInfo{
+		Key:        identity,
+		Limit:      rate.Limit(float64(usage.quota.Limit) / usage.quota.Period.Seconds()),
+		Burst:      usage.quota.Limit,
+		Remaining:  usage.remaining,
+		RetryAfter: usage.reset.Sub(rl.storeNow(now)),
+		Quota:      usage.quota,
+	}
+}
+
+// writeQuotaHeaders describes the most constrained quota: its limit, the
+// requests left in the current period and, as a Unix timestamp, when the
+// period ends
+func (rl *RateLimiter) writeQuotaHeaders(h http.Header, usage quotaUsage) {
+	if rl.config.OmitHeaders || usage.quota == nil {
+		return
+	}
+	h.Set("X-RateLimit-Quota-Limit", strconv.Itoa(usage.quota.Limit))
+	h.Set("X-RateLimit-Quota-Remaining", strconv.Itoa(usage.remaining))
+	h.Set("X-RateLimit-Quota-Reset", strconv.FormatInt(usage.reset.Add(rl.config.RetryAfterMargin).Unix(), 10))
+}
@@ -1,10 +1,19 @@
 package ratelimiter
 
 import (
+	"container/list"
+	"context"
+	"html/template"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -13,13 +22,395 @@ import (
 // Config holds the configuration for the rate limiter
 type Config struct {
 	// RequestsPerSecond is the number of requests allowed per second
-	RequestsPerSecond float64
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
 	// Burst is the maximum number of requests allowed in a burst
-	Burst int
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+	// Algorithm selects how requests are counted. The window based algorithms
+	// allow Burst requests per Window and only work with the in-process store;
+	// a shared Store uses the token bucket, or GCRA if that is selected.
+	Algorithm Algorithm `json:"algorithm" yaml:"algorithm" toml:"algorithm"`
+	// Window is the window length of the window based algorithms. Defaults
+	// to Burst / RequestsPerSecond, matching the token bucket's long-run rate.
+	Window time.Duration `json:"window" yaml:"window" toml:"window"`
+	// GlobalRequestsPerSecond, when set, caps the total rate across all
+	// clients, protecting a backend with a hard capacity however many
+	// clients there are. It is checked before the per-client limits.
+	GlobalRequestsPerSecond float64 `json:"global_requests_per_second" yaml:"global_requests_per_second" toml:"global_requests_per_second"`
+	// GlobalBurst is the burst of the global limit. Defaults to one second's
+	// worth of GlobalRequestsPerSecond.
+	GlobalBurst int `json:"global_burst" yaml:"global_burst" toml:"global_burst"`
+	// Quotas cap the requests each client may make per minute, hour, day or
+	// any other period, on top of its rate. A request must fit every quota
+	// and the rate; requests denied by one aren't counted against the others.
+	Quotas []Quota `json:"quotas" yaml:"quotas" toml:"quotas"`
+	// Limits are further rates every client must pass along with
+	// RequestsPerSecond, e.g. 1000 per hour and 5000 per day. They are
+	// checked together, so one that denies a request doesn't take tokens
+	// from the others. SetLimitsFor replaces them for a single key.
+	Limits []Limit `json:"limits" yaml:"limits" toml:"limits"`
+	// Dimensions, when set, limit requests by further keys at the same time,
+	// each at its own rate, e.g. per account and per IP. A request must pass
+	// all of them, and denials name the dimension in X-RateLimit-Dimension.
+	// Dimensions without a KeyFunc or rate are dropped.
+	Dimensions []Dimension `json:"dimensions" yaml:"dimensions" toml:"dimensions"`
+	// Store, when set, holds the token buckets instead of the in-process
+	// default, e.g. a Redis store shared by every instance of a service
+	Store Store `json:"-" yaml:"-" toml:"-"`
+	// FailurePolicy decides what happens to requests while Store fails:
+	// FailOpen (default) lets them through, FailClosed denies them and
+	// FallbackToLocal limits them in memory until the store is back
+	FailurePolicy FailurePolicy `json:"failure_policy" yaml:"failure_policy" toml:"failure_policy"`
+	// StoreBreakerThreshold is the number of consecutive Store errors that
+	// trip the circuit breaker. While it is open the store isn't called and
+	// FailurePolicy applies to every request (default: 5).
+	StoreBreakerThreshold int `json:"store_breaker_threshold" yaml:"store_breaker_threshold" toml:"store_breaker_threshold"`
+	// StoreRetryInterval is how often an open breaker lets one request try
+	// the store again. The breaker closes once one succeeds (default: 5s).
+	StoreRetryInterval time.Duration `json:"store_retry_interval" yaml:"store_retry_interval" toml:"store_retry_interval"`
+	// KeyFunc, when set, returns the key a request is limited by, such as an
+	// API key, user ID or JWT subject. The result is treated as an opaque
+	// string. Requests for which it returns "" fall back to the client IP.
+	KeyFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// KeyCacheFunc, when set together with KeyCacheTTL, returns a cheap
+	// attribute shared by correlated requests, such as a request or connection
+	// ID. KeyFunc's result is reused for requests with the same attribute for
+	// up to KeyCacheTTL, so a key may be that stale. Requests for which it
+	// returns "" always call KeyFunc.
+	KeyCacheFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// KeyCacheTTL is how long a KeyFunc result is reused for a KeyCacheFunc
+	// attribute
+	KeyCacheTTL time.Duration `json:"key_cache_ttl" yaml:"key_cache_ttl" toml:"key_cache_ttl"`
+	// KeyCacheSize caps how many KeyFunc results are cached (default: 10000)
+	KeyCacheSize int `json:"key_cache_size" yaml:"key_cache_size" toml:"key_cache_size"`
+	// Routes gives requests to matching paths their own rate and burst. The
+	// first matching route wins; unmatched requests use the limits above.
+	Routes []Route `json:"routes" yaml:"routes" toml:"routes"`
+	// MethodOverrides gives HTTP methods their own rate and burst, so writes
+	// can have a stricter budget than reads. Each method keeps its own bucket
+	// per client. Requests matching a route or tier use its limits instead.
+	MethodOverrides map[string]LimitSpec `json:"method_overrides" yaml:"method_overrides" toml:"method_overrides"`
+	// TrustedProxies lists the CIDRs (or single IPs) of proxies allowed to set
+	// X-Forwarded-For and X-Real-IP. When set, those headers are ignored on
+	// requests from anywhere else. When empty, they are trusted from any
+	// client, which lets clients spoof their address.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// IPv6PrefixBits is the prefix length IPv6 clients are limited by, so
+	// every address in a client's network shares one limit. Defaults to 64;
+	// 128 limits each address on its own.
+	IPv6PrefixBits int `json:"ipv6_prefix_bits" yaml:"ipv6_prefix_bits" toml:"ipv6_prefix_bits"`
+	// IPv4PrefixBits is the prefix length IPv4 clients are limited by.
+	// Defaults to 32, limiting each address on its own.
+	IPv4PrefixBits int `json:"ipv4_prefix_bits" yaml:"ipv4_prefix_bits" toml:"ipv4_prefix_bits"`
+	// Allowlist holds IPs and CIDR ranges, such as health checkers, that are
+	// never limited
+	Allowlist []string `json:"allowlist" yaml:"allowlist" toml:"allowlist"`
+	// Denylist holds IPs and CIDR ranges whose requests are always rejected
+	// with 403 Forbidden, without touching any limiter state
+	Denylist []string `json:"denylist" yaml:"denylist" toml:"denylist"`
+	// TierResolver, when set together with Tiers, returns the tier of a
+	// request, typically the plan of the authenticated account. Requests
+	// whose tier isn't in Tiers use the limits above.
+	TierResolver func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// Tiers maps tier names to their limits. Each tier keeps its own bucket
+	// per client, so a plan change takes effect on the next request. Routes
+	// take precedence over tiers.
+	Tiers map[string]Tier `json:"tiers" yaml:"tiers" toml:"tiers"`
+	// NetworkLimits gives clients within IP ranges their own limits. The
+	// most specific range containing the client IP applies, and each client
+	// keeps its own bucket with the range's limits. Routes and tiers take
+	// precedence over network limits.
+	NetworkLimits []NetworkLimit `json:"network_limits" yaml:"network_limits" toml:"network_limits"`
+	// MaxVisitors, when set, caps the number of tracked keys. Once it is
+	// reached, the least recently seen keys are evicted to make room for new
+	// ones straight away rather than at the next cleanup, bounding memory
+	// when clients rotate through many addresses.
+	MaxVisitors int `json:"max_visitors" yaml:"max_visitors" toml:"max_visitors"`
+	// SyncVisitorMap keeps the in-memory visitors in sync.Maps, so requests
+	// from visitors already tracked find their bucket without taking a shard
+	// lock. It suits read-heavy workloads where the same clients recur at
+	// high concurrency, and makes each new visitor more expensive. Lookups
+	// take the lock anyway with MaxVisitors, which reorders visitors on
+	// every request, and for boosted visitors.
+	SyncVisitorMap bool `json:"sync_visitor_map" yaml:"sync_visitor_map" toml:"sync_visitor_map"`
+	// OnNewVisitor, when set, is called when a key is first tracked, and
+	// OnEvict when it is removed by cleanup, MaxVisitors or a reset. They run
+	// with part of the visitor table locked, so they must be quick and must
+	// not call the limiter; hand slow work such as billing writes to another
+	// goroutine.
+	OnNewVisitor func(VisitorEvent) `json:"-" yaml:"-" toml:"-"`
+	OnEvict      func(VisitorEvent) `json:"-" yaml:"-" toml:"-"`
+	// Clock, when set, replaces the system clock for every decision and for
+	// cleanup, so tests can advance time with a ManualClock instead of
+	// sleeping. ModeWait and EscalationTarpit don't wait with a custom clock.
+	Clock Clock `json:"-" yaml:"-" toml:"-"`
 	// CleanupInterval is how often the cleanup routine runs
-	CleanupInterval time.Duration
+	CleanupInterval time.Duration `json:"cleanup_interval" yaml:"cleanup_interval" toml:"cleanup_interval"`
+	// NormalizePaths lowercases request paths, collapses repeated slashes and
+	// drops trailing slashes before they are matched against Routes and used
+	// in keys, so "/API//users/" and "/api/users" share a bucket. Route
+	// patterns are normalized the same way.
+	NormalizePaths bool `json:"normalize_paths" yaml:"normalize_paths" toml:"normalize_paths"`
+	// MaxDynamicRoutes caps how many paths routes with PerPath set give
+	// buckets of their own (default: 1000). Statically configured routes
+	// don't count towards it.
+	MaxDynamicRoutes int `json:"max_dynamic_routes" yaml:"max_dynamic_routes" toml:"max_dynamic_routes"`
+	// CleanupWorkers, when above 1, is how many of the visitor map's shards
+	// each cleanup run scans at once, at most GOMAXPROCS. Large maps are
+	// cleaned faster at the cost of briefly using more CPUs.
+	CleanupWorkers int `json:"cleanup_workers" yaml:"cleanup_workers" toml:"cleanup_workers"`
 	// MaxIdleTime is how long a visitor can be idle before being removed
-	MaxIdleTime time.Duration
+	MaxIdleTime time.Duration `json:"max_idle_time" yaml:"max_idle_time" toml:"max_idle_time"`
+	// PenaltyBoxSize, when set, is how many removed visitors that had been
+	// denied or given a limit of their own are remembered, so a client that
+	// goes quiet comes back to its limit and denial history rather than a
+	// clean slate
+	PenaltyBoxSize int `json:"penalty_box_size" yaml:"penalty_box_size" toml:"penalty_box_size"`
+	// PenaltyBoxTTL is how long after its last request a removed visitor's
+	// record is kept. It must exceed MaxIdleTime (default: 10 times
+	// MaxIdleTime).
+	PenaltyBoxTTL time.Duration `json:"penalty_box_ttl" yaml:"penalty_box_ttl" toml:"penalty_box_ttl"`
+	// OverrideTTL, when set, is how long cleanup keeps a visitor given its own
+	// limit by SetVisitorLimit, however long it is idle
+	OverrideTTL time.Duration `json:"override_ttl" yaml:"override_ttl" toml:"override_ttl"`
+	// OmitHeaders disables the RateLimit-Limit, RateLimit-Remaining and
+	// RateLimit-Reset headers sent on every limited response
+	OmitHeaders bool `json:"omit_headers" yaml:"omit_headers" toml:"omit_headers"`
+	// MarkUnlimited sends RateLimit-Limit: unlimited for buckets with an
+	// infinite rate instead of leaving the RateLimit headers out
+	MarkUnlimited bool `json:"mark_unlimited" yaml:"mark_unlimited" toml:"mark_unlimited"`
+	// RetryAfterJitter, when set, is the fraction by which Retry-After and
+	// RateLimit-Reset are randomly lengthened or shortened, e.g. 0.2 for ±20%,
+	// so clients throttled together don't all retry at the same moment
+	RetryAfterJitter float64 `json:"retry_after_jitter" yaml:"retry_after_jitter" toml:"retry_after_jitter"`
+	// RetryAfterMargin is added to Retry-After and the reset times sent to
+	// clients, so retries arriving at an instance whose clock lags slightly
+	// behind aren't denied again
+	RetryAfterMargin time.Duration `json:"retry_after_margin" yaml:"retry_after_margin" toml:"retry_after_margin"`
+	// DenyRateWindow is the sliding window DenyRate is computed over
+	DenyRateWindow time.Duration `json:"deny_rate_window" yaml:"deny_rate_window" toml:"deny_rate_window"`
+	// RequestIDHeader, when set, is the header carrying the request ID. Denied
+	// responses echo the ID back in the same header, generating one if the
+	// request has none, and the ID is passed to OnDeny and OnLimitExceeded in
+	// LimitInfo.RequestID and logged with the denial.
+	RequestIDHeader string `json:"request_id_header" yaml:"request_id_header" toml:"request_id_header"`
+	// HTMLTemplate, when set, is rendered with DenyPageData for denied requests
+	// whose Accept header asks for HTML. Other clients get the plain text response.
+	HTMLTemplate *template.Template `json:"-" yaml:"-" toml:"-"`
+	// RejectionStatusCode is the status denied requests get, e.g. 503 for
+	// clients that only understand Retry-After on that code (default: 429)
+	RejectionStatusCode int `json:"rejection_status_code" yaml:"rejection_status_code" toml:"rejection_status_code"`
+	// RejectionContentType is the Content-Type of RejectionBody
+	// (default: text/plain; charset=utf-8)
+	RejectionContentType string `json:"rejection_content_type" yaml:"rejection_content_type" toml:"rejection_content_type"`
+	// RejectionBody, when set, replaces the status text as the body of denied
+	// requests, such as a JSON error matching an API's schema. It is a
+	// text/template executed with DenyPageData; if it doesn't parse it is sent
+	// as is.
+	RejectionBody string `json:"rejection_body" yaml:"rejection_body" toml:"rejection_body"`
+	// Mode decides whether requests over the limit are rejected straight
+	// away (the default) or delayed until they fit
+	Mode Mode `json:"mode" yaml:"mode" toml:"mode"`
+	// TransportKeyFunc, when set, extracts the key outgoing requests made
+	// through Transport are limited by, e.g. the API token they carry.
+	// Defaults to the destination host.
+	TransportKeyFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// MaxWait is the longest a request is delayed in ModeWait. Requests that
+	// would have to wait longer are denied; requests canceled while waiting
+	// get 503 Service Unavailable.
+	MaxWait time.Duration `json:"max_wait" yaml:"max_wait" toml:"max_wait"`
+	// OnLimitExceeded, when set, writes the response for denied requests
+	// instead of the default 429, e.g. a JSON error body or a redirect to a
+	// captcha. The Retry-After and other denial headers are already set when
+	// it is called. Escalated responses are not affected.
+	OnLimitExceeded func(w http.ResponseWriter, r *http.Request, info LimitInfo) `json:"-" yaml:"-" toml:"-"`
+	// OnDeny, when set, is called for every request denied by a rate limit,
+	// before the response is written. It is meant for logging and tracing;
+	// use OnLimitExceeded to change the response.
+	OnDeny func(r *http.Request, info LimitInfo) `json:"-" yaml:"-" toml:"-"`
+	// Logger, when set, receives structured logs of throttled requests
+	// (including would-be denials in dry run mode), bans, cleanup sweeps and
+	// store errors. Without it the limiter is silent.
+	Logger *slog.Logger `json:"-" yaml:"-" toml:"-"`
+	// CostFunc, when set, returns the number of tokens a request costs, so
+	// expensive endpoints such as search or export use up more of the limit.
+	// Values below 1 count as 1. Requests costing more than the burst are
+	// always denied.
+	CostFunc func(*http.Request) int `json:"-" yaml:"-" toml:"-"`
+	// BodyCostBytes, when set, charges a token per started BodyCostBytes of
+	// the request's Content-Length, e.g. 100 << 10 for one token per 100KB,
+	// so large uploads use up more of the limit. With CostFunc set as well,
+	// the larger of the two costs applies.
+	BodyCostBytes int64 `json:"body_cost_bytes" yaml:"body_cost_bytes" toml:"body_cost_bytes"`
+	// MaxBodyCost caps the tokens charged by BodyCostBytes
+	MaxBodyCost int `json:"max_body_cost" yaml:"max_body_cost" toml:"max_body_cost"`
+	// UnknownBodyCost is charged up front for bodies of unknown length, such
+	// as chunked uploads (default: MaxBodyCost)
+	UnknownBodyCost int `json:"unknown_body_cost" yaml:"unknown_body_cost" toml:"unknown_body_cost"`
+	// MaxMeteredBody, when set, has bodies of unknown length read into memory
+	// up to this many bytes before they are charged, so they cost what they
+	// actually weigh. Longer bodies are charged at least UnknownBodyCost. The
+	// handler still receives the whole body.
+	MaxMeteredBody int64 `json:"max_metered_body" yaml:"max_metered_body" toml:"max_metered_body"`
+	// ChargeStatuses, when set, switches to post-response charging: requests are
+	// admitted while the visitor has a token left, and a token is only consumed
+	// when the handler responds with one of these statuses. Use
+	// []int{http.StatusUnauthorized, http.StatusForbidden} to limit failed logins.
+	// Only the visitor's bucket and the global limit apply: Quotas, Limits,
+	// Dimensions, MaxDistinctResources and IdempotencyKeyTTL are ignored.
+	ChargeStatuses []int `json:"charge_statuses" yaml:"charge_statuses" toml:"charge_statuses"`
+	// RefundStatuses, when set, gives a request its tokens back when the
+	// handler responds with one of these statuses. Unlike ChargeStatuses,
+	// which takes precedence, tokens are taken before the handler runs, so
+	// concurrent requests can't overrun the limit while they are in flight.
+	RefundStatuses []int `json:"refund_statuses" yaml:"refund_statuses" toml:"refund_statuses"`
+	// UpstreamErrorThreshold, when set, is the fraction of 5xx responses over
+	// UpstreamWindow above which limits are tightened until the upstream recovers
+	UpstreamErrorThreshold float64 `json:"upstream_error_threshold" yaml:"upstream_error_threshold" toml:"upstream_error_threshold"`
+	// UpstreamWindow is the sliding window upstream failures are measured over
+	UpstreamWindow time.Duration `json:"upstream_window" yaml:"upstream_window" toml:"upstream_window"`
+	// UpstreamPenalty is the number of tokens each request costs while the
+	// upstream is unhealthy
+	UpstreamPenalty int `json:"upstream_penalty" yaml:"upstream_penalty" toml:"upstream_penalty"`
+	// LoadFunc, when set with LoadThreshold, reports the server's load, such
+	// as CPU utilization, p99 latency or queue depth, which the limiter
+	// samples every LoadInterval to tighten limits while it is too high
+	LoadFunc func() float64 `json:"-" yaml:"-" toml:"-"`
+	// LoadThreshold is the load above which limits are tightened
+	LoadThreshold float64 `json:"load_threshold" yaml:"load_threshold" toml:"load_threshold"`
+	// LoadInterval is how often LoadFunc is sampled (default: 1s)
+	LoadInterval time.Duration `json:"load_interval" yaml:"load_interval" toml:"load_interval"`
+	// LoadMaxPenalty is the most tokens a request costs under load
+	// (default: 4)
+	LoadMaxPenalty int `json:"load_max_penalty" yaml:"load_max_penalty" toml:"load_max_penalty"`
+	// CountOnly disables enforcement entirely: no token buckets are created and
+	// requests are only counted per key, see KeyCounts
+	CountOnly bool `json:"count_only" yaml:"count_only" toml:"count_only"`
+	// DominantKeyShare, when set, is the share of all requests within
+	// DominantKeyWindow above which a single key triggers OnDominantKey
+	DominantKeyShare float64 `json:"dominant_key_share" yaml:"dominant_key_share" toml:"dominant_key_share"`
+	// DominantKeyWindow is the window request shares are measured over
+	DominantKeyWindow time.Duration `json:"dominant_key_window" yaml:"dominant_key_window" toml:"dominant_key_window"`
+	// OnDominantKey is called once per window for each key exceeding
+	// DominantKeyShare. Defaults to a warning on Logger; without either the
+	// share isn't tracked.
+	OnDominantKey func(key string, share float64) `json:"-" yaml:"-" toml:"-"`
+	// AdminAuth authorizes requests to AdminHandler. Without it every admin
+	// request is rejected with 403 Forbidden.
+	AdminAuth func(*http.Request) bool `json:"-" yaml:"-" toml:"-"`
+	// IdempotentBurst, when set, gives idempotent requests (GET, HEAD, OPTIONS,
+	// TRACE, PUT, DELETE) their own bucket with this burst, leaving Burst for
+	// everything else, so safe retries aren't punished as harshly as POSTs
+	IdempotentBurst int `json:"idempotent_burst" yaml:"idempotent_burst" toml:"idempotent_burst"`
+	// InstanceID, when set, identifies this limiter instance on denied
+	// responses, which helps debugging limits that diverge between replicas
+	InstanceID string `json:"instance_id" yaml:"instance_id" toml:"instance_id"`
+	// InstanceIDHeader is the header InstanceID is sent in
+	InstanceIDHeader string `json:"instance_id_header" yaml:"instance_id_header" toml:"instance_id_header"`
+	// HashBuckets, when set, hashes every key into one of this many buckets and
+	// limits per bucket. Memory is bounded by the bucket count no matter how
+	// many clients there are, at the cost of unrelated clients that collide
+	// sharing a limit.
+	HashBuckets int `json:"hash_buckets" yaml:"hash_buckets" toml:"hash_buckets"`
+	// UnusualMethodPolicy decides what happens to TRACE, CONNECT and
+	// non-standard methods. Defaults to limiting them normally.
+	UnusualMethodPolicy MethodPolicy `json:"unusual_method_policy" yaml:"unusual_method_policy" toml:"unusual_method_policy"`
+	// IdempotencyKeyTTL, when set, makes retries carrying the same
+	// Idempotency-Key header free for this long after the first charged request
+	IdempotencyKeyTTL time.Duration `json:"idempotency_key_ttl" yaml:"idempotency_key_ttl" toml:"idempotency_key_ttl"`
+	// IdempotencyCacheSize caps how many idempotency keys are remembered
+	IdempotencyCacheSize int `json:"idempotency_cache_size" yaml:"idempotency_cache_size" toml:"idempotency_cache_size"`
+	// IdempotencyMaxReplays caps how many free retries one Idempotency-Key
+	// gets within the TTL (default: 3)
+	IdempotencyMaxReplays int `json:"idempotency_max_replays" yaml:"idempotency_max_replays" toml:"idempotency_max_replays"`
+	// ResourceFunc, when set together with MaxDistinctResources, extracts a
+	// resource ID from the request. Keys accessing more than
+	// MaxDistinctResources distinct IDs within DistinctResourceWindow are
+	// denied; requests for which it returns "" aren't counted.
+	ResourceFunc func(*http.Request) string `json:"-" yaml:"-" toml:"-"`
+	// MaxDistinctResources is the number of distinct resources a key may access per window
+	MaxDistinctResources int `json:"max_distinct_resources" yaml:"max_distinct_resources" toml:"max_distinct_resources"`
+	// DistinctResourceWindow is the sliding window distinct resources are counted over
+	DistinctResourceWindow time.Duration `json:"distinct_resource_window" yaml:"distinct_resource_window" toml:"distinct_resource_window"`
+	// RegularityThreshold, when set, enables a heuristic bot detector. Each
+	// key's inter-arrival intervals are tracked as an EWMA, and keys whose
+	// coefficient of variation (stddev / mean) falls below this threshold look
+	// automated and pay RegularityPenalty tokens per request. Around 0.1 only
+	// catches very clock-like clients; higher values risk flagging humans or
+	// legitimate pollers.
+	RegularityThreshold float64 `json:"regularity_threshold" yaml:"regularity_threshold" toml:"regularity_threshold"`
+	// RegularityPenalty is the number of tokens each request from a regular key costs
+	RegularityPenalty int `json:"regularity_penalty" yaml:"regularity_penalty" toml:"regularity_penalty"`
+	// DocsURL, when set, is sent on denied responses as a Link header with
+	// rel="help" pointing clients at the rate limit documentation
+	DocsURL string `json:"docs_url" yaml:"docs_url" toml:"docs_url"`
+	// AlwaysLinkDocs sends the DocsURL Link header on every response, not just denials
+	AlwaysLinkDocs bool `json:"always_link_docs" yaml:"always_link_docs" toml:"always_link_docs"`
+	// KeySecret, when set, stores state under an HMAC of each client identity
+	// rather than the identity itself, so keys can't be guessed or enumerated
+	// and limiters with different secrets never share buckets. See DeriveKey.
+	KeySecret []byte `json:"-" yaml:"-" toml:"-"`
+	// BypassSecret, when set, exempts requests carrying a BypassHeader signed
+	// with it by SignBypass. Each signature works once, within BypassMaxAge
+	// of its timestamp. Bypasses are logged to Logger.
+	BypassSecret []byte `json:"-" yaml:"-" toml:"-"`
+	// BypassHeader is the header signed bypasses are read from (default: X-RateLimit-Bypass)
+	BypassHeader string `json:"bypass_header" yaml:"bypass_header" toml:"bypass_header"`
+	// BypassMaxAge is how far a signed bypass's timestamp may be from the
+	// current time, either way, to allow for clock skew (default: 1 minute)
+	BypassMaxAge time.Duration `json:"bypass_max_age" yaml:"bypass_max_age" toml:"bypass_max_age"`
+	// WarmupDuration, when set, keeps the limiter in observe-only mode for this
+	// long after New: decisions are made and counted, but nothing is denied
+	WarmupDuration time.Duration `json:"warmup_duration" yaml:"warmup_duration" toml:"warmup_duration"`
+	// DryRun evaluates limits and counts would-be denials in DenyRate,
+	// Metrics and SnapshotAndResetStats, but never denies a request, so
+	// limits can be tuned against production traffic before enforcing them.
+	// The denylist and UnusualMethodPolicy still apply.
+	DryRun bool `json:"dry_run" yaml:"dry_run" toml:"dry_run"`
+	// EscalationThreshold, when set, is the number of consecutive denials
+	// after which a key gets the escalated response instead of the normal
+	// one. A single allowed request resets the count.
+	EscalationThreshold int `json:"escalation_threshold" yaml:"escalation_threshold" toml:"escalation_threshold"`
+	// EscalationStatus is the status code of the escalated response
+	EscalationStatus int `json:"escalation_status" yaml:"escalation_status" toml:"escalation_status"`
+	// EscalationTarpit is how long the escalated response is held back
+	EscalationTarpit time.Duration `json:"escalation_tarpit" yaml:"escalation_tarpit" toml:"escalation_tarpit"`
+	// EscalationRetryAfter is the least Retry-After sent with escalated
+	// responses (default: 1 minute)
+	EscalationRetryAfter time.Duration `json:"escalation_retry_after" yaml:"escalation_retry_after" toml:"escalation_retry_after"`
+	// BanThreshold, when set, bans keys denied this many times within
+	// BanWindow for BanDuration. Banned keys are rejected with BanStatus
+	// without consulting their buckets. See also Ban and Unban.
+	BanThreshold int `json:"ban_threshold" yaml:"ban_threshold" toml:"ban_threshold"`
+	// BanWindow is the sliding window violations are counted over
+	BanWindow time.Duration `json:"ban_window" yaml:"ban_window" toml:"ban_window"`
+	// BanDuration is how long a key stays banned
+	BanDuration time.Duration `json:"ban_duration" yaml:"ban_duration" toml:"ban_duration"`
+	// BanStatus is the status code banned keys are rejected with, typically
+	// 429 or 403
+	BanStatus int `json:"ban_status" yaml:"ban_status" toml:"ban_status"`
+	// OffenderWindow, when set, tracks the clients denied most often over a
+	// rolling window of this length. See TopOffenders.
+	OffenderWindow time.Duration `json:"offender_window" yaml:"offender_window" toml:"offender_window"`
+	// OnOffenders, when set, is called every OffenderReportInterval with the
+	// OffenderReportSize top offenders. OffenderWindow defaults to an hour.
+	OnOffenders func([]OffenderStat) `json:"-" yaml:"-" toml:"-"`
+	// OffenderReportInterval is how often OnOffenders is called (default: 1 minute)
+	OffenderReportInterval time.Duration `json:"offender_report_interval" yaml:"offender_report_interval" toml:"offender_report_interval"`
+	// OffenderReportSize is the most offenders passed to OnOffenders (default: 10)
+	OffenderReportSize int `json:"offender_report_size" yaml:"offender_report_size" toml:"offender_report_size"`
+	// AccelerationThreshold, when set, enables a heuristic spike detector.
+	// Each key's request rate is measured over consecutive AccelerationWindow
+	// windows, and keys whose rate grows faster than this many requests per
+	// second per second pay AccelerationPenalty tokens per request until their
+	// rate levels off.
+	AccelerationThreshold float64 `json:"acceleration_threshold" yaml:"acceleration_threshold" toml:"acceleration_threshold"`
+	// AccelerationWindow is the window each rate sample is measured over
+	AccelerationWindow time.Duration `json:"acceleration_window" yaml:"acceleration_window" toml:"acceleration_window"`
+	// AccelerationPenalty is the number of tokens each request from an accelerating key costs
+	AccelerationPenalty int `json:"acceleration_penalty" yaml:"acceleration_penalty" toml:"acceleration_penalty"`
+	// ReloadInterval, when set, is how often a limiter created by NewFromFile
+	// checks its file for changes and reloads it
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval" toml:"reload_interval"`
 }
 
 // DefaultConfig returns a Config with sensible defaults
@@ -29,6 +420,7 @@ func DefaultConfig() *Config {
 		Burst:             5,
 		CleanupInterval:   time.Minute,
 		MaxIdleTime:       3 * time.Minute,
+		DenyRateWindow:    time.Minute,
 	}
 }
 
@@ -40,24 +432,319 @@ func (c *Config) Validate() {
 	if c.Burst <= 0 {
 		c.Burst = 5
 	}
+	if _, local := c.Store.(*MemoryStore); c.Store != nil && !local && c.Algorithm != AlgorithmGCRA {
+		c.Algorithm = AlgorithmTokenBucket
+	}
+	if c.Algorithm < AlgorithmTokenBucket || c.Algorithm > AlgorithmGCRA {
+		c.Algorithm = AlgorithmTokenBucket
+	}
+	if c.Window <= 0 {
+		c.Window = time.Duration(float64(c.Burst) / c.RequestsPerSecond * float64(time.Second))
+	}
+	if c.Mode != ModeWait {
+		c.Mode = ModeReject
+	}
+	if c.FailurePolicy < FailOpen || c.FailurePolicy > FallbackToLocal {
+		c.FailurePolicy = FailOpen
+	}
+	if c.StoreBreakerThreshold <= 0 {
+		c.StoreBreakerThreshold = 5
+	}
+	if c.StoreRetryInterval <= 0 {
+		c.StoreRetryInterval = 5 * time.Second
+	}
+	if c.MaxWait <= 0 {
+		c.MaxWait = time.Second
+	}
+	if c.MaxDynamicRoutes <= 0 {
+		c.MaxDynamicRoutes = 1000
+	}
+	for i := range c.Routes {
+		if c.NormalizePaths {
+			c.Routes[i].Pattern = normalizePattern(c.Routes[i].Pattern)
+		}
+		if c.Routes[i].RequestsPerSecond <= 0 {
+			c.Routes[i].RequestsPerSecond = c.RequestsPerSecond
+		}
+		if c.Routes[i].Burst <= 0 {
+			c.Routes[i].Burst = c.Burst
+		}
+		c.Routes[i].MethodOverrides = validateOverrides(c.Routes[i].MethodOverrides, c.Routes[i].RequestsPerSecond, c.Routes[i].Burst)
+	}
+	c.MethodOverrides = validateOverrides(c.MethodOverrides, c.RequestsPerSecond, c.Burst)
+	for name, tier := range c.Tiers {
+		if tier.RequestsPerSecond <= 0 {
+			tier.RequestsPerSecond = c.RequestsPerSecond
+		}
+		if tier.Burst <= 0 {
+			tier.Burst = c.Burst
+		}
+		c.Tiers[name] = tier
+	}
+	c.NetworkLimits = slices.DeleteFunc(c.NetworkLimits, func(n NetworkLimit) bool {
+		_, err := parsePrefix(n.CIDR)
+		return err != nil
+	})
+	for i := range c.NetworkLimits {
+		if c.NetworkLimits[i].RequestsPerSecond <= 0 {
+			c.NetworkLimits[i].RequestsPerSecond = c.RequestsPerSecond
+		}
+		if c.NetworkLimits[i].Burst <= 0 {
+			c.NetworkLimits[i].Burst = c.Burst
+		}
+	}
+	if c.MaxVisitors < 0 {
+		c.MaxVisitors = 0
+	}
+	if c.IPv6PrefixBits <= 0 || c.IPv6PrefixBits > 128 {
+		c.IPv6PrefixBits = 64
+	}
+	if c.IPv4PrefixBits <= 0 || c.IPv4PrefixBits > 32 {
+		c.IPv4PrefixBits = 32
+	}
+	if c.Clock == nil {
+		c.Clock = realClock{}
+	}
+	if c.GlobalRequestsPerSecond < 0 {
+		c.GlobalRequestsPerSecond = 0
+	}
+	if c.GlobalRequestsPerSecond > 0 && c.GlobalBurst <= 0 {
+		c.GlobalBurst = int(math.Ceil(c.GlobalRequestsPerSecond))
+	}
+	c.Limits = normalizeLimits(c.Limits)
+	c.Dimensions = slices.DeleteFunc(c.Dimensions, func(d Dimension) bool { return d.KeyFunc == nil || d.RequestsPerSecond <= 0 })
+	for i := range c.Dimensions {
+		if c.Dimensions[i].Name == "" {
+			c.Dimensions[i].Name = "dimension" + strconv.Itoa(i+1)
+		}
+		if c.Dimensions[i].Burst <= 0 {
+			c.Dimensions[i].Burst = int(math.Ceil(c.Dimensions[i].RequestsPerSecond))
+		}
+	}
+	if c.BodyCostBytes < 0 {
+		c.BodyCostBytes = 0
+	}
+	if c.MaxBodyCost < 0 {
+		c.MaxBodyCost = 0
+	}
+	if c.UnknownBodyCost <= 0 {
+		c.UnknownBodyCost = c.MaxBodyCost
+	}
+	if c.MaxMeteredBody < 0 {
+		c.MaxMeteredBody = 0
+	}
+	c.Quotas = slices.DeleteFunc(c.Quotas, func(q Quota) bool { return q.Limit <= 0 || q.Period <= 0 })
 	if c.CleanupInterval < time.Second {
 		c.CleanupInterval = time.Minute
 	}
 	if c.MaxIdleTime < time.Second {
 		c.MaxIdleTime = 3 * time.Minute
 	}
+	if c.PenaltyBoxSize < 0 {
+		c.PenaltyBoxSize = 0
+	}
+	if c.PenaltyBoxTTL <= c.MaxIdleTime {
+		c.PenaltyBoxTTL = 10 * c.MaxIdleTime
+	}
+	if c.OverrideTTL < 0 {
+		c.OverrideTTL = 0
+	}
+	if c.DenyRateWindow < time.Second {
+		c.DenyRateWindow = time.Minute
+	}
+	if c.UpstreamErrorThreshold < 0 || c.UpstreamErrorThreshold >= 1 {
+		c.UpstreamErrorThreshold = 0
+	}
+	if c.RetryAfterJitter < 0 || c.RetryAfterJitter > 1 {
+		c.RetryAfterJitter = 0
+	}
+	if c.RetryAfterMargin < 0 {
+		c.RetryAfterMargin = 0
+	}
+	if c.UpstreamWindow < time.Second {
+		c.UpstreamWindow = 10 * time.Second
+	}
+	if c.UpstreamPenalty < 2 {
+		c.UpstreamPenalty = 2
+	}
+	if c.LoadThreshold < 0 {
+		c.LoadThreshold = 0
+	}
+	if c.LoadInterval <= 0 {
+		c.LoadInterval = time.Second
+	}
+	if c.LoadMaxPenalty < 2 {
+		c.LoadMaxPenalty = 4
+	}
+	if c.DominantKeyShare < 0 || c.DominantKeyShare >= 1 {
+		c.DominantKeyShare = 0
+	}
+	if c.DominantKeyWindow < time.Second {
+		c.DominantKeyWindow = time.Minute
+	}
+	if c.IdempotentBurst < 0 {
+		c.IdempotentBurst = 0
+	}
+	if c.HashBuckets < 0 {
+		c.HashBuckets = 0
+	}
+	if c.KeyCacheTTL < 0 {
+		c.KeyCacheTTL = 0
+	}
+	if c.KeyCacheSize <= 0 {
+		c.KeyCacheSize = 10000
+	}
+	if c.IdempotencyKeyTTL < 0 {
+		c.IdempotencyKeyTTL = 0
+	}
+	if c.IdempotencyCacheSize <= 0 {
+		c.IdempotencyCacheSize = 10000
+	}
+	if c.IdempotencyMaxReplays <= 0 {
+		c.IdempotencyMaxReplays = 3
+	}
+	if c.MaxDistinctResources < 0 {
+		c.MaxDistinctResources = 0
+	}
+	if c.DistinctResourceWindow < time.Second {
+		c.DistinctResourceWindow = time.Minute
+	}
+	if c.RegularityThreshold < 0 {
+		c.RegularityThreshold = 0
+	}
+	if c.RegularityPenalty < 2 {
+		c.RegularityPenalty = 2
+	}
+	if c.EscalationThreshold < 0 {
+		c.EscalationThreshold = 0
+	}
+	if c.EscalationStatus < 400 || c.EscalationStatus > 599 {
+		c.EscalationStatus = http.StatusTooManyRequests
+	}
+	if c.EscalationRetryAfter <= 0 {
+		c.EscalationRetryAfter = time.Minute
+	}
+	if c.BanThreshold < 0 {
+		c.BanThreshold = 0
+	}
+	if c.BanWindow < time.Second {
+		c.BanWindow = time.Minute
+	}
+	if c.BanDuration < time.Second {
+		c.BanDuration = 10 * time.Minute
+	}
+	if c.BanStatus < 400 || c.BanStatus > 499 {
+		c.BanStatus = http.StatusTooManyRequests
+	}
+	if c.OffenderWindow < 0 {
+		c.OffenderWindow = 0
+	}
+	if c.OnOffenders != nil && c.OffenderWindow == 0 {
+		c.OffenderWindow = time.Hour
+	}
+	if c.OffenderReportInterval < time.Second {
+		c.OffenderReportInterval = time.Minute
+	}
+	if c.OffenderReportSize <= 0 {
+		c.OffenderReportSize = 10
+	}
+	if c.RejectionStatusCode < 400 || c.RejectionStatusCode > 599 {
+		c.RejectionStatusCode = http.StatusTooManyRequests
+	}
+	if c.RejectionContentType == "" {
+		c.RejectionContentType = "text/plain; charset=utf-8"
+	}
+	if c.BypassHeader == "" {
+		c.BypassHeader = "X-RateLimit-Bypass"
+	}
+	if c.BypassMaxAge <= 0 {
+		c.BypassMaxAge = time.Minute
+	}
+	if c.ReloadInterval < 0 {
+		c.ReloadInterval = 0
+	} else if c.ReloadInterval > 0 && c.ReloadInterval < time.Second {
+		c.ReloadInterval = time.Second
+	}
+	if c.AccelerationThreshold < 0 {
+		c.AccelerationThreshold = 0
+	}
+	if c.AccelerationWindow < 100*time.Millisecond {
+		c.AccelerationWindow = time.Second
+	}
+	if c.AccelerationPenalty < 2 {
+		c.AccelerationPenalty = 2
+	}
+	if c.InstanceIDHeader == "" {
+		c.InstanceIDHeader = "X-RateLimit-Instance"
+	}
 }
 
 // RateLimiter represents a rate limiter instance
 type RateLimiter struct {
-	config   *Config
-	visitors map[string]*visitor
-	mx       sync.Mutex
+	config    *Config
+	store     Store
+	memory    *MemoryStore // per-visitor state, and the store unless Config.Store is set
+	denies    *denyCounter
+	counts    decisionCounters
+	totals    atomic.Pointer[map[string]*decisionCounters] // by route pattern, never reset
+	cleanup   atomic.Int64                                 // duration of the last cleanup run
+	upstream  *upstreamHealth
+	networks  []networkLimit  // most specific first
+	load      *loadController // nil without LoadFunc and LoadThreshold
+	dominant  *dominanceDetector
+	idem      *idempotencyCache
+	keys      *keyCache    // nil without KeyFunc, KeyCacheFunc and KeyCacheTTL
+	skew      atomic.Int64 // the shared store's clock minus ours, see observeStoreTime
+	started   time.Time
+	trusted   *ipList
+	routes    atomic.Pointer[[]Route] // Config.Routes, replaced by ApplyConfig
+	dynamic   *dynamicRoutes          // paths with buckets of their own under PerPath routes
+	penalties *penaltyBox             // nil without PenaltyBoxSize
+	allowlist *ipList
+	denylist  *ipList
+	bans      *banTracker
+	quotas    *quotaTracker // nil without Quotas
+	limits    *limitTracker
+	offenders *offenderTracker // nil without OffenderWindow
+	bypasses  bypassTokens
+	health    storeHealth
+	global    *rate.Limiter             // nil without GlobalRequestsPerSecond
+	defaults  atomic.Pointer[LimitSpec] // top-level rate and burst, changed by SetLimit
+
+	rejectionBody *texttemplate.Template // parsed RejectionBody, nil if unset or invalid
+
+	source      *configSource // set by NewFromFile
+	reconfigure sync.Mutex    // serializes ApplyConfig
+
+	closeOnce sync.Once
+	done      chan struct{} // closed by Close to stop cleanup
+	closed    atomic.Bool
 }
 
 type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+	limiter   *rate.Limiter // nil in CountOnly mode
+	lastSeen  atomicTime    // atomic, as is requests, for lookups without the shard lock
+	requests  atomic.Uint64
+	boost     *boost
+	boosted   atomic.Bool          // boost is set
+	resources map[string]time.Time // resource ID -> last access
+	arrivals  *arrivalStats
+	trend     *rateTrend
+	window    *windowState  // nil unless a window based algorithm is used
+	tat       time.Time     // theoretical arrival time with AlgorithmGCRA
+	pinned    time.Time     // kept by cleanup until then, set by SetVisitorLimit
+	elem      *list.Element // position in the shard's LRU list with MaxVisitors
+
+	consecutiveDenials int
+	denials            uint64 // requests denied since the visitor was created
+	custom             bool   // limit set by SetKeyRate or SetLimitFor, kept by SetLimit
+}
+
+// newVisitor returns a visitor using limiter, last seen at now
+func newVisitor(limiter *rate.Limiter, now time.Time) *visitor {
+	v := &visitor{limiter: limiter}
+	v.lastSeen.Store(now)
+	return v
 }
 
 // New creates a new RateLimiter instance with the given configuration
@@ -68,56 +755,867 @@ func New(cfg *Config) *RateLimiter {
 	cfg.Validate()
 
 	rl := &RateLimiter{
-		config:   cfg,
-		visitors: make(map[string]*visitor),
+		config:    cfg,
+		store:     cfg.Store,
+		denies:    newDenyCounter(cfg.DenyRateWindow),
+		started:   cfg.Clock.Now(),
+		done:      make(chan struct{}),
+		trusted:   newIPList(cfg.TrustedProxies),
+		allowlist: newIPList(cfg.Allowlist),
+		denylist:  newIPList(cfg.Denylist),
+		bans:      newBanTracker(),
+		limits:    newLimitTracker(cfg.Limits),
+		networks:  newNetworkLimits(cfg.NetworkLimits),
+		dynamic:   newDynamicRoutes(cfg.MaxDynamicRoutes),
+	}
+	rl.health.breaker.threshold, rl.health.breaker.retry = cfg.StoreBreakerThreshold, cfg.StoreRetryInterval
+	totals := map[string]*decisionCounters{"": {}}
+	for _, route := range cfg.Routes {
+		totals[route.Pattern] = &decisionCounters{}
+	}
+	rl.totals.Store(&totals)
+	rl.routes.Store(&cfg.Routes)
+	if ms, ok := cfg.Store.(*MemoryStore); ok {
+		rl.memory = ms
+	} else {
+		rl.memory = NewMemoryStore()
+	}
+	rl.memory.clock = cfg.Clock
+	rl.memory.workers = cfg.CleanupWorkers
+	if rl.store == nil {
+		rl.store = rl.memory
+	}
+	rl.defaults.Store(&LimitSpec{RequestsPerSecond: cfg.RequestsPerSecond, Burst: cfg.Burst})
+	if cfg.RejectionBody != "" {
+		rl.rejectionBody, _ = texttemplate.New("rejection").Parse(cfg.RejectionBody)
+	}
+	if cfg.GlobalRequestsPerSecond > 0 {
+		rl.global = rate.NewLimiter(rate.Limit(cfg.GlobalRequestsPerSecond), cfg.GlobalBurst)
+	}
+	if len(cfg.Quotas) > 0 {
+		rl.quotas = newQuotaTracker(cfg.Quotas)
+	}
+	if cfg.OffenderWindow > 0 {
+		rl.offenders = newOffenderTracker(cfg.OffenderWindow)
+	}
+	if cfg.MaxVisitors > 0 {
+		rl.memory.limitVisitors(cfg.MaxVisitors)
+	}
+	if cfg.SyncVisitorMap {
+		rl.memory.syncVisitors()
+	}
+	if cfg.PenaltyBoxSize > 0 {
+		rl.penalties = newPenaltyBox(cfg.PenaltyBoxSize, cfg.PenaltyBoxTTL)
+	}
+	if cfg.OnNewVisitor != nil || cfg.OnEvict != nil || rl.penalties != nil {
+		rl.memory.setHooks(&visitorHooks{onNew: cfg.OnNewVisitor, onEvict: cfg.OnEvict, penalties: rl.penalties})
+	}
+	if cfg.UpstreamErrorThreshold > 0 {
+		rl.upstream = newUpstreamHealth(cfg.UpstreamErrorThreshold, cfg.UpstreamWindow)
+	}
+	if cfg.DominantKeyShare > 0 {
+		onDominant := cfg.OnDominantKey
+		if onDominant == nil && cfg.Logger != nil {
+			onDominant = func(key string, share float64) {
+				cfg.Logger.Warn("ratelimiter: dominant key", slog.String("key", key), slog.Float64("share", share))
+			}
+		}
+		// with nowhere to report to, dominant keys aren't tracked at all
+		if onDominant != nil {
+			rl.dominant = newDominanceDetector(cfg.DominantKeyShare, cfg.DominantKeyWindow, onDominant)
+		}
+	}
+	if cfg.KeyFunc != nil && cfg.KeyCacheFunc != nil && cfg.KeyCacheTTL > 0 {
+		rl.keys = newKeyCache(cfg.KeyCacheTTL, cfg.KeyCacheSize)
+	}
+	if cfg.IdempotencyKeyTTL > 0 {
+		rl.idem = newIdempotencyCache(cfg.IdempotencyKeyTTL, cfg.IdempotencyCacheSize, cfg.IdempotencyMaxReplays)
 	}
 
 	go rl.cleanupVisitors()
+	if cfg.OnOffenders != nil {
+		go rl.reportOffenders()
+	}
+	if cfg.LoadFunc != nil && cfg.LoadThreshold > 0 {
+		rl.load = &loadController{}
+		go rl.watchLoad()
+	}
 	return rl
 }
 
-// getVisitor returns or creates a rate limiter for the given IP
-func (rl *RateLimiter) getVisitor(ip string) *rate.Limiter {
-	rl.mx.Lock()
-	defer rl.mx.Unlock()
+// getVisitor returns or creates the rate limiter for the given key, using
+// limit and burst for newly created limiters. With a shared store the limiter
+// only carries the key's limit and burst; tokens are taken from the store.
+func (rl *RateLimiter) getVisitor(key string, limit rate.Limit, burst int) *rate.Limiter {
+	shard := rl.memory.shard(key)
+	if rl.memory.lockFree.Load() {
+		// a visitor evicted meanwhile takes this request with it, as it would
+		// had the request come in just before the eviction
+		if v, exists := shard.visitors.get(key); exists && !v.boosted.Load() {
+			v.lastSeen.Store(rl.now())
+			v.requests.Add(1)
+			return v.limiter
+		}
+	}
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(rate.NewLimiter(limit, burst), rl.now())
+		v.requests.Store(1)
+		if rl.penalties != nil {
+			rl.penalties.restore(key, v, v.lastSeen.Load())
+		}
+		shard.add(key, v)
+		return v.limiter
+	}
+	now := rl.now()
+	v.lastSeen.Store(now)
+	shard.touch(v)
+	v.requests.Add(1)
+	v.expireBoost(now)
+	return v.limiter
+}
+
+// lookupVisitor returns the limiter for key like getVisitor, but without
+// counting a request or marking the visitor as seen
+func (rl *RateLimiter) lookupVisitor(key string, limit rate.Limit, burst int) *rate.Limiter {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
 
-	v, exists := rl.visitors[ip]
+	v, exists := shard.visitors.get(key)
 	if !exists {
-		limiter := rate.NewLimiter(rate.Limit(rl.config.RequestsPerSecond), rl.config.Burst)
-		rl.visitors[ip] = &visitor{limiter: limiter, lastSeen: time.Now()}
-		return limiter
+		v = newVisitor(rate.NewLimiter(limit, burst), rl.now())
+		if rl.penalties != nil {
+			rl.penalties.restore(key, v, v.lastSeen.Load())
+		}
+		shard.add(key, v)
+		return v.limiter
 	}
-	v.lastSeen = time.Now()
+	v.expireBoost(rl.now())
 	return v.limiter
 }
 
-// cleanupVisitors periodically removes inactive visitors
+// countVisitor records a request for the given key without creating a limiter
+func (rl *RateLimiter) countVisitor(key string) {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(nil, rl.now())
+		v.requests.Store(1)
+		shard.add(key, v)
+		return
+	}
+	v.lastSeen.Store(rl.now())
+	shard.touch(v)
+	v.requests.Add(1)
+}
+
+// KeyCounts returns the number of requests seen for each tracked key. Keys
+// are forgotten once they are removed by cleanup.
+func (rl *RateLimiter) KeyCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	rl.memory.each(func(key string, v *visitor) {
+		counts[key] = v.requests.Load()
+	})
+	return counts
+}
+
+// SetKeyRate sets the limit for a client identity to count requests per the
+// given duration, so "30 requests per 5 minutes" becomes
+// SetKeyRate(identity, 30, 5*time.Minute, 30). A non-positive burst
+// defaults to count. The limit lasts until the visitor is removed by cleanup.
+func (rl *RateLimiter) SetKeyRate(identity string, count int, per time.Duration, burst int) {
+	limit := rate.Limit(0)
+	if count > 0 && per > 0 {
+		limit = rate.Every(per / time.Duration(count))
+	}
+	if burst <= 0 {
+		burst = count
+	}
+	rl.setKeyLimit(rl.storageKey(identity), limit, burst)
+}
+
+// Drain consumes every available token for a client identity so its next
+// request is denied. The bucket then refills at the visitor's configured
+// rate. This is mostly useful for testing how clients back off.
+func (rl *RateLimiter) Drain(identity string) {
+	key := rl.storageKey(identity)
+	limit, burst := rl.defaultLimit()
+	limiter := rl.lookupVisitor(key, limit, burst)
+	if limiter == nil {
+		return
+	}
+	now := rl.now()
+	if rl.localStore() && rl.windowed() {
+		res := rl.takeWindow(key, limiter.Burst(), 0, now, false)
+		if n := int(res.Remaining); n > 0 {
+			rl.takeWindow(key, limiter.Burst(), n, now, true)
+		}
+		return
+	}
+	if rl.localStore() && rl.gcra() {
+		res := rl.takeGCRA(key, limiter, 0, now, false)
+		if n := int(res.Remaining); n > 0 {
+			rl.takeGCRA(key, limiter, n, now, true)
+		}
+		return
+	}
+	if rl.localStore() {
+		if n := int(limiter.TokensAt(now)); n > 0 {
+			limiter.AllowN(now, n)
+		}
+		return
+	}
+	ctx := context.Background()
+	if res, ok, err := rl.storeGet(ctx, key, limiter); err == nil && ok && int(res.Remaining) > 0 {
+		rl.storeAllow(ctx, key, limiter, int(res.Remaining))
+	}
+}
+
+// cleanupVisitors periodically removes inactive visitors until Close is called
 func (rl *RateLimiter) cleanupVisitors() {
-	ticker := time.NewTicker(rl.config.CleanupInterval)
-	for range ticker.C {
-		rl.mx.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) >= rl.config.MaxIdleTime {
-				delete(rl.visitors, ip)
+	ticker := rl.config.Clock.NewTicker(rl.config.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			ctx := context.Background()
+			start := rl.now()
+			var before int
+			if rl.config.Logger != nil {
+				before = rl.memory.len()
+			}
+			rl.bans.expire(start, rl.config.BanWindow)
+			if rl.quotas != nil {
+				rl.quotas.expire(rl.storeNow(start))
+			}
+			rl.limits.expire(start)
+			if rl.offenders != nil {
+				rl.offenders.expire(start)
+			}
+			rl.bypasses.expire(start)
+			rl.dynamic.expire(start, rl.config.MaxIdleTime)
+			rl.memory.Cleanup(ctx, rl.config.MaxIdleTime)
+			if !rl.localStore() {
+				if err := rl.store.Cleanup(ctx, rl.config.MaxIdleTime); err != nil && rl.config.Logger != nil {
+					rl.config.Logger.Warn("ratelimiter: store cleanup failed", slog.Any("error", err))
+				}
 			}
+			elapsed := rl.now().Sub(start)
+			rl.cleanup.Store(int64(elapsed))
+			if rl.config.Logger != nil {
+				after := rl.memory.len()
+				rl.config.Logger.Debug("ratelimiter: cleanup",
+					slog.Int("removed", max(0, before-after)),
+					slog.Int("visitors", after),
+					slog.Duration("duration", elapsed),
+				)
+			}
+		case <-rl.done:
+			return
 		}
-		rl.mx.Unlock()
 	}
 }
 
+// Close stops the cleanup goroutine and forgets every visitor. Afterwards
+// the middleware passes all requests through unlimited. A shared Store is
+// left untouched, as it may be in use by other limiters. Close is safe to
+// call more than once.
+func (rl *RateLimiter) Close() error {
+	rl.closeOnce.Do(func() {
+		rl.closed.Store(true)
+		close(rl.done)
+
+		rl.memory.clear()
+	})
+	return nil
+}
+
+// localStore reports whether buckets live in the in-process MemoryStore
+func (rl *RateLimiter) localStore() bool {
+	return rl.store == Store(rl.memory)
+}
+
+// take takes n tokens for key and returns the bucket's state. The in-process
+// store uses limiter directly; shared stores are passed its limit and burst
+// so per-key limits still apply. Store errors, and requests made while the
+// store's circuit breaker is open, are handled by FailurePolicy.
+func (rl *RateLimiter) take(ctx context.Context, key string, limiter *rate.Limiter, n int, now time.Time) Result {
+	if rl.localStore() {
+		if rl.windowed() {
+			return rl.takeWindow(key, limiter.Burst(), n, now, true)
+		}
+		if rl.gcra() {
+			return rl.takeGCRA(key, limiter, n, now, true)
+		}
+		return limiterResult(limiter, now, n, limiter.AllowN(now, n))
+	}
+	if !rl.health.breaker.allow(now) {
+		return rl.applyFailurePolicy(limiter, n, now, true)
+	}
+	res, err := rl.storeAllow(ctx, key, limiter, n)
+	if err != nil {
+		return rl.storeFailed("allow", key, err, limiter, n, now, true)
+	}
+	rl.storeSucceeded()
+	return res
+}
+
+// peek reports whether key has n tokens available without taking them
+func (rl *RateLimiter) peek(ctx context.Context, key string, limiter *rate.Limiter, n int, now time.Time) Result {
+	if rl.localStore() {
+		if rl.windowed() {
+			return rl.takeWindow(key, limiter.Burst(), n, now, false)
+		}
+		if rl.gcra() {
+			return rl.takeGCRA(key, limiter, n, now, false)
+		}
+		return limiterResult(limiter, now, n, limiter.TokensAt(now) >= float64(n))
+	}
+	if !rl.health.breaker.allow(now) {
+		return rl.applyFailurePolicy(limiter, n, now, false)
+	}
+	res, ok, err := rl.storeGet(ctx, key, limiter)
+	if err != nil {
+		return rl.storeFailed("get", key, err, limiter, n, now, false)
+	}
+	rl.storeSucceeded()
+	if !ok {
+		return Result{Allowed: true, Remaining: float64(limiter.Burst()), Limit: limiter.Limit(), Burst: limiter.Burst()}
+	}
+	res.RetryAfter = tokenWait(res.Remaining, res.Limit, n)
+	res.Allowed = res.RetryAfter == 0
+	return res
+}
+
 // Middleware creates a new rate limiting middleware
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
-		limiter := rl.getVisitor(ip)
-		if !limiter.Allow() {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		if rl.closed.Load() {
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		if denied, allowed := rl.listed(r); denied {
+			rl.denyHeaders(w, rl.requestID(r))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if allowed || Bypassed(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		} else if rl.signedBypass(r) {
+			// Mark the request so limiters further in don't spend the
+			// single-use signature again
+			next.ServeHTTP(w, r.WithContext(WithBypass(r.Context())))
+			return
+		}
+		if rl.config.UnusualMethodPolicy != MethodPolicyLimit && isUnusualMethod(r.Method) {
+			if rl.config.UnusualMethodPolicy == MethodPolicyReject {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := rl.identify(r)
+		if rl.dominant != nil {
+			rl.dominant.observe(identity, rl.now())
+		}
+		clientKey := rl.storageKey(identity)
+		if rl.config.CountOnly {
+			rl.countVisitor(clientKey)
+			rl.recordDecision(rl.now(), nil, false)
+			next.ServeHTTP(w, r)
+			return
+		}
+		// denied records a failed check that isn't enforced, so the request
+		// is still counted as a single decision
+		denied := false
+		if remaining := rl.bans.banned(clientKey, rl.now()); remaining > 0 {
+			rl.recordOffense(clientKey, rl.now())
+			if rl.enforcing(rl.now()) {
+				rl.recordDecision(rl.now(), nil, true)
+				rl.denyBanned(w, r, remaining)
+				return
+			}
+			denied = true
+		}
+		path := r.URL.Path
+		if rl.config.NormalizePaths {
+			path = normalizePath(path)
+		}
+		route := rl.matchRoute(path)
+		var tierName string
+		var tier *Tier
+		var network *networkLimit
+		if route == nil {
+			tierName, tier = rl.resolveTier(r)
+		}
+		if route == nil && tier == nil {
+			network = rl.matchNetwork(r)
+		}
+		if tier != nil && tier.Unlimited {
+			rl.recordDecision(rl.now(), nil, denied)
+			rl.serve(w, r, next)
+			return
+		}
+		key, limit, burst := rl.bucket(clientKey, route, path, tierName, tier, network, r.Method)
+		limiter := rl.getVisitor(key, limit, burst)
+		if len(rl.config.ChargeStatuses) > 0 {
+			rl.serveCharged(w, r, next, route, identity, key, limiter, denied)
+			return
+		}
+
+		now := rl.now()
+		if rl.config.ResourceFunc != nil && rl.config.MaxDistinctResources > 0 {
+			if resource := rl.config.ResourceFunc(r); resource != "" && !rl.admitResource(key, resource, now) {
+				if rl.enforcing(now) {
+					rl.recordDecision(now, route, true)
+					rl.denyKey(w, r, key, newLimitInfo(identity, limiter, Result{}))
+					return
+				}
+				denied = true
+			}
+		}
+
+		var idemKey string
+		if rl.idem != nil {
+			idemKey = rl.idem.cacheKey(key, r)
+		}
+		if idemKey != "" && rl.idem.replay(idemKey, r, now) {
+			rl.recordDecision(now, route, denied)
+			rl.serve(w, r, next)
+			return
+		}
+
+		weight := rl.requestWeight(r)
+		if weight > limiter.Burst() && rl.enforcing(now) {
+			rl.recordDecision(now, route, true)
+			rl.denyCost(w, r)
+			return
+		}
+		penalty := max(rl.requestCost(limiter.Burst()), rl.heuristicCost(key, now, limiter.Burst()))
+		cost := weightedCost(weight, penalty, limiter.Burst())
+		rsv, wait, ok := rl.reserveGlobal(now, cost)
+		if !ok {
+			if rl.enforcing(now) {
+				rl.recordDecision(now, route, true)
+				rl.deny(w, r, rl.globalLimitInfo(identity, wait))
+				return
+			}
+			denied = true
+			rl.logDenial(r, rl.globalLimitInfo(identity, wait), false)
+		}
+		usage, quotaOK := rl.takeQuota(clientKey, cost, now)
+		if !quotaOK {
+			rl.countDenial(key)
+			rl.recordOffense(clientKey, now)
+			if rl.enforcing(now) {
+				rl.recordDecision(now, route, true)
+				releaseGlobal(rsv, now)
+				rl.denyKey(w, r, key, rl.quotaLimitInfo(identity, usage, now))
+				return
+			}
+			denied = true
+			rl.logDenial(r, rl.quotaLimitInfo(identity, usage, now), false)
+		}
+		limitRsvs, failed, limitWait, limitsOK := rl.limits.reserve(clientKey, cost, now)
+		if !limitsOK {
+			rl.countDenial(key)
+			rl.recordOffense(clientKey, now)
+			if rl.enforcing(now) {
+				rl.recordDecision(now, route, true)
+				releaseGlobal(rsv, now)
+				rl.refundQuota(clientKey, cost, now)
+				rl.denyKey(w, r, key, compositeLimitInfo(identity, failed, limitWait))
+				return
+			}
+			denied = true
+			rl.logDenial(r, compositeLimitInfo(identity, failed, limitWait), false)
+		}
+		dimRsvs, dim, dimKey, dimWait, dimsOK := rl.reserveDimensions(r, cost, now)
+		if !dimsOK {
+			rl.countDenial(key)
+			rl.recordOffense(clientKey, now)
+			if rl.enforcing(now) {
+				rl.recordDecision(now, route, true)
+				releaseGlobal(rsv, now)
+				releaseLimits(limitRsvs, now)
+				rl.refundQuota(clientKey, cost, now)
+				rl.denyKey(w, r, key, dimensionLimitInfo(dim, dimKey, dimWait))
+				return
+			}
+			denied = true
+			rl.logDenial(r, dimensionLimitInfo(dim, dimKey, dimWait), false)
+		}
+		var res Result
+		var refund func(time.Time)
+		if len(rl.config.RefundStatuses) > 0 {
+			res, refund = rl.takeRefundable(r.Context(), key, limiter, cost, now)
+		} else {
+			res = rl.take(r.Context(), key, limiter, cost, now)
+		}
+		if !res.Allowed && rl.config.Mode == ModeWait && rl.enforcing(now) {
+			var err error
+			if res, err = rl.waitTake(r.Context(), key, limiter, cost, res); err != nil {
+				releaseGlobal(rsv, now)
+				releaseLimits(limitRsvs, now)
+				releaseLimits(dimRsvs, now)
+				rl.refundQuota(clientKey, cost, now)
+				rl.recordDecision(now, route, true)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if !res.Allowed {
+			releaseGlobal(rsv, now)
+			releaseLimits(limitRsvs, now)
+			releaseLimits(dimRsvs, now)
+			if quotaOK {
+				rl.refundQuota(clientKey, cost, now)
+			}
+		}
+		rl.recordDecision(now, route, denied || !res.Allowed)
+		rl.writeLimitHeaders(w.Header(), res)
+		if res.Allowed && quotaOK {
+			rl.writeQuotaHeaders(w.Header(), usage)
+		}
+		if !res.Allowed && !res.failed {
+			rl.countDenial(key)
+			rl.recordViolation(clientKey, now)
+			rl.recordOffense(clientKey, now)
+		}
+		if !res.Allowed && !rl.enforcing(now) {
+			rl.logDenial(r, newLimitInfo(identity, limiter, res), false)
+		}
+		if !res.Allowed && rl.enforcing(now) {
+			rl.denyKey(w, r, key, newLimitInfo(identity, limiter, res))
+			return
+		}
+		if res.Allowed {
+			rl.trackDenials(key, false)
+		}
+		if idemKey != "" {
+			rl.idem.remember(idemKey, r, now)
+		}
+		if refund != nil {
+			rl.serveRefundable(w, r, next, func(at time.Time) {
+				refund(at)
+				releaseGlobal(rsv, at)
+				releaseLimits(limitRsvs, at)
+				releaseLimits(dimRsvs, at)
+				if quotaOK {
+					rl.refundQuota(clientKey, cost, at)
+				}
+			})
+			return
+		}
+		rl.serve(w, r, next)
 	})
 }
 
+// enforcing reports whether denials are enforced, which they aren't in dry
+// run mode or while the limiter is warming up
+func (rl *RateLimiter) enforcing(now time.Time) bool {
+	return !rl.config.DryRun && now.Sub(rl.started) >= rl.config.WarmupDuration
+}
+
+// identify returns the identity a request is limited by: the key set with
+// WithKey or the KeyFunc result if there is one, otherwise the client's
+// network prefix
+func (rl *RateLimiter) identify(r *http.Request) string {
+	if key, ok := KeyFromContext(r.Context()); ok {
+		return key
+	}
+	if rl.config.KeyFunc != nil {
+		if key := rl.keyFor(r); key != "" {
+			return key
+		}
+	}
+	return rl.prefixKey(rl.clientIP(r))
+}
+
+// storageKey maps an identity to the key its state is stored under
+func (rl *RateLimiter) storageKey(identity string) string {
+	key := rl.secretKey(identity)
+	if rl.config.HashBuckets > 0 {
+		key = hashBucket(key, rl.config.HashBuckets)
+	}
+	return key
+}
+
+// serve passes an admitted request on to next, reporting its outcome to the
+// upstream health tracker when enabled
+func (rl *RateLimiter) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if rl.config.DocsURL != "" && rl.config.AlwaysLinkDocs {
+		rl.linkDocs(w)
+	}
+	if rl.upstream == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	sw := newStatusWriter(w)
+	next.ServeHTTP(sw, r)
+	rl.ReportUpstream(sw.status >= http.StatusInternalServerError)
+}
+
+// AllowKey makes a single decision for a client identity outside of HTTP,
+// such as an API key or peer address, returning how long to wait before
+// retrying when it is denied. The identity goes through the same KeySecret
+// and HashBuckets mapping as the middleware's keys.
+func (rl *RateLimiter) AllowKey(identity string) (bool, time.Duration) {
+	ok, wait, _ := rl.reserveKey(rl.storageKey(identity), 1, rl.now())
+	return ok, wait
+}
+
+// reserveKey makes a single decision for n tokens for key, returning how
+// long to wait before retrying when it is denied and, when it is allowed,
+// the function giving the tokens back. Buckets in a shared Store can't be
+// refunded.
+func (rl *RateLimiter) reserveKey(key string, n int, now time.Time) (bool, time.Duration, func(time.Time)) {
+	noop := func(time.Time) {}
+	if rl.closed.Load() {
+		return true, 0, noop
+	}
+	if rl.config.CountOnly {
+		rl.countVisitor(key)
+		rl.recordDecision(now, nil, false)
+		return true, 0, noop
+	}
+	// a failed check that isn't enforced still counts as one decision
+	denied := false
+	if remaining := rl.bans.banned(key, now); remaining > 0 {
+		rl.recordOffense(key, now)
+		if rl.enforcing(now) {
+			rl.recordDecision(now, nil, true)
+			return false, remaining, nil
+		}
+		denied = true
+	}
+	limit, burst := rl.defaultLimit()
+	limiter := rl.getVisitor(key, limit, burst)
+	if n > limiter.Burst() && rl.enforcing(now) {
+		rl.recordDecision(now, nil, true)
+		return false, time.Duration(math.MaxInt64), nil
+	}
+	cost := weightedCost(n, rl.requestCost(limiter.Burst()), limiter.Burst())
+	rsv, wait, ok := rl.reserveGlobal(now, cost)
+	if !ok {
+		if rl.enforcing(now) {
+			rl.recordDecision(now, nil, true)
+			return false, wait, nil
+		}
+		denied = true
+	}
+	usage, quotaOK := rl.takeQuota(key, cost, now)
+	if !quotaOK {
+		rl.countDenial(key)
+		rl.recordOffense(key, now)
+		if rl.enforcing(now) {
+			rl.recordDecision(now, nil, true)
+			releaseGlobal(rsv, now)
+			return false, usage.reset.Sub(now), nil
+		}
+		denied = true
+	}
+	limitRsvs, _, limitWait, limitsOK := rl.limits.reserve(key, cost, now)
+	if !limitsOK {
+		rl.countDenial(key)
+		rl.recordOffense(key, now)
+		if rl.enforcing(now) {
+			rl.recordDecision(now, nil, true)
+			releaseGlobal(rsv, now)
+			rl.refundQuota(key, cost, now)
+			return false, limitWait, nil
+		}
+		denied = true
+	}
+	res, refund := rl.takeRefundable(context.Background(), key, limiter, cost, now)
+	if !res.Allowed {
+		releaseGlobal(rsv, now)
+		releaseLimits(limitRsvs, now)
+		if quotaOK {
+			rl.refundQuota(key, cost, now)
+		}
+	}
+	rl.recordDecision(now, nil, denied || !res.Allowed)
+	if !res.Allowed && !res.failed {
+		rl.countDenial(key)
+		rl.recordViolation(key, now)
+		rl.recordOffense(key, now)
+	}
+	if !res.Allowed && rl.enforcing(now) {
+		return false, res.RetryAfter, nil
+	}
+	if refund == nil {
+		return true, 0, noop
+	}
+	return true, 0, func(at time.Time) {
+		refund(at)
+		releaseGlobal(rsv, at)
+		releaseLimits(limitRsvs, at)
+		if quotaOK {
+			rl.refundQuota(key, cost, at)
+		}
+	}
+}
+
+// bucket returns the visitor key, limit and burst for a request. Requests
+// matching a route are tracked in that route's bucket, or the bucket of
+// their path under a PerPath route, requests with a tier in that tier's
+// bucket, requests from a limited network in that network's bucket, and
+// requests with a method override in that method's bucket.
+func (rl *RateLimiter) bucket(key string, route *Route, path, tierName string, tier *Tier, network *networkLimit, method string) (string, rate.Limit, int) {
+	if route != nil {
+		key += "|route:" + route.Pattern
+		if route.PerPath && rl.dynamic.admit(route.Pattern, path, rl.now()) {
+			key += "|path:" + path
+		}
+		if spec, ok := route.MethodOverrides[method]; ok {
+			return key + "|method:" + method, rate.Limit(spec.RequestsPerSecond), spec.Burst
+		}
+		return key, rate.Limit(route.RequestsPerSecond), route.Burst
+	}
+	if tier != nil {
+		return key + "|tier:" + tierName, rate.Limit(tier.RequestsPerSecond), tier.Burst
+	}
+	if network != nil {
+		return key + "|net:" + network.prefix.String(), rate.Limit(network.RequestsPerSecond), network.Burst
+	}
+	if spec, ok := rl.config.MethodOverrides[method]; ok {
+		return key + "|method:" + method, rate.Limit(spec.RequestsPerSecond), spec.Burst
+	}
+	limit, _ := rl.defaultLimit()
+	key, burst := rl.methodBucket(key, method)
+	return key, limit, burst
+}
+
+// methodBucket returns the visitor key and burst for a request. With
+// IdempotentBurst set, idempotent methods are tracked in a separate bucket.
+func (rl *RateLimiter) methodBucket(key, method string) (string, int) {
+	_, burst := rl.defaultLimit()
+	if rl.config.IdempotentBurst == 0 {
+		return key, burst
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return key + "|idempotent", rl.config.IdempotentBurst
+	}
+	return key, burst
+}
+
+// serveCharged admits the request if the visitor has a token left and only
+// consumes it once the handler has responded with a charged status.
+// Concurrent requests may all be admitted before any of them is charged.
+// Quotas, composite limits, dimensions and distinct resources are skipped.
+// denied reports an earlier check that failed without being enforced.
+func (rl *RateLimiter) serveCharged(w http.ResponseWriter, r *http.Request, next http.Handler, route *Route, identity, key string, limiter *rate.Limiter, denied bool) {
+	now := rl.now()
+	weight := rl.requestWeight(r)
+	if weight > limiter.Burst() && rl.enforcing(now) {
+		rl.recordDecision(now, route, true)
+		rl.denyCost(w, r)
+		return
+	}
+	cost := weightedCost(weight, rl.requestCost(limiter.Burst()), limiter.Burst())
+	rsv, wait, ok := rl.reserveGlobal(now, cost)
+	if !ok {
+		if rl.enforcing(now) {
+			rl.recordDecision(now, route, true)
+			rl.deny(w, r, rl.globalLimitInfo(identity, wait))
+			return
+		}
+		denied = true
+	}
+	res := rl.peek(r.Context(), key, limiter, cost, now)
+	if !res.Allowed {
+		releaseGlobal(rsv, now)
+	}
+	rl.recordDecision(now, route, denied || !res.Allowed)
+	rl.writeLimitHeaders(w.Header(), res)
+	if !res.Allowed && !res.failed {
+		rl.countDenial(key)
+		rl.recordViolation(rl.storageKey(identity), now)
+		rl.recordOffense(rl.storageKey(identity), now)
+	}
+	if !res.Allowed && rl.enforcing(now) {
+		rl.denyKey(w, r, key, newLimitInfo(identity, limiter, res))
+		return
+	}
+	if res.Allowed {
+		rl.trackDenials(key, false)
+	}
+
+	sw := newStatusWriter(w)
+	rl.serve(sw, r, next)
+	if slices.Contains(rl.config.ChargeStatuses, sw.status) {
+		rl.take(r.Context(), key, limiter, cost, rl.now())
+	}
+}
+
+// deny writes the response for a rate limited request
+func (rl *RateLimiter) deny(w http.ResponseWriter, r *http.Request, info LimitInfo) {
+	if info.RequestID == "" {
+		info.RequestID = rl.requestID(r)
+	}
+	rl.logDenial(r, info, true)
+	if rl.config.OnDeny != nil {
+		rl.config.OnDeny(r, info)
+	}
+	rl.denyHeaders(w, info.RequestID)
+	info.RetryAfter = rl.padWait(info.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(info.RetryAfter)))
+	if rl.global != nil || rl.quotas != nil {
+		scope := "client"
+		if info.Global {
+			scope = "global"
+		} else if info.Quota != nil {
+			scope = "quota"
+		}
+		w.Header().Set("X-RateLimit-Scope", scope)
+	}
+	if info.Constraint != "" {
+		w.Header().Set("X-RateLimit-Constraint", info.Constraint)
+	}
+	if len(rl.config.Dimensions) > 0 && !info.Global && info.Quota == nil && info.Constraint == "" {
+		dimension := info.Dimension
+		if dimension == "" {
+			dimension = "default"
+		}
+		w.Header().Set("X-RateLimit-Dimension", dimension)
+	}
+	if info.Escalated {
+		rl.writeEscalated(w, r)
+		return
+	}
+	rl.writeDenial(w, r, info)
+}
+
+// denyHeaders sets the headers shared by every denied response, echoing
+// requestID unless it is empty
+func (rl *RateLimiter) denyHeaders(w http.ResponseWriter, requestID string) {
+	if requestID != "" {
+		w.Header().Set(rl.config.RequestIDHeader, requestID)
+	}
+	if rl.config.InstanceID != "" {
+		w.Header().Set(rl.config.InstanceIDHeader, rl.config.InstanceID)
+	}
+	if rl.config.DocsURL != "" {
+		rl.linkDocs(w)
+	}
+}
+
+// linkDocs points the client at the rate limit documentation
+func (rl *RateLimiter) linkDocs(w http.ResponseWriter) {
+	w.Header().Add("Link", "<"+rl.config.DocsURL+`>; rel="help"`)
+}
+
 // Global instance for backward compatibility
 var globalLimiter *RateLimiter
 
@@ -139,13 +1637,14 @@ func getClientIP(r *http.Request) string {
 	// X-Forwarded-For may contain multiple IPs, like: "client, proxy1, proxy2"
 	xForwardedFor := r.Header.Get("X-Forwarded-For")
 	if xForwardedFor != "" {
-		// Take the first IP in the list
-		ips := strings.Split(xForwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+		// Take the first IP in the list; Cut avoids allocating the whole list
+		first, _, _ := strings.Cut(xForwardedFor, ",")
+		return strings.TrimSpace(first)
 	}
 
-	// Fallback to X-Real-IP (used by some proxies)
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+	// Fallback to X-Real-IP (used by some proxies), spelled canonically so
+	// the lookup doesn't allocate
+	if realIP := r.Header.Get("X-Real-Ip"); realIP != "" {
 		return realIP
 	}
 
@@ -0,0 +1,272 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// discardWriter is a ResponseWriter that keeps nothing, so benchmarks
+// measure the limiter rather than the recorder
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkMiddlewareAllowed(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		xff  string
+	}{
+		{name: "remote addr", cfg: Config{OmitHeaders: true}},
+		{name: "trusted proxy", cfg: Config{OmitHeaders: true, TrustedProxies: []string{"192.0.2.0/24"}}, xff: "198.51.100.7"},
+		{name: "headers", cfg: Config{}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			cfg := tc.cfg
+			cfg.RequestsPerSecond, cfg.Burst = 1e9, 1e9
+			rl := New(&cfg)
+			defer rl.Close()
+			h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			w := &discardWriter{header: make(http.Header)}
+			// the visitor already exists on the measured path
+			h.ServeHTTP(w, r)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func TestSetKeyRateAdmitsCountPerPeriod(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 100, Burst: 100, Clock: clock})
+	defer rl.Close()
+	rl.SetKeyRate("a", 30, 5*time.Minute, 0)
+
+	allowed := 0
+	for rl.Allow("a") {
+		allowed++
+	}
+	if allowed != 30 {
+		t.Fatalf("%d requests admitted at once, want the burst of 30", allowed)
+	}
+
+	// a client trying every second for a whole period gets exactly 30 more
+	allowed = 0
+	for range 5 * 60 {
+		clock.Advance(time.Second)
+		if rl.Allow("a") {
+			allowed++
+		}
+	}
+	if allowed != 30 {
+		t.Errorf("%d requests admitted over 5 minutes, want 30", allowed)
+	}
+	if rl.Allow("a") {
+		t.Error("request beyond the rate admitted")
+	}
+	if !rl.Allow("b") {
+		t.Error("other keys should keep the configured rate")
+	}
+}
+
+func TestSetKeyRateBurst(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 100, Burst: 100, Clock: clock})
+	defer rl.Close()
+	rl.SetKeyRate("a", 30, 5*time.Minute, 5)
+
+	for i := range 6 {
+		if got := rl.Allow("a"); got != (i < 5) {
+			t.Fatalf("request %d: allowed = %v with a burst of 5", i, got)
+		}
+	}
+	clock.Advance(10*time.Second - time.Millisecond)
+	if rl.Allow("a") {
+		t.Fatal("admitted before the next 10s slot")
+	}
+	clock.Advance(time.Millisecond)
+	if !rl.Allow("a") {
+		t.Fatal("not admitted 10s, a thirtieth of 5 minutes, later")
+	}
+}
+
+func TestChargeStatusesChargeOnlyFailedLogins(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             3,
+		ChargeStatuses:    []int{http.StatusUnauthorized, http.StatusForbidden},
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	login := func(password string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login?password="+password, nil))
+		return rec.Code
+	}
+
+	for i := range 10 {
+		if code := login("secret"); code != http.StatusOK {
+			t.Fatalf("successful login %d: status %d, want 200", i, code)
+		}
+	}
+	for i := range 3 {
+		if code := login("guess"); code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: status %d, want 401", i, code)
+		}
+	}
+	if code := login("secret"); code != http.StatusTooManyRequests {
+		t.Fatalf("login after 3 failures: status %d, want 429", code)
+	}
+}
+
+func TestCountOnlyNeverDenies(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             1,
+		CountOnly:         true,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i := range 70 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if i >= 50 {
+			r.RemoteAddr = "198.51.100.7:1234"
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d in count-only mode", i, rec.Code)
+		}
+	}
+	for i := range 5 {
+		if !rl.Allow("api-key") {
+			t.Fatalf("Allow %d denied in count-only mode", i)
+		}
+	}
+
+	counts := rl.KeyCounts()
+	want := map[string]uint64{
+		rl.DeriveKey("192.0.2.1"):    50,
+		rl.DeriveKey("198.51.100.7"): 20,
+		rl.DeriveKey("api-key"):      5,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("KeyCounts = %v, want %v", counts, want)
+	}
+	for key, n := range want {
+		if counts[key] != n {
+			t.Errorf("%s counted %d times, want %d", key, counts[key], n)
+		}
+	}
+	if s := rl.Stats(); s.Allowed != 75 || s.Denied != 0 {
+		t.Errorf("Stats = %+v, want 75 allowed and none denied", s)
+	}
+}
+
+func TestIdempotentBurst(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             2,
+		IdempotentBurst:   6,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	admitted := func(method string) int {
+		n := 0
+		for range 10 {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+			if rec.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := admitted(http.MethodGet); n != 6 {
+		t.Errorf("%d GETs admitted, want the idempotent burst of 6", n)
+	}
+	if n := admitted(http.MethodPost); n != 2 {
+		t.Errorf("%d POSTs admitted from the same client, want the burst of 2", n)
+	}
+}
+
+func TestWarmupThenEnforce(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond: 0.001,
+		Burst:             1,
+		WarmupDuration:    time.Minute,
+		Clock:             clock,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	for i := range 5 {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("request %d during warmup: status %d", i, code)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	if s := rl.Stats(); s.Allowed != 1 || s.Denied != 4 {
+		t.Errorf("Stats after warmup = %+v, want the 4 requests over the limit counted as denied", s)
+	}
+	clock.Advance(10 * time.Second)
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Errorf("request after warmup: status %d, want 429", code)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmTokenBucket, AlgorithmGCRA} {
+		clock := NewManualClock(time.Unix(0, 0))
+		rl := New(&Config{RequestsPerSecond: 1, Burst: 5, Algorithm: algorithm, Clock: clock})
+		name, _ := algorithm.MarshalText()
+
+		rl.Allow("a")
+		rl.Drain("a")
+		if rl.Allow("a") {
+			t.Errorf("%s: request allowed right after Drain", name)
+		}
+		clock.Advance(999 * time.Millisecond)
+		if rl.Allow("a") {
+			t.Errorf("%s: request allowed before a token refilled", name)
+		}
+		clock.Advance(time.Millisecond)
+		if !rl.Allow("a") {
+			t.Errorf("%s: request denied a second after Drain at 1 per second", name)
+		}
+		if rl.Allow("a") {
+			t.Errorf("%s: second request allowed on one refilled token", name)
+		}
+		if !rl.Allow("b") {
+			t.Errorf("%s: Drain affected another key", name)
+		}
+		rl.Close()
+	}
+}
@@ -0,0 +1,280 @@
+package redisstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// debitScript takes n tokens from a bucket unconditionally, emptying it if
+// it holds fewer, and replies with the tokens left. It settles the requests
+// a CachedStore already admitted locally.
+var debitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.max(0, math.min(burst, tokens + math.max(0, now - ts) * rate) - n)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now), 'rate', tostring(rate), 'burst', burst, 'ttl', ttl)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return tostring(tokens)
+`)
+
+// cachedBucket is a CachedStore's local copy of a Redis bucket
+type cachedBucket struct {
+	limit    rate.Limit
+	burst    int
+	tokens   float64   // as of ts
+	ts       time.Time // when tokens was last refilled
+	pending  int       // tokens taken locally since the last sync
+	lastUsed time.Time
+}
+
+// refill brings the bucket's tokens up to now
+func (b *cachedBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.ts); elapsed > 0 && b.limit > 0 {
+		b.tokens = min(float64(b.burst), b.tokens+elapsed.Seconds()*float64(b.limit))
+	}
+	b.ts = now
+}
+
+// CachedStore is a ratelimiter.Store that admits requests against local
+// copies of the buckets in Redis and settles what it spent every sync
+// interval, in one pipelined batch, rather than on every request. Syncing a
+// key also picks up what other instances spent from it. Limits are enforced
+// approximately: between syncs the instances together may admit up to a
+// bucket's worth more than it holds. A key's first request goes to Redis
+// directly.
+type CachedStore struct {
+	store *Store
+	mx    sync.Mutex
+	keys  map[string]*cachedBucket
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+var _ ratelimiter.Store = (*CachedStore)(nil)
+
+// NewCached creates a CachedStore using client that syncs with Redis every
+// interval, such as 50ms. Keys are stored under prefix like with New, so
+// cached and uncached instances can share buckets. Call Close when done to
+// stop syncing and settle the last spends.
+func NewCached(client redis.UniversalClient, prefix string, interval time.Duration) *CachedStore {
+	cs := &CachedStore{
+		store: New(client, prefix),
+		keys:  make(map[string]*cachedBucket),
+		done:  make(chan struct{}),
+	}
+	cs.wg.Add(1)
+	go cs.syncLoop(interval)
+	return cs
+}
+
+// Allow implements ratelimiter.Store, deciding locally for keys already
+// cached
+func (cs *CachedStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (ratelimiter.Result, error) {
+	if limit == rate.Inf {
+		return ratelimiter.Result{Allowed: true, Remaining: float64(burst), Limit: limit, Burst: burst}, nil
+	}
+	now := time.Now()
+	cs.mx.Lock()
+	b, ok := cs.keys[key]
+	if !ok {
+		cs.mx.Unlock()
+		res, err := cs.store.Allow(ctx, key, limit, burst, n)
+		if err != nil {
+			return res, err
+		}
+		cs.mx.Lock()
+		if _, raced := cs.keys[key]; !raced {
+			cs.keys[key] = &cachedBucket{limit: limit, burst: burst, tokens: res.Remaining, ts: now, lastUsed: now}
+		}
+		cs.mx.Unlock()
+		return res, nil
+	}
+	defer cs.mx.Unlock()
+
+	b.limit, b.burst, b.lastUsed = limit, burst, now
+	b.refill(now)
+	res := ratelimiter.Result{Limit: limit, Burst: burst}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		b.pending += n
+		res.Allowed = true
+	} else {
+		res.RetryAfter = wait(b.tokens, limit, n)
+	}
+	res.Remaining = b.tokens
+	return res, nil
+}
+
+// Get implements ratelimiter.Store, answering from the local copy if there
+// is one
+func (cs *CachedStore) Get(ctx context.Context, key string) (ratelimiter.Result, bool, error) {
+	cs.mx.Lock()
+	if b, ok := cs.keys[key]; ok {
+		defer cs.mx.Unlock()
+		b.refill(time.Now())
+		return ratelimiter.Result{Allowed: true, Remaining: b.tokens, Limit: b.limit, Burst: b.burst}, true, nil
+	}
+	cs.mx.Unlock()
+	return cs.store.Get(ctx, key)
+}
+
+// Touch implements ratelimiter.Store
+func (cs *CachedStore) Touch(ctx context.Context, key string) error {
+	cs.mx.Lock()
+	if b, ok := cs.keys[key]; ok {
+		b.lastUsed = time.Now()
+	}
+	cs.mx.Unlock()
+	return cs.store.Touch(ctx, key)
+}
+
+// Cleanup implements ratelimiter.Store by dropping the local copies of keys
+// idle for at least maxIdle that have nothing left to settle. Redis expires
+// the buckets themselves.
+func (cs *CachedStore) Cleanup(ctx context.Context, maxIdle time.Duration) error {
+	now := time.Now()
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+	for key, b := range cs.keys {
+		if b.pending == 0 && now.Sub(b.lastUsed) >= maxIdle {
+			delete(cs.keys, key)
+		}
+	}
+	return nil
+}
+
+// Close stops syncing, settling what was spent since the last sync first
+func (cs *CachedStore) Close() error {
+	cs.once.Do(func() {
+		close(cs.done)
+		cs.wg.Wait()
+	})
+	return cs.Sync(context.Background())
+}
+
+// syncLoop syncs every interval until Close is called
+func (cs *CachedStore) syncLoop(interval time.Duration) {
+	defer cs.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cs.Sync(context.Background())
+		case <-cs.done:
+			return
+		}
+	}
+}
+
+// Sync settles the tokens spent locally with Redis and refreshes the local
+// copies of those keys with the bucket's state there, in one pipelined batch
+// of script calls. Keys with nothing to settle are left alone. It runs every
+// sync interval on its own. Keys that fail to sync keep their spends for the
+// next attempt; the first error is returned.
+func (cs *CachedStore) Sync(ctx context.Context) error {
+	cs.mx.Lock()
+	var spends []spend
+	for key, b := range cs.keys {
+		if b.pending > 0 {
+			spends = append(spends, spend{key, b.limit, b.burst, b.pending})
+			b.pending = 0
+		}
+	}
+	cs.mx.Unlock()
+	if len(spends) == 0 {
+		return nil
+	}
+
+	tokens, errs := cs.debit(ctx, spends)
+	now := time.Now()
+	var firstErr error
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+	for i, s := range spends {
+		if b, ok := cs.keys[s.key]; ok {
+			if errs[i] != nil {
+				b.pending += s.pending
+			} else {
+				// spends made while the scripts ran are still to be settled
+				b.tokens, b.ts = max(0, tokens[i]-float64(b.pending)), now
+			}
+		}
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	return firstErr
+}
+
+// spend is the tokens a key took locally since the last sync
+type spend struct {
+	key     string
+	limit   rate.Limit
+	burst   int
+	pending int
+}
+
+// debit takes each spend's tokens from its bucket in Redis in one pipeline,
+// returning the tokens left in each or why that failed. Scripts Redis hasn't
+// cached yet are sent again in full.
+func (cs *CachedStore) debit(ctx context.Context, spends []spend) ([]float64, []error) {
+	cmds := make([]*redis.Cmd, len(spends))
+	run := func(indexes []int, eval func(context.Context, redis.Scripter, []string, ...any) *redis.Cmd) {
+		pipe := cs.store.client.Pipeline()
+		for _, i := range indexes {
+			s := spends[i]
+			ttl := maxTTL
+			if s.limit > 0 {
+				ttl = min(maxTTL, time.Duration(float64(s.burst)/float64(s.limit)*float64(time.Second))+time.Second)
+			}
+			cmds[i] = eval(ctx, pipe, []string{cs.store.prefix + s.key}, float64(s.limit), s.burst, s.pending, ttl.Milliseconds())
+		}
+		pipe.Exec(ctx)
+	}
+
+	all := make([]int, len(spends))
+	for i := range all {
+		all[i] = i
+	}
+	run(all, debitScript.EvalSha)
+	var uncached []int
+	for i, cmd := range cmds {
+		if redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+			uncached = append(uncached, i)
+		}
+	}
+	if len(uncached) > 0 {
+		run(uncached, debitScript.Eval)
+	}
+
+	tokens := make([]float64, len(spends))
+	errs := make([]error, len(spends))
+	for i, cmd := range cmds {
+		reply, err := cmd.Result()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		tokens[i], errs[i] = replyFloat(reply)
+	}
+	return tokens, errs
+}
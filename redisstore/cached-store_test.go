@@ -0,0 +1,104 @@
+package redisstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// callCounter is a go-redis hook counting round trips and the commands in
+// them
+type callCounter struct {
+	mx        sync.Mutex
+	single    int
+	pipelines int
+	piped     int
+}
+
+func (c *callCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *callCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.mx.Lock()
+		c.single++
+		c.mx.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (c *callCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.mx.Lock()
+		c.pipelines++
+		c.piped += len(cmds)
+		c.mx.Unlock()
+		return next(ctx, cmds)
+	}
+}
+
+func (c *callCounter) reset() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.single, c.pipelines, c.piped = 0, 0, 0
+}
+
+func newTestClient(t *testing.T) (*redis.Client, *callCounter) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	counter := &callCounter{}
+	client.AddHook(counter)
+	return client, counter
+}
+
+func TestCachedStoreSyncsPendingKeysInOnePipeline(t *testing.T) {
+	client, counter := newTestClient(t)
+	ctx := context.Background()
+	cs := NewCached(client, "rl:", time.Hour)
+	defer cs.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := cs.Allow(ctx, key, 1, 10, 1); err != nil {
+			t.Fatalf("Allow(%s): %v", key, err)
+		}
+	}
+	// the first sync also has Redis cache the script
+	cs.Allow(ctx, "c", 1, 10, 1)
+	if err := cs.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for _, key := range []string{"a", "b", "b"} {
+		if res, err := cs.Allow(ctx, key, 1, 10, 1); err != nil || !res.Allowed {
+			t.Fatalf("local Allow(%s) = %+v, %v", key, res, err)
+		}
+	}
+
+	counter.reset()
+	if err := cs.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if counter.pipelines != 1 || counter.piped != 2 || counter.single != 0 {
+		t.Errorf("Sync made %d pipelines of %d commands and %d single calls, want one pipeline of 2",
+			counter.pipelines, counter.piped, counter.single)
+	}
+	res, ok, err := cs.store.Get(ctx, "b")
+	if err != nil || !ok {
+		t.Fatalf("Get(b) = %v, %v", ok, err)
+	}
+	if res.Remaining > 7.1 {
+		t.Errorf("b has %v tokens in Redis after 3 spends of 10, want about 7", res.Remaining)
+	}
+
+	counter.reset()
+	if err := cs.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if counter.pipelines != 0 || counter.single != 0 {
+		t.Errorf("Sync with nothing pending made %d pipelines and %d calls", counter.pipelines, counter.single)
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/gigatar/ratelimiter/redisstore
+
+go 1.24.2
+
+require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/gigatar/ratelimiter v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.7.3
+	golang.org/x/time v0.11.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/gigatar/ratelimiter => ../
@@ -0,0 +1,303 @@
+// Package redisstore provides a Redis backed ratelimiter.Store so several
+// instances of a service can share one set of token buckets.
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// allowScript refills and takes from a token bucket atomically. Time comes
+// from the Redis server so instances with skewed clocks agree, and is sent
+// back with the decision. Buckets expire
+// once they would have refilled completely, at which point they're
+// indistinguishable from a new bucket.
+var allowScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= n then
+  tokens = tokens - n
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now), 'rate', tostring(rate), 'burst', burst, 'ttl', ttl)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, tostring(tokens), tonumber(t[1]), tonumber(t[2])}
+`)
+
+// getScript reports a bucket's current tokens without taking any
+var getScript = redis.NewScript(`
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts', 'rate', 'burst')
+local tokens = tonumber(state[1])
+if tokens == nil then
+  return false
+end
+local ts = tonumber(state[2])
+local rate = tonumber(state[3])
+local burst = tonumber(state[4])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+return {tostring(tokens), tostring(rate), burst, tonumber(t[1]), tonumber(t[2])}
+`)
+
+// gcraScript runs the generic cell rate algorithm on a key holding only its
+// theoretical arrival time, in seconds. Keys expire once that time has
+// passed and they would admit a full burst again. It replies whether the
+// requests were allowed, the arrival time relative to now and the server's
+// time.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+local allowed = 0
+local nxt = tat + n * interval
+if nxt - burst * interval <= now then
+  allowed = 1
+  tat = nxt
+  if n > 0 then
+    redis.call('SET', KEYS[1], tostring(tat), 'PX', math.ceil((tat - now) * 1000) + 1000)
+  end
+end
+return {allowed, tostring(tat - now), tonumber(t[1]), tonumber(t[2])}
+`)
+
+// maxTTL bounds how long buckets that never refill are kept
+const maxTTL = 24 * time.Hour
+
+// Store is a ratelimiter.Store keeping token buckets in Redis
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ ratelimiter.GCRAStore = (*Store)(nil)
+
+// New creates a Store using client. Keys are stored under prefix, which lets
+// several limiters share one Redis without colliding.
+func New(client redis.UniversalClient, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+// Allow implements ratelimiter.Store
+func (s *Store) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (ratelimiter.Result, error) {
+	if limit == rate.Inf {
+		return ratelimiter.Result{Allowed: true, Remaining: float64(burst), Limit: limit, Burst: burst}, nil
+	}
+
+	ttl := maxTTL
+	if limit > 0 {
+		ttl = min(maxTTL, time.Duration(float64(burst)/float64(limit)*float64(time.Second))+time.Second)
+	}
+	vals, err := allowScript.Run(ctx, s.client, []string{s.prefix + key},
+		float64(limit), burst, n, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	if len(vals) != 4 {
+		return ratelimiter.Result{}, errUnexpectedReply
+	}
+	allowed, err := replyInt(vals[0])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	tokens, err := replyFloat(vals[1])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	at, err := replyTime(vals[2], vals[3])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+
+	res := ratelimiter.Result{
+		Allowed:   allowed == 1,
+		Remaining: tokens,
+		Limit:     limit,
+		Burst:     burst,
+		At:        at,
+	}
+	if !res.Allowed {
+		res.RetryAfter = wait(tokens, limit, n)
+	}
+	return res, nil
+}
+
+// AllowGCRA implements ratelimiter.GCRAStore with a single script call,
+// keeping each key's theoretical arrival time in a plain string next to its
+// token bucket
+func (s *Store) AllowGCRA(ctx context.Context, key string, limit rate.Limit, burst, n int) (ratelimiter.Result, error) {
+	if limit == rate.Inf || limit <= 0 || n > burst {
+		_, res := ratelimiter.GCRA(time.Time{}, time.Now(), limit, burst, n)
+		return res, nil
+	}
+
+	interval := 1 / float64(limit)
+	vals, err := gcraScript.Run(ctx, s.client, []string{s.prefix + key + "|gcra"}, interval, burst, n).Slice()
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	if len(vals) != 4 {
+		return ratelimiter.Result{}, errUnexpectedReply
+	}
+	allowed, err := replyInt(vals[0])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	ahead, err := replyFloat(vals[1])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+	at, err := replyTime(vals[2], vals[3])
+	if err != nil {
+		return ratelimiter.Result{}, err
+	}
+
+	res := ratelimiter.Result{
+		Allowed:   allowed == 1,
+		Remaining: max(0, float64(burst)-ahead/interval),
+		Limit:     limit,
+		Burst:     burst,
+		At:        at,
+	}
+	if !res.Allowed {
+		res.RetryAfter = time.Duration((ahead + float64(n)*interval - float64(burst)*interval) * float64(time.Second))
+	}
+	return res, nil
+}
+
+// Get implements ratelimiter.Store
+func (s *Store) Get(ctx context.Context, key string) (ratelimiter.Result, bool, error) {
+	vals, err := getScript.Run(ctx, s.client, []string{s.prefix + key}).Slice()
+	if errors.Is(err, redis.Nil) {
+		return ratelimiter.Result{}, false, nil
+	}
+	if err != nil {
+		return ratelimiter.Result{}, false, err
+	}
+	if len(vals) != 5 {
+		return ratelimiter.Result{}, false, errUnexpectedReply
+	}
+	tokens, err := replyFloat(vals[0])
+	if err != nil {
+		return ratelimiter.Result{}, false, err
+	}
+	limit, err := replyFloat(vals[1])
+	if err != nil {
+		return ratelimiter.Result{}, false, err
+	}
+	burst, err := replyInt(vals[2])
+	if err != nil {
+		return ratelimiter.Result{}, false, err
+	}
+	at, err := replyTime(vals[3], vals[4])
+	if err != nil {
+		return ratelimiter.Result{}, false, err
+	}
+	return ratelimiter.Result{
+		Allowed:   true,
+		Remaining: tokens,
+		Limit:     rate.Limit(limit),
+		Burst:     int(burst),
+		At:        at,
+	}, true, nil
+}
+
+// Touch implements ratelimiter.Store by extending the key's expiry
+func (s *Store) Touch(ctx context.Context, key string) error {
+	ttl, err := s.client.HGet(ctx, s.prefix+key, "ttl").Int64()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.client.PExpire(ctx, s.prefix+key, time.Duration(ttl)*time.Millisecond).Err()
+}
+
+// Cleanup implements ratelimiter.Store. Redis expires idle buckets on its
+// own, so there is nothing to do.
+func (s *Store) Cleanup(ctx context.Context, maxIdle time.Duration) error {
+	return nil
+}
+
+// errUnexpectedReply is returned when a script replies with something it
+// never sends, such as after a key was overwritten by another client
+var errUnexpectedReply = errors.New("redisstore: unexpected script reply")
+
+// replyInt returns a script reply element that should be an integer
+func replyInt(v any) (int64, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, errUnexpectedReply
+	}
+	return n, nil
+}
+
+// replyFloat returns a script reply element that should be a number sent as
+// a string, which is how scripts return fractions
+func replyFloat(v any) (float64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, errUnexpectedReply
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errUnexpectedReply
+	}
+	return f, nil
+}
+
+// wait returns how long a bucket holding tokens takes to reach n at limit
+func wait(tokens float64, limit rate.Limit, n int) time.Duration {
+	missing := float64(n) - tokens
+	if missing <= 0 {
+		return 0
+	}
+	if limit <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(missing / float64(limit) * float64(time.Second))
+}
+
+// replyTime returns the server time a script replied with as the seconds
+// and microseconds from TIME
+func replyTime(sec, usec any) (time.Time, error) {
+	s, err := replyInt(sec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	us, err := replyInt(usec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(s, us*int64(time.Microsecond)), nil
+}
@@ -0,0 +1,65 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/gigatar/ratelimiter/storetest"
+)
+
+func TestStoreConformance(t *testing.T) {
+	client, _ := newTestClient(t)
+	storetest.Run(t, func(t *testing.T) ratelimiter.Store {
+		return New(client, t.Name()+":")
+	})
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+	s := New(client, "rl:")
+
+	for i, want := range []bool{true, true, false} {
+		res, err := s.Allow(ctx, "k", 1, 2, 1)
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i, err)
+		}
+		if res.Allowed != want {
+			t.Fatalf("Allow %d: allowed = %v, want %v", i, res.Allowed, want)
+		}
+		if res.At.IsZero() {
+			t.Errorf("Allow %d: no server time in the result", i)
+		}
+	}
+	res, ok, err := s.Get(ctx, "k")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if res.Burst != 2 || res.Limit != 1 || res.Remaining >= 1 {
+		t.Errorf("Get = %+v, want an empty bucket of 2 at 1/s", res)
+	}
+	if res, err := s.AllowGCRA(ctx, "k", 1, 2, 1); err != nil || !res.Allowed {
+		t.Errorf("AllowGCRA = %+v, %v", res, err)
+	}
+}
+
+func TestReplyElementsAreChecked(t *testing.T) {
+	for _, v := range []any{nil, "1", 1.5, []any{}} {
+		if _, err := replyInt(v); !errors.Is(err, errUnexpectedReply) {
+			t.Errorf("replyInt(%#v) = %v, want errUnexpectedReply", v, err)
+		}
+	}
+	for _, v := range []any{nil, int64(1), "nan?", []any{}} {
+		if _, err := replyFloat(v); !errors.Is(err, errUnexpectedReply) {
+			t.Errorf("replyFloat(%#v) = %v, want errUnexpectedReply", v, err)
+		}
+	}
+	if n, err := replyInt(int64(7)); n != 7 || err != nil {
+		t.Errorf("replyInt(7) = %v, %v", n, err)
+	}
+	if f, err := replyFloat("2.5"); f != 2.5 || err != nil {
+		t.Errorf(`replyFloat("2.5") = %v, %v`, f, err)
+	}
+}
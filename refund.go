@@ -0,0 +1,98 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// takeRefundable is take for requests whose tokens may be given back once
+// the response is known. refund is nil if the tokens weren't taken. Buckets
+// in a shared Store can't be refunded, so their refund does nothing.
+func (rl *RateLimiter) takeRefundable(ctx context.Context, key string, limiter *rate.Limiter, n int, now time.Time) (res Result, refund func(time.Time)) {
+	switch {
+	case !rl.localStore():
+		res = rl.take(ctx, key, limiter, n, now)
+		refund = func(time.Time) {}
+	case rl.windowed():
+		res = rl.takeWindow(key, limiter.Burst(), n, now, true)
+		refund = func(at time.Time) { rl.refundWindow(key, n, now, at) }
+	case rl.gcra():
+		res = rl.takeGCRA(key, limiter, n, now, true)
+		refund = func(time.Time) { rl.refundGCRA(key, limiter, n) }
+	default:
+		res = limiterResult(limiter, now, n, limiter.AllowN(now, n))
+		refund = func(at time.Time) { refundTokens(limiter, n, at) }
+	}
+	if !res.Allowed {
+		refund = nil
+	}
+	return res, refund
+}
+
+// tokenGrant is n tokens taken from a bucket, which can be given back at any
+// time later
+type tokenGrant struct {
+	limiter *rate.Limiter
+	n       int
+}
+
+// release puts the tokens back into the bucket
+func (g tokenGrant) release(at time.Time) {
+	refundTokens(g.limiter, g.n, at)
+}
+
+// refundTokens puts n tokens back into limiter at now. Reservation.CancelAt
+// can't be used: it gives nothing back once the reservation's time has
+// passed. Taking a negative number of tokens adds them instead, and the
+// limiter caps them at its burst when it next refills.
+func refundTokens(limiter *rate.Limiter, n int, now time.Time) {
+	limiter.AllowN(now, -n)
+}
+
+// refundWindow gives back n requests counted in key's window at taken, if
+// the window they were counted in hasn't ended by now
+func (rl *RateLimiter) refundWindow(key string, n int, taken, now time.Time) {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	if v, exists := shard.visitors.get(key); exists && v.window != nil {
+		v.window.refund(n, taken, now)
+	}
+}
+
+// refund removes n requests counted at taken from the window state
+func (ws *windowState) refund(n int, taken, now time.Time) {
+	ws.advance(now)
+	if ws.algorithm == AlgorithmSlidingWindowLog {
+		for i := len(ws.log) - 1; i >= 0 && n > 0; i-- {
+			if ws.log[i].Equal(taken) {
+				ws.log = slices.Delete(ws.log, i, i+1)
+				n--
+			}
+		}
+		return
+	}
+	if ws.start.Equal(taken.Truncate(ws.length)) {
+		ws.count = max(0, ws.count-n)
+	}
+}
+
+// refunds reports whether a response with status gets its tokens back
+func (rl *RateLimiter) refunds(status int) bool {
+	return slices.Contains(rl.config.RefundStatuses, status)
+}
+
+// serveRefundable serves an admitted request and, if its response has one
+// of the RefundStatuses, calls refund with the time the response finished
+func (rl *RateLimiter) serveRefundable(w http.ResponseWriter, r *http.Request, next http.Handler, refund func(time.Time)) {
+	sw := newStatusWriter(w)
+	rl.serve(sw, r, next)
+	if rl.refunds(sw.status) {
+		refund(rl.now())
+	}
+}
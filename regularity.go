@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// regularityAlpha is the EWMA smoothing factor for inter-arrival intervals
+	regularityAlpha = 0.2
+	// regularityMinSamples is the number of intervals needed before a key can
+	// be judged as regular
+	regularityMinSamples = 10
+)
+
+// arrivalStats is an exponentially weighted mean and variance of a key's
+// request inter-arrival intervals, in seconds
+type arrivalStats struct {
+	last     time.Time
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// observe adds an arrival at now and reports whether the intervals look
+// automated, i.e. their coefficient of variation is below threshold
+func (as *arrivalStats) observe(now time.Time, threshold float64) bool {
+	if as.last.IsZero() {
+		as.last = now
+		return false
+	}
+	interval := now.Sub(as.last).Seconds()
+	as.last = now
+	if as.samples == 0 {
+		as.mean = interval
+	} else {
+		diff := interval - as.mean
+		as.mean += regularityAlpha * diff
+		as.variance = (1 - regularityAlpha) * (as.variance + regularityAlpha*diff*diff)
+	}
+	as.samples++
+
+	if as.samples < regularityMinSamples || as.mean <= 0 {
+		return false
+	}
+	return math.Sqrt(as.variance)/as.mean < threshold
+}
@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArrivalStatsRegularity(t *testing.T) {
+	var regular, irregular arrivalStats
+	now := time.Unix(0, 0)
+	for i := range 30 {
+		now = now.Add(time.Second)
+		regular.observe(now, 0.1)
+		irregular.observe(now.Add(time.Duration(i%3)*400*time.Millisecond), 0.1)
+	}
+	if !regular.observe(now.Add(time.Second), 0.1) {
+		t.Error("one request a second exactly isn't judged regular")
+	}
+	if irregular.observe(now.Add(2*time.Second), 0.1) {
+		t.Error("jittered arrivals judged regular")
+	}
+}
+
+func TestRegularClientsPayMore(t *testing.T) {
+	// count returns the denials for 40 requests whose intervals cycle
+	// through gaps, each client with a bucket of 40 refilling once a second
+	count := func(gaps ...time.Duration) int {
+		clock := NewManualClock(time.Unix(0, 0))
+		rl := New(&Config{
+			RequestsPerSecond:   1,
+			Burst:               40,
+			RegularityThreshold: 0.1,
+			RegularityPenalty:   4,
+			Clock:               clock,
+		})
+		defer rl.Close()
+		h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		denied := 0
+		for i := range 40 {
+			clock.Advance(gaps[i%len(gaps)])
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code == http.StatusTooManyRequests {
+				denied++
+			}
+		}
+		return denied
+	}
+
+	if n := count(time.Second); n == 0 {
+		t.Error("a client requesting every second exactly was never denied")
+	}
+	if n := count(500*time.Millisecond, 1500*time.Millisecond, time.Second); n != 0 {
+		t.Errorf("a client with irregular gaps averaging a second was denied %d times", n)
+	}
+}
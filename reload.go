@@ -0,0 +1,229 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// configSource is the file a limiter created by NewFromFile reloads from
+type configSource struct {
+	path    string
+	format  string
+	mx      sync.Mutex // serializes reloads
+	modTime time.Time
+	size    int64
+}
+
+// NewFromFile creates a RateLimiter configured from the JSON or YAML file at
+// path, picking the format by extension. Its settings can be changed later
+// by editing the file and calling Reload, by ReloadOnSignal, or on their own
+// every ReloadInterval.
+func NewFromFile(path string) (*RateLimiter, error) {
+	src := &configSource{path: path}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		src.format = "json"
+	case ".yaml", ".yml":
+		src.format = "yaml"
+	default:
+		return nil, fmt.Errorf("ratelimiter: unsupported config file %q", path)
+	}
+	cfg, err := src.load()
+	if err != nil {
+		return nil, err
+	}
+	rl := New(cfg)
+	rl.source = src
+	if cfg.ReloadInterval > 0 {
+		go rl.watchConfig()
+	}
+	return rl, nil
+}
+
+// load reads and decodes the file, remembering its version
+func (src *configSource) load() (*Config, error) {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimiter: reading config: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("ratelimiter: reading config: %w", err)
+	}
+	cfg, err := ConfigFromReader(f, src.format)
+	if err != nil {
+		return nil, err
+	}
+	src.modTime, src.size = info.ModTime(), info.Size()
+	return cfg, nil
+}
+
+// changed reports whether the file was modified since it was last loaded
+func (src *configSource) changed() bool {
+	src.mx.Lock()
+	defer src.mx.Unlock()
+	info, err := os.Stat(src.path)
+	return err == nil && (!info.ModTime().Equal(src.modTime) || info.Size() != src.size)
+}
+
+// Reload re-reads the file the limiter was created from and applies it with
+// ApplyConfig. A file that can't be read or decoded changes nothing.
+func (rl *RateLimiter) Reload() error {
+	if rl.source == nil {
+		return errors.New("ratelimiter: limiter wasn't created by NewFromFile")
+	}
+	rl.source.mx.Lock()
+	defer rl.source.mx.Unlock()
+	cfg, err := rl.source.load()
+	if err != nil {
+		return err
+	}
+	rl.ApplyConfig(cfg)
+	return nil
+}
+
+// watchConfig reloads the file whenever it changes, checking every
+// ReloadInterval until Close is called
+func (rl *RateLimiter) watchConfig() {
+	ticker := rl.config.Clock.NewTicker(rl.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if rl.source.changed() {
+				rl.reloadAndLog("file changed")
+			}
+		case <-rl.done:
+			return
+		}
+	}
+}
+
+// ReloadOnSignal reloads the file the limiter was created from whenever the
+// process receives one of sigs, SIGHUP if none are given, until Close is
+// called. Failed reloads are logged and leave the limiter as it was.
+func (rl *RateLimiter) ReloadOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case sig := <-c:
+				rl.reloadAndLog(sig.String())
+			case <-rl.done:
+				return
+			}
+		}
+	}()
+}
+
+// reloadAndLog reloads the config in the background, logging the outcome
+func (rl *RateLimiter) reloadAndLog(reason string) {
+	err := rl.Reload()
+	if rl.config.Logger == nil {
+		return
+	}
+	if err != nil {
+		rl.config.Logger.Error("ratelimiter: config reload failed", slog.String("reason", reason), slog.Any("error", err))
+		return
+	}
+	rl.config.Logger.Info("ratelimiter: config reloaded", slog.String("reason", reason), slog.String("path", rl.source.path))
+}
+
+// ApplyConfig brings a running limiter in line with cfg without losing its
+// visitors. It applies the settings operators tune most: RequestsPerSecond
+// and Burst as SetLimit does, Routes, Allowlist, Denylist, TrustedProxies
+// and, if the limiter was created with one, the global limit. Entries added
+// with AddToAllowlist and the like are replaced by cfg's. Every other field
+// only takes effect in a new limiter.
+func (rl *RateLimiter) ApplyConfig(cfg *Config) {
+	cfg.Validate()
+	rl.reconfigure.Lock()
+	defer rl.reconfigure.Unlock()
+	rl.SetLimit(cfg.RequestsPerSecond, cfg.Burst)
+	rl.applyRoutes(cfg.Routes)
+	rl.allowlist.set(parsePrefixes(cfg.Allowlist))
+	rl.denylist.set(parsePrefixes(cfg.Denylist))
+	rl.trusted.set(parsePrefixes(cfg.TrustedProxies))
+	if rl.global != nil && cfg.GlobalRequestsPerSecond > 0 {
+		now := rl.now()
+		rl.global.SetLimitAt(now, rate.Limit(cfg.GlobalRequestsPerSecond))
+		rl.global.SetBurstAt(now, cfg.GlobalBurst)
+	}
+}
+
+// applyRoutes replaces the routes, retuning the buckets of routes whose
+// rate, burst or method overrides changed
+func (rl *RateLimiter) applyRoutes(routes []Route) {
+	old := make(map[string]Route)
+	for _, route := range *rl.routes.Load() {
+		old[route.Pattern] = route
+	}
+	var changed [][2]Route // previous and new version
+	for _, route := range routes {
+		if prev, ok := old[route.Pattern]; ok && !reflect.DeepEqual(prev, route) {
+			changed = append(changed, [2]Route{prev, route})
+		}
+	}
+
+	totals := maps.Clone(*rl.totals.Load())
+	for _, route := range routes {
+		if totals[route.Pattern] == nil {
+			totals[route.Pattern] = &decisionCounters{}
+		}
+	}
+	rl.totals.Store(&totals)
+	rl.routes.Store(&routes)
+
+	if len(changed) == 0 {
+		return
+	}
+	now := rl.now()
+	rl.memory.each(func(key string, v *visitor) {
+		if v.limiter == nil || v.custom {
+			return
+		}
+		for _, c := range changed {
+			prev, ok := c[0].spec(key)
+			if !ok {
+				continue
+			}
+			if spec, ok := c[1].spec(key); ok {
+				v.retune(prev, spec, now)
+			}
+			return
+		}
+	})
+}
+
+// spec returns the rate and burst the route gives the bucket stored under
+// key, or false if key isn't one of the route's buckets
+func (rt *Route) spec(key string) (LimitSpec, bool) {
+	suffix := "|route:" + rt.Pattern
+	if strings.HasSuffix(key, suffix) {
+		return LimitSpec{RequestsPerSecond: rt.RequestsPerSecond, Burst: rt.Burst}, true
+	}
+	i := strings.LastIndex(key, suffix+"|method:")
+	if i < 0 {
+		return LimitSpec{}, false
+	}
+	spec, ok := rt.MethodOverrides[key[i+len(suffix+"|method:"):]]
+	return spec, ok
+}
@@ -0,0 +1,24 @@
+package ratelimiter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestID returns the request ID carried in the RequestIDHeader, or a
+// newly generated one if the request doesn't have it. It is empty without a
+// RequestIDHeader.
+func (rl *RateLimiter) requestID(r *http.Request) string {
+	if rl.config.RequestIDHeader == "" {
+		return ""
+	}
+	if id := r.Header.Get(rl.config.RequestIDHeader); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
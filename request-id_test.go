@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDReachesHookLogAndResponse(t *testing.T) {
+	var logs bytes.Buffer
+	var hookIDs []string
+	rl := New(&Config{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		RequestIDHeader:   "X-Request-ID",
+		Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+		OnDeny:            func(r *http.Request, info LimitInfo) { hookIDs = append(hookIDs, info.RequestID) },
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("X-Request-ID"); got != "abc123" {
+		t.Errorf("response carries request ID %q, want abc123", got)
+	}
+
+	// without one, the generated ID is the same everywhere
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	generated := w.Header().Get("X-Request-ID")
+	if generated == "" {
+		t.Fatal("no request ID generated for the denied response")
+	}
+
+	if len(hookIDs) != 2 || hookIDs[0] != "abc123" || hookIDs[1] != generated {
+		t.Errorf("OnDeny saw request IDs %q, want [abc123 %s]", hookIDs, generated)
+	}
+	for _, id := range []string{"abc123", generated} {
+		if !strings.Contains(logs.String(), "request_id="+id) {
+			t.Errorf("denial log lacks request_id=%s:\n%s", id, logs.String())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Reservation is the outcome of Reserve. Unlike a rate.Reservation it
+// doesn't hold tokens for the future: they are taken straight away if they
+// are available, and can be given back with Cancel if the work they were
+// taken for didn't happen.
+type Reservation struct {
+	rl         *RateLimiter
+	ok         bool
+	retryAfter time.Duration
+	refund     func(time.Time)
+	once       sync.Once
+}
+
+// OK reports whether the tokens were taken
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// RetryAfter returns how long to wait before trying again, zero if the
+// tokens were taken
+func (r *Reservation) RetryAfter() time.Duration {
+	return r.retryAfter
+}
+
+// Cancel gives the tokens back to the key's bucket, quota and the global
+// limit. Buckets in a shared Store can't be refunded. Only the first call
+// has an effect, and it does nothing if the tokens weren't taken.
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.once.Do(func() { r.refund(r.rl.now()) })
+}
+
+// Allow reports whether one request for a client identity, such as a
+// worker's tenant or a CLI user, may happen now. It is AllowKey without the
+// retry time.
+func (rl *RateLimiter) Allow(identity string) bool {
+	return rl.AllowN(identity, 1)
+}
+
+// AllowN reports whether n requests for a client identity may happen now,
+// taking n tokens if so. n above the key's burst is never allowed.
+func (rl *RateLimiter) AllowN(identity string, n int) bool {
+	ok, _, _ := rl.reserveKey(rl.storageKey(identity), n, rl.now())
+	return ok
+}
+
+// Reserve takes one token for a client identity if one is available,
+// returning a Reservation that can give it back
+func (rl *RateLimiter) Reserve(identity string) *Reservation {
+	ok, wait, refund := rl.reserveKey(rl.storageKey(identity), 1, rl.now())
+	return &Reservation{rl: rl, ok: ok, retryAfter: wait, refund: refund}
+}
+
+// Wait blocks until one request for a client identity is allowed, trying
+// again whenever the previous attempt said to. Like requests obeying
+// Retry-After, every attempt is a decision of its own and denied attempts
+// count towards bans. The waits are timed by Config.Clock, so a
+// ManualClock's Advance ends them. It fails with a *LimitError without
+// waiting if ctx's deadline, which is in real time, would pass first, and
+// with ctx's error if it ends while waiting.
+func (rl *RateLimiter) Wait(ctx context.Context, identity string) error {
+	key := rl.storageKey(identity)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, wait, _ := rl.reserveKey(key, 1, rl.now())
+		if ok {
+			return nil
+		}
+		if deadline, set := ctx.Deadline(); wait == time.Duration(math.MaxInt64) || set && time.Until(deadline) < wait {
+			return &LimitError{Key: identity, RetryAfter: wait}
+		}
+		// a ticker stopped after its first tick is the only timer a Clock has
+		ticker := rl.config.Clock.NewTicker(max(wait, time.Millisecond))
+		select {
+		case <-ticker.C():
+			ticker.Stop()
+		case <-ctx.Done():
+			ticker.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReservationCancelRestoresAllTokens(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{
+		RequestsPerSecond:       1,
+		Burst:                   1,
+		GlobalRequestsPerSecond: 1,
+		GlobalBurst:             3,
+		Limits:                  []Limit{{Name: "hourly", Requests: 1, Period: time.Hour}},
+		Clock:                   clock,
+	})
+	defer rl.Close()
+
+	rsv := rl.Reserve("tenant")
+	if !rsv.OK() {
+		t.Fatal("first reservation should succeed")
+	}
+	if rl.Allow("tenant") {
+		t.Fatal("bucket should be empty")
+	}
+	// cancelling after the reservation's time must still give the tokens back
+	clock.Advance(100 * time.Millisecond)
+	rsv.Cancel()
+	rsv.Cancel()
+
+	if tokens := rl.global.TokensAt(clock.Now()); tokens != 3 {
+		t.Errorf("global bucket holds %v tokens after Cancel, want 3", tokens)
+	}
+	if !rl.Allow("tenant") {
+		t.Error("the cancelled token should be available again, composite limit included")
+	}
+}
+
+func TestWaitUsesClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 1, Burst: 1, Clock: clock})
+	defer rl.Close()
+
+	if !rl.Allow("tenant") {
+		t.Fatal("first request should be allowed")
+	}
+	done := make(chan error, 1)
+	go func() { done <- rl.Wait(context.Background(), "tenant") }()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Wait: %v", err)
+			}
+			if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed < time.Second {
+				t.Errorf("Wait returned after %v of clock time, want at least 1s", elapsed)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Wait didn't return as the clock advanced")
+		case <-time.After(time.Millisecond):
+			clock.Advance(100 * time.Millisecond)
+		}
+	}
+}
+
+func TestWaitFailsFastPastDeadline(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 0.001, Burst: 1})
+	defer rl.Close()
+
+	rl.Allow("tenant")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var limitErr *LimitError
+	if err := rl.Wait(ctx, "tenant"); !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want a *LimitError", err)
+	}
+}
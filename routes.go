@@ -0,0 +1,72 @@
+package ratelimiter
+
+import "strings"
+
+// Route limits requests whose path matches Pattern at its own rate, in a
+// bucket separate from the default one
+type Route struct {
+	// Pattern is either an exact path like "/login" or a prefix ending in
+	// "*" like "/api/v1/*". "/api/v1/*" also matches "/api/v1" itself.
+	Pattern string `json:"pattern" yaml:"pattern" toml:"pattern"`
+	// RequestsPerSecond is the number of requests allowed per second.
+	// Defaults to Config.RequestsPerSecond.
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is the maximum number of requests allowed in a burst. Defaults
+	// to Config.Burst.
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+	// MethodOverrides gives methods on the route their own rate and burst,
+	// e.g. a stricter limit for POST than for GET
+	MethodOverrides map[string]LimitSpec `json:"method_overrides" yaml:"method_overrides" toml:"method_overrides"`
+	// PerPath gives every path matching a wildcard Pattern a bucket of its
+	// own, as if a route had been registered for each. At most
+	// Config.MaxDynamicRoutes paths get one; requests for other paths share
+	// the route's bucket.
+	PerPath bool `json:"per_path" yaml:"per_path" toml:"per_path"`
+}
+
+// matches reports whether path falls under the route's pattern
+func (rt *Route) matches(path string) bool {
+	prefix, wildcard := strings.CutSuffix(rt.Pattern, "*")
+	if !wildcard {
+		return path == rt.Pattern
+	}
+	return strings.HasPrefix(path, prefix) || path+"/" == prefix
+}
+
+// matchRoute returns the first route matching path, or nil if none does
+func (rl *RateLimiter) matchRoute(path string) *Route {
+	routes := *rl.routes.Load()
+	for i := range routes {
+		if routes[i].matches(path) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// normalizePath lowercases path, collapses runs of slashes and drops a
+// trailing slash, so variants of one path share their buckets
+func normalizePath(path string) string {
+	path = collapseSlashes(strings.ToLower(path))
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// normalizePattern normalizes a route pattern like the paths it is matched
+// against, leaving the "*" of a wildcard in place
+func normalizePattern(pattern string) string {
+	if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+		return collapseSlashes(strings.ToLower(prefix)) + "*"
+	}
+	return normalizePath(pattern)
+}
+
+// collapseSlashes replaces runs of slashes in path with a single one
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
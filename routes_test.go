@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/":              "/",
+		"//":             "/",
+		"/api/users":     "/api/users",
+		"/API/Users/":    "/api/users",
+		"/api//users":    "/api/users",
+		"///api///users": "/api/users",
+	} {
+		if got := normalizePath(path); got != want {
+			t.Errorf("normalizePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if got := normalizePattern("/API//v1/*"); got != "/api/v1/*" {
+		t.Errorf("normalizePattern kept %q", got)
+	}
+}
+
+func TestNormalizedPathsShareBucket(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		route Route
+		paths []string
+	}{
+		{"exact", Route{Pattern: "/Login/", RequestsPerSecond: 0.001, Burst: 1}, []string{"/login", "/login/", "//LOGIN", "/Login//"}},
+		{"per path", Route{Pattern: "/users/*", RequestsPerSecond: 0.001, Burst: 1, PerPath: true}, []string{"/users/42", "/users/42/", "/USERS//42", "//users/42//"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rl := New(&Config{
+				RequestsPerSecond: 100,
+				Burst:             100,
+				NormalizePaths:    true,
+				Routes:            []Route{tc.route},
+				Clock:             NewManualClock(time.Unix(0, 0)),
+			})
+			defer rl.Close()
+			h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			for i, path := range tc.paths {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				want := http.StatusTooManyRequests
+				if i == 0 {
+					want = http.StatusOK
+				}
+				if w.Code != want {
+					t.Errorf("%s: got %d, want %d", path, w.Code, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultLimit returns the top-level rate and burst, as last set by SetLimit
+func (rl *RateLimiter) defaultLimit() (rate.Limit, int) {
+	spec := rl.defaults.Load()
+	return rate.Limit(spec.RequestsPerSecond), spec.Burst
+}
+
+// SetLimit changes the top-level rate and burst at runtime, e.g. to tighten
+// limits during an incident without losing every visitor's state. Existing
+// buckets using the old rate or burst are updated in place, keeping their
+// tokens up to the new burst. Keys with a limit of their own from
+// SetKeyRate or SetLimitFor are left alone, as are routes, tiers and
+// method overrides with their own values; boosted keys revert to the new
+// limit. Non-positive values leave that part unchanged. Config is not
+// modified.
+func (rl *RateLimiter) SetLimit(rps float64, burst int) {
+	old := *rl.defaults.Load()
+	spec := old
+	if rps > 0 {
+		spec.RequestsPerSecond = rps
+	}
+	if burst > 0 {
+		spec.Burst = burst
+	}
+	rl.defaults.Store(&spec)
+
+	now := rl.now()
+	rl.memory.each(func(key string, v *visitor) {
+		if v.limiter != nil && !v.custom {
+			v.retune(old, spec, now)
+		}
+	})
+}
+
+// retune moves a visitor's bucket from the old limit to the new one. Parts
+// the bucket doesn't have from the old limit, such as a burst of its own,
+// are left alone, and boosted buckets revert to the new limit.
+func (v *visitor) retune(old, spec LimitSpec, now time.Time) {
+	oldLimit, newLimit := rate.Limit(old.RequestsPerSecond), rate.Limit(spec.RequestsPerSecond)
+	if v.boost != nil {
+		if v.boost.revertLimit == oldLimit {
+			v.boost.revertLimit = newLimit
+		}
+		if v.boost.revertBurst == old.Burst {
+			v.boost.revertBurst = spec.Burst
+		}
+		return
+	}
+	if v.limiter.Limit() == oldLimit {
+		v.limiter.SetLimitAt(now, newLimit)
+	}
+	if v.limiter.Burst() == old.Burst {
+		v.limiter.SetBurstAt(now, spec.Burst)
+	}
+}
+
+// SetLimitFor gives a client identity its own rate and burst, updating its
+// bucket in place if it has one. burst defaults to one second's worth of
+// rps. Like SetKeyRate, the limit lasts until the visitor is removed by
+// cleanup, and SetLimit doesn't change it.
+func (rl *RateLimiter) SetLimitFor(identity string, rps float64, burst int) {
+	rl.setLimitFor(rl.storageKey(identity), rps, burst)
+}
+
+func (rl *RateLimiter) setLimitFor(key string, rps float64, burst int) {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rps)))
+	}
+	rl.setKeyLimit(key, rate.Limit(max(0, rps)), burst)
+}
+
+// SetVisitorLimit gives a client identity, as returned by KeyFunc, its own
+// rate and burst, such as a raised limit for a customer, like SetLimitFor.
+// With OverrideTTL cleanup keeps the limit for that long even while the
+// client is idle. ResetVisitor removes it again.
+func (rl *RateLimiter) SetVisitorLimit(identity string, rps float64, burst int) {
+	key := rl.storageKey(identity)
+	rl.setLimitFor(key, rps, burst)
+	if rl.config.OverrideTTL == 0 {
+		return
+	}
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+	if v, exists := shard.visitors.get(key); exists {
+		v.pinned = rl.now().Add(rl.config.OverrideTTL)
+	}
+}
+
+// ResetVisitor forgets everything about a client identity: its bucket and
+// any limit set for it, its quota usage and denial history, and any ban, so
+// an accidental lockout can be cleared. Its next request starts afresh with
+// the default limit.
+func (rl *RateLimiter) ResetVisitor(identity string) {
+	rl.resetKey(rl.storageKey(identity))
+}
+
+// setKeyLimit sets key's limit, creating its visitor if needed
+func (rl *RateLimiter) setKeyLimit(key string, limit rate.Limit, burst int) {
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(rate.NewLimiter(limit, burst), rl.now())
+		v.custom = true
+		shard.add(key, v)
+		return
+	}
+	v.custom = true
+	if v.limiter == nil {
+		v.limiter = rate.NewLimiter(limit, burst)
+		return
+	}
+	now := rl.now()
+	v.limiter.SetLimitAt(now, limit)
+	v.limiter.SetBurstAt(now, burst)
+}
@@ -0,0 +1,105 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ErrServerClosed is returned by Server.Serve after Close has been called
+var ErrServerClosed = errors.New("ratelimiter: server closed")
+
+// Server answers rate limit decisions over a stream socket, typically a Unix
+// domain socket, so a sidecar (an Envoy or nginx ext_authz shim) can reuse the
+// limiter outside the application process. The protocol is line based: the
+// client sends a key per line and the server answers each with either
+// "ALLOW" or "DENY <seconds until retry>".
+type Server struct {
+	limiter *RateLimiter
+
+	mx        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// NewServer creates a Server answering with the given limiter
+func NewServer(rl *RateLimiter) *Server {
+	return &Server{limiter: rl, listeners: make(map[net.Listener]struct{})}
+}
+
+// ListenAndServe listens on the given network and address, e.g. "unix" and
+// "/run/ratelimiter.sock", and serves decisions until Close is called
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves decisions until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mx.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mx.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mx.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers requests on a single connection until it is closed
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" {
+			fmt.Fprintln(w, "ERR empty key")
+		} else if allowed, wait := s.limiter.AllowKey(key); allowed {
+			fmt.Fprintln(w, "ALLOW")
+		} else {
+			fmt.Fprintf(w, "DENY %d\n", retryAfterSeconds(wait))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops all listeners. Connections already being served are left to
+// finish on their own.
+func (s *Server) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
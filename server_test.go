@@ -0,0 +1,72 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerAnswersUntilBucketDrains(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 0.5, Burst: 3, Clock: NewManualClock(time.Unix(0, 0))})
+	defer rl.Close()
+	client, server := net.Pipe()
+	defer client.Close()
+	go NewServer(rl).ServeConn(server)
+
+	r := bufio.NewReader(client)
+	for _, tt := range []struct{ send, want string }{
+		{"a", "ALLOW"},
+		{"a", "ALLOW"},
+		{"a", "ALLOW"},
+		{"a", "DENY 2"},
+		{"  ", "ERR empty key"},
+		{"b", "ALLOW"},
+	} {
+		fmt.Fprintln(client, tt.send)
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the answer to %q: %v", tt.send, err)
+		}
+		if line != tt.want+"\n" {
+			t.Errorf("sent %q, got %q, want %q", tt.send, line, tt.want)
+		}
+	}
+}
+
+func TestServerOverUnixSocket(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 0.5, Burst: 1, Clock: NewManualClock(time.Unix(0, 0))})
+	defer rl.Close()
+	path := filepath.Join(t.TempDir(), "ratelimiter.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	s := NewServer(rl)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"ALLOW\n", "DENY 2\n"} {
+		fmt.Fprintln(conn, "key")
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("got %q, %v, want %q", line, err, want)
+		}
+	}
+
+	s.Close()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve returned %v after Close, want ErrServerClosed", err)
+	}
+	if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve on a closed server returned %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package ratelimiter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignBypass returns a BypassHeader value exempting one request for path
+// from limiting, signed with secret at the given time. Load tests and
+// internal tooling sharing BypassSecret use it to sign their requests:
+//
+//	req.Header.Set("X-RateLimit-Bypass", ratelimiter.SignBypass(secret, req.URL.Path, time.Now()))
+//
+// The value is the Unix timestamp, a random nonce that keeps signatures for
+// the same path and second apart, and the signature, separated by colons.
+func SignBypass(secret []byte, path string, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	b := make([]byte, 8)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	return timestamp + ":" + nonce + ":" + bypassSignature(secret, timestamp, nonce, path)
+}
+
+// bypassSignature is the hex HMAC-SHA256 of a timestamp, nonce and path
+func bypassSignature(secret []byte, timestamp, nonce, path string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + path))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bypassTokens remembers the signed bypasses already used, until they would
+// have expired anyway, so each one works only once
+type bypassTokens struct {
+	mx   sync.Mutex
+	used map[string]time.Time // signature -> expiry
+}
+
+// use reports whether sig is being used for the first time, remembering it
+// until expires
+func (bt *bypassTokens) use(sig string, expires time.Time) bool {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+	if _, seen := bt.used[sig]; seen {
+		return false
+	}
+	if bt.used == nil {
+		bt.used = make(map[string]time.Time)
+	}
+	bt.used[sig] = expires
+	return true
+}
+
+// expire forgets signatures that are too old to be accepted anyway
+func (bt *bypassTokens) expire(now time.Time) {
+	bt.mx.Lock()
+	defer bt.mx.Unlock()
+	for sig, expires := range bt.used {
+		if !now.Before(expires) {
+			delete(bt.used, sig)
+		}
+	}
+}
+
+// signedBypass reports whether r carries a valid, unused BypassHeader.
+// Accepted and rejected bypasses are both logged for auditing.
+func (rl *RateLimiter) signedBypass(r *http.Request) bool {
+	if len(rl.config.BypassSecret) == 0 {
+		return false
+	}
+	value := r.Header.Get(rl.config.BypassHeader)
+	if value == "" {
+		return false
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		rl.logBypass(r, "malformed")
+		return false
+	}
+	timestamp, nonce, sig := parts[0], parts[1], parts[2]
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		rl.logBypass(r, "malformed")
+		return false
+	}
+	if !hmac.Equal([]byte(sig), []byte(bypassSignature(rl.config.BypassSecret, timestamp, nonce, r.URL.Path))) {
+		rl.logBypass(r, "invalid signature")
+		return false
+	}
+	now, signed := rl.now(), time.Unix(unix, 0)
+	if now.Sub(signed).Abs() > rl.config.BypassMaxAge {
+		rl.logBypass(r, "expired")
+		return false
+	}
+	if !rl.bypasses.use(sig, signed.Add(rl.config.BypassMaxAge+time.Second)) {
+		rl.logBypass(r, "replayed")
+		return false
+	}
+	rl.logBypass(r, "")
+	return true
+}
+
+// logBypass logs a signed bypass, accepted if reason is empty and rejected
+// for reason otherwise
+func (rl *RateLimiter) logBypass(r *http.Request, reason string) {
+	if rl.config.Logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("client_ip", rl.clientIP(r)),
+	}
+	if reason == "" {
+		rl.config.Logger.LogAttrs(r.Context(), slog.LevelInfo, "ratelimiter: bypass used", attrs...)
+		return
+	}
+	attrs = append(attrs, slog.String("reason", reason))
+	rl.config.Logger.LogAttrs(r.Context(), slog.LevelWarn, "ratelimiter: bypass rejected", attrs...)
+}
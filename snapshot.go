@@ -0,0 +1,208 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// snapshotVersion is written into every snapshot and bumped whenever the
+// format changes incompatibly
+const snapshotVersion = 1
+
+// ErrSnapshotVersion is returned when restoring a snapshot written in a
+// format this version of the package doesn't understand
+var ErrSnapshotVersion = errors.New("ratelimiter: unsupported snapshot version")
+
+// SnapshotStore is implemented by stores whose state can be saved before a
+// restart and restored afterwards. Stores that outlive the process on their
+// own, such as Redis, needn't implement it.
+type SnapshotStore interface {
+	// Snapshot serializes the store's buckets
+	Snapshot(ctx context.Context) ([]byte, error)
+	// Restore loads buckets from a Snapshot, replacing existing ones with
+	// the same key
+	Restore(ctx context.Context, data []byte) error
+}
+
+// snapshot is the serialized form of a limiter or MemoryStore
+type snapshot struct {
+	Version  int                        `json:"version"`
+	TakenAt  time.Time                  `json:"taken_at"`
+	Visitors []visitorSnapshot          `json:"visitors"`
+	Bans     map[string]time.Time       `json:"bans,omitempty"`
+	Quotas   map[string][]quotaSnapshot `json:"quotas,omitempty"`
+	Store    json.RawMessage            `json:"store,omitempty"` // a shared SnapshotStore's own snapshot
+}
+
+// visitorSnapshot is a visitor's bucket as of snapshot.TakenAt
+type visitorSnapshot struct {
+	Key      string          `json:"key"`
+	LastSeen time.Time       `json:"last_seen"`
+	Limit    float64         `json:"limit,omitempty"`
+	Burst    int             `json:"burst,omitempty"`
+	Tokens   float64         `json:"tokens,omitempty"`
+	Window   *windowSnapshot `json:"window,omitempty"`
+	TAT      *time.Time      `json:"tat,omitempty"` // theoretical arrival time with AlgorithmGCRA
+}
+
+// windowSnapshot is a visitor's windowState
+type windowSnapshot struct {
+	Algorithm Algorithm     `json:"algorithm"`
+	Length    time.Duration `json:"length"`
+	Start     time.Time     `json:"start"`
+	Count     int           `json:"count"`
+	Prev      int           `json:"prev"`
+	Log       []time.Time   `json:"log,omitempty"`
+}
+
+// visitors returns the state of every visitor at now. Boosts are not saved;
+// boosted visitors are saved with the limit they revert to.
+func (ms *MemoryStore) visitors(now time.Time) []visitorSnapshot {
+	visitors := make([]visitorSnapshot, 0)
+	ms.each(func(key string, v *visitor) {
+		vs := visitorSnapshot{Key: key, LastSeen: v.lastSeen.Load()}
+		if v.limiter != nil {
+			vs.Limit, vs.Burst = float64(v.limiter.Limit()), v.limiter.Burst()
+			if v.boost != nil {
+				vs.Limit, vs.Burst = float64(v.boost.revertLimit), v.boost.revertBurst
+			}
+			vs.Tokens = min(max(0, v.limiter.TokensAt(now)), float64(vs.Burst))
+		}
+		if ws := v.window; ws != nil {
+			vs.Window = &windowSnapshot{
+				Algorithm: ws.algorithm,
+				Length:    ws.length,
+				Start:     ws.start,
+				Count:     ws.count,
+				Prev:      ws.prev,
+				Log:       ws.log,
+			}
+		}
+		if !v.tat.IsZero() {
+			tat := v.tat
+			vs.TAT = &tat
+		}
+		visitors = append(visitors, vs)
+	})
+	return visitors
+}
+
+// restore loads visitors saved at takenAt. Buckets refill for the time
+// between takenAt and now as they would have without the restart.
+func (ms *MemoryStore) restore(visitors []visitorSnapshot, takenAt time.Time) {
+	for _, vs := range visitors {
+		v := newVisitor(nil, vs.LastSeen)
+		if vs.Burst > 0 {
+			v.limiter = rate.NewLimiter(rate.Limit(vs.Limit), vs.Burst)
+			if used := math.Ceil(float64(vs.Burst) - vs.Tokens); used > 0 {
+				v.limiter.ReserveN(takenAt, int(used))
+			}
+		}
+		if ws := vs.Window; ws != nil && ws.Length > 0 {
+			v.window = &windowState{
+				algorithm: ws.Algorithm,
+				length:    ws.Length,
+				start:     ws.Start,
+				count:     ws.Count,
+				prev:      ws.Prev,
+				log:       ws.Log,
+			}
+		}
+		if vs.TAT != nil {
+			v.tat = *vs.TAT
+		}
+
+		shard := ms.shard(vs.Key)
+		shard.mx.Lock()
+		if old, exists := shard.visitors.get(vs.Key); exists {
+			shard.remove(vs.Key, old)
+		}
+		shard.add(vs.Key, v)
+		shard.mx.Unlock()
+	}
+}
+
+// decodeSnapshot parses data and checks its version
+func decodeSnapshot(data []byte) (*snapshot, error) {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("%w %d", ErrSnapshotVersion, snap.Version)
+	}
+	return &snap, nil
+}
+
+// Snapshot implements SnapshotStore
+func (ms *MemoryStore) Snapshot(ctx context.Context) ([]byte, error) {
+	now := ms.now()
+	return json.Marshal(snapshot{Version: snapshotVersion, TakenAt: now, Visitors: ms.visitors(now)})
+}
+
+// Restore implements SnapshotStore
+func (ms *MemoryStore) Restore(ctx context.Context, data []byte) error {
+	snap, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	ms.restore(snap.Visitors, snap.TakenAt)
+	return nil
+}
+
+// Snapshot serializes the limiter's visitors, bans and quota usage, and a shared Store's
+// state if it implements SnapshotStore, so they can be restored with Restore
+// after a graceful restart. Without it a restart gives every client, abusive
+// ones included, a fresh burst. The format is versioned JSON.
+func (rl *RateLimiter) Snapshot() ([]byte, error) {
+	now := rl.now()
+	snap := snapshot{
+		Version:  snapshotVersion,
+		TakenAt:  now,
+		Visitors: rl.memory.visitors(now),
+		Bans:     rl.bans.active(now),
+	}
+	if rl.quotas != nil {
+		snap.Quotas = rl.quotas.active(rl.storeNow(now))
+	}
+	if ss, ok := rl.store.(SnapshotStore); ok && !rl.localStore() {
+		data, err := ss.Snapshot(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		snap.Store = data
+	}
+	return json.Marshal(snap)
+}
+
+// Restore loads state saved by Snapshot, typically right after New and
+// before serving requests. Buckets refill for the time the process was
+// down, and bans that ran out in the meantime are dropped.
+func (rl *RateLimiter) Restore(data []byte) error {
+	snap, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if ss, ok := rl.store.(SnapshotStore); ok && !rl.localStore() && len(snap.Store) > 0 {
+		if err := ss.Restore(context.Background(), snap.Store); err != nil {
+			return err
+		}
+	}
+	rl.memory.restore(snap.Visitors, snap.TakenAt)
+	now := rl.now()
+	for key, until := range snap.Bans {
+		if until.After(now) {
+			rl.bans.ban(key, until)
+		}
+	}
+	if rl.quotas != nil {
+		rl.quotas.restore(snap.Quotas, rl.storeNow(now))
+	}
+	return nil
+}
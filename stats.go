@@ -0,0 +1,198 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DecisionCounts holds the number of allowed and denied requests
+type DecisionCounts struct {
+	Allowed uint64 `json:"allowed"`
+	Denied  uint64 `json:"denied"`
+}
+
+// decisionCounters accumulates decisions between SnapshotAndResetStats calls
+type decisionCounters struct {
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+}
+
+// add counts a single decision
+func (c *decisionCounters) add(denied bool) {
+	if denied {
+		c.denied.Add(1)
+	} else {
+		c.allowed.Add(1)
+	}
+}
+
+// load returns the current counts
+func (c *decisionCounters) load() DecisionCounts {
+	return DecisionCounts{Allowed: c.allowed.Load(), Denied: c.denied.Load()}
+}
+
+// recordDecision counts a decision for DenyRate, SnapshotAndResetStats and
+// Metrics. route is nil for requests that matched no route.
+func (rl *RateLimiter) recordDecision(now time.Time, route *Route, denied bool) {
+	rl.denies.record(now, denied)
+	rl.counts.add(denied)
+	totals := *rl.totals.Load()
+	counters := totals[""]
+	if route != nil && totals[route.Pattern] != nil {
+		counters = totals[route.Pattern]
+	}
+	counters.add(denied)
+}
+
+// SnapshotAndResetStats returns the decisions counted since the previous call
+// and starts counting from zero. Each counter is swapped atomically, so no
+// decision is lost or reported twice across calls, which makes it suitable
+// for pushing per-interval deltas to a metrics pipeline.
+func (rl *RateLimiter) SnapshotAndResetStats() DecisionCounts {
+	return DecisionCounts{
+		Allowed: rl.counts.allowed.Swap(0),
+		Denied:  rl.counts.denied.Swap(0),
+	}
+}
+
+// Metrics is a point-in-time view of a limiter for monitoring systems
+type Metrics struct {
+	// Decisions holds the decisions made since New, by route pattern. Requests
+	// matching no route, and decisions made outside the middleware, are
+	// counted under "".
+	Decisions map[string]DecisionCounts `json:"decisions"`
+	// Visitors is the number of keys currently tracked in memory
+	Visitors int `json:"visitors"`
+	// ShardSkew is how many times the average in-memory shard's visitors the
+	// fullest shard holds, 1 when they are spread perfectly evenly
+	ShardSkew float64 `json:"shard_skew"`
+	// LastCleanup is how long the most recent cleanup run took
+	LastCleanup time.Duration `json:"last_cleanup"`
+	// StoreErrors is the number of failed Store operations since New
+	StoreErrors uint64 `json:"store_errors"`
+	// Degraded reports whether the most recent Store operation failed, so
+	// requests are currently handled by the FailurePolicy
+	Degraded bool `json:"degraded"`
+	// BreakerOpen reports whether the store's circuit breaker is open, so the
+	// store is only tried every StoreRetryInterval
+	BreakerOpen bool `json:"breaker_open"`
+	// LoadPenalty is the number of tokens each request currently costs
+	// because of server load, see LoadPenalty
+	LoadPenalty int `json:"load_penalty"`
+}
+
+// Metrics returns the limiter's cumulative counters and current size. Unlike
+// SnapshotAndResetStats it never resets anything, so it suits pull-based
+// monitoring systems such as Prometheus.
+func (rl *RateLimiter) Metrics() Metrics {
+	totals := *rl.totals.Load()
+	m := Metrics{
+		Decisions:   make(map[string]DecisionCounts, len(totals)),
+		LastCleanup: time.Duration(rl.cleanup.Load()),
+		StoreErrors: rl.health.errors.Load(),
+		Degraded:    rl.health.degraded.Load(),
+		BreakerOpen: rl.health.breaker.open(),
+		LoadPenalty: rl.LoadPenalty(),
+	}
+	for pattern, counters := range totals {
+		m.Decisions[pattern] = counters.load()
+	}
+	shards := rl.memory.ShardBalance()
+	for _, n := range shards {
+		m.Visitors += n
+	}
+	m.ShardSkew = shardSkew(shards)
+	return m
+}
+
+// ShardBalance returns the number of visitors in each shard of the in-memory
+// store, see MemoryStore.ShardBalance
+func (rl *RateLimiter) ShardBalance() []int {
+	return rl.memory.ShardBalance()
+}
+
+// Stats is a summary of a limiter's activity
+type Stats struct {
+	// Visitors is the number of keys currently tracked in memory
+	Visitors int `json:"visitors"`
+	// Allowed is the number of requests allowed since New
+	Allowed uint64 `json:"allowed"`
+	// Denied is the number of requests denied since New
+	Denied uint64 `json:"denied"`
+}
+
+// Stats returns the number of tracked keys and the decisions made since New,
+// over all routes. Metrics breaks the decisions down by route.
+func (rl *RateLimiter) Stats() Stats {
+	s := Stats{Visitors: rl.memory.len()}
+	for _, counters := range *rl.totals.Load() {
+		counts := counters.load()
+		s.Allowed += counts.Allowed
+		s.Denied += counts.Denied
+	}
+	return s
+}
+
+// VisitorInfo is a client's current standing, e.g. for a /me/limits endpoint
+type VisitorInfo struct {
+	// Key is the client identity the info was requested for
+	Key string `json:"key"`
+	// Tokens is the number of requests the client can make right now
+	Tokens float64 `json:"tokens"`
+	// Limit is the client's rate in requests per second
+	Limit float64 `json:"limit"`
+	// Burst is the client's bucket size
+	Burst int `json:"burst"`
+	// LastSeen is when the client's latest request arrived
+	LastSeen time.Time `json:"last_seen"`
+	// Requests and Denials count the client's requests since it was first
+	// tracked
+	Requests uint64 `json:"requests"`
+	Denials  uint64 `json:"denials"`
+	// BannedUntil is set while the client is banned
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+// VisitorInfo returns the standing of a client identity, as returned by
+// KeyFunc, or false if it isn't tracked. With a shared Store the tokens are
+// read from the store.
+func (rl *RateLimiter) VisitorInfo(identity string) (VisitorInfo, bool) {
+	key := rl.storageKey(identity)
+	now := rl.now()
+	shard := rl.memory.shard(key)
+	shard.mx.Lock()
+	v, exists := shard.visitors.get(key)
+	var state AdminKeyState
+	var limiter *rate.Limiter
+	if exists {
+		state, limiter = rl.adminState(key, v, now), v.limiter
+	}
+	shard.mx.Unlock()
+
+	if limiter != nil && rl.store != Store(rl.memory) {
+		if res, ok, err := rl.storeGet(context.Background(), key, limiter); ok && err == nil {
+			state.Tokens = res.Remaining
+		}
+	}
+	banned := rl.bans.banned(key, now)
+	if !exists && banned == 0 {
+		return VisitorInfo{}, false
+	}
+	info := VisitorInfo{
+		Key:      identity,
+		Tokens:   state.Tokens,
+		Limit:    state.Limit,
+		Burst:    state.Burst,
+		LastSeen: state.LastSeen,
+		Requests: state.Requests,
+		Denials:  state.Denials,
+	}
+	if banned > 0 {
+		until := now.Add(banned)
+		info.BannedUntil = &until
+	}
+	return info, true
+}
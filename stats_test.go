@@ -0,0 +1,97 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAndResetStatsLosesNothing(t *testing.T) {
+	const workers, requests, burst = 8, 500, 100
+	rl := New(&Config{RequestsPerSecond: 0.001, Burst: burst, Clock: NewManualClock(time.Unix(0, 0))})
+	defer rl.Close()
+
+	var total DecisionCounts
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for {
+			s := rl.SnapshotAndResetStats()
+			total.Allowed += s.Allowed
+			total.Denied += s.Denied
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			for range requests {
+				rl.Allow(key)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-reported
+	s := rl.SnapshotAndResetStats()
+	total.Allowed += s.Allowed
+	total.Denied += s.Denied
+
+	want := DecisionCounts{Allowed: workers * burst, Denied: workers * (requests - burst)}
+	if total != want {
+		t.Errorf("snapshots added up to %+v, want %+v", total, want)
+	}
+	if s := rl.SnapshotAndResetStats(); s != (DecisionCounts{}) {
+		t.Errorf("snapshot after reset = %+v, want zero", s)
+	}
+	if m := rl.Metrics().Decisions[""]; m != want {
+		t.Errorf("Metrics counts %+v, want %+v: they must not be reset", m, want)
+	}
+}
+
+func TestUnenforcedDenialsCountOnce(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"dry run", Config{DryRun: true}},
+		{"warmup", Config{WarmupDuration: time.Hour}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.RequestsPerSecond = 0.001
+			cfg.Burst = 1
+			cfg.GlobalRequestsPerSecond = 1
+			cfg.GlobalBurst = 1
+			cfg.Quotas = []Quota{{Limit: 1, Period: time.Hour}}
+			cfg.Limits = []Limit{{Name: "hourly", Requests: 1, Period: time.Hour}}
+			cfg.Clock = NewManualClock(time.Unix(0, 0))
+			rl := New(&cfg)
+			defer rl.Close()
+			h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			for range 3 {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			// the global bucket is spent, so these fail it as well
+			for range 3 {
+				rl.Allow("a")
+			}
+			if s := rl.Stats(); s.Allowed != 1 || s.Denied != 5 {
+				t.Errorf("Stats = %d allowed, %d denied; want 1 and 5, one decision per request", s.Allowed, s.Denied)
+			}
+		})
+	}
+}
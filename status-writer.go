@@ -0,0 +1,39 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// statusWriter records the status code written by the wrapped handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher for handlers that stream, such as server-sent
+// events. It does nothing if the underlying writer can't flush.
+func (sw *statusWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for handlers that take over the
+// connection, such as WebSocket upgrades
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(sw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
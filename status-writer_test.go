@@ -0,0 +1,55 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackRecorder is a ResponseRecorder whose connection can be hijacked
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hr.hijacked = true
+	c, _ := net.Pipe()
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+func TestStatusWriterPassesFlushAndHijack(t *testing.T) {
+	configs := map[string]*Config{
+		"upstream": {UpstreamErrorThreshold: 0.5},
+		"refund":   {RefundStatuses: []int{http.StatusNotModified}},
+		"charge":   {ChargeStatuses: []int{http.StatusUnauthorized}},
+	}
+	for name, cfg := range configs {
+		rl := New(cfg)
+		h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/events" {
+				w.(http.Flusher).Flush()
+				return
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("%s: Hijack: %v", name, err)
+				return
+			}
+			conn.Close()
+		}))
+
+		rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+		if !rec.Flushed {
+			t.Errorf("%s: Flush didn't reach the underlying writer", name)
+		}
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+		if !rec.hijacked {
+			t.Errorf("%s: Hijack didn't reach the underlying writer", name)
+		}
+		rl.Close()
+	}
+}
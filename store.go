@@ -0,0 +1,352 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Store holds the token buckets requests are admitted against. The default
+// MemoryStore keeps them in process; a shared store such as Redis lets several
+// instances behind a load balancer enforce one limit together instead of each
+// allowing the full rate.
+type Store interface {
+	// Allow takes n tokens from key's bucket, creating the bucket with limit
+	// and burst if it doesn't exist, and reports the bucket's state afterwards
+	Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error)
+	// Get returns key's bucket state without taking tokens. ok is false if
+	// the store has no bucket for key.
+	Get(ctx context.Context, key string) (res Result, ok bool, err error)
+	// Touch marks key as active so it isn't removed as idle
+	Touch(ctx context.Context, key string) error
+	// Cleanup removes buckets that have been idle for at least maxIdle.
+	// Stores that expire keys on their own may do nothing.
+	Cleanup(ctx context.Context, maxIdle time.Duration) error
+}
+
+// Result describes a bucket after a Store operation
+type Result struct {
+	// Allowed reports whether the requested tokens were taken
+	Allowed bool
+	// Remaining is the number of tokens left in the bucket
+	Remaining float64
+	// Limit is the bucket's refill rate in tokens per second
+	Limit rate.Limit
+	// Burst is the bucket's capacity
+	Burst int
+	// RetryAfter is how long until the requested tokens are available, zero
+	// if they were taken
+	RetryAfter time.Duration
+	// At is when the store decided by its own clock, for stores that keep
+	// time themselves such as a Redis server. It is zero for stores using the
+	// caller's clock.
+	At time.Time
+
+	failed bool // denied by FailClosed, not by the bucket
+}
+
+// memoryShards is the number of independently locked shards a MemoryStore
+// is split into. It must be a power of two.
+const memoryShards = 64
+
+// MemoryStore is the default in-process Store. It also holds the per-visitor
+// state behind features that only work locally, such as boosts and request
+// counts. Visitors are spread over shards by key hash so concurrent requests
+// for different keys rarely contend for the same lock.
+type MemoryStore struct {
+	shards   [memoryShards]memoryShard
+	clock    Clock       // set by New from Config.Clock; nil means the system clock
+	workers  int         // shards cleaned at once, set by New from Config.CleanupWorkers
+	lockFree atomic.Bool // visitors can be looked up without the shard lock
+}
+
+// memoryShard holds the visitors whose keys hash to it
+type memoryShard struct {
+	mx       sync.Mutex
+	visitors visitorMap
+	lru      *list.List // keys, most recently seen first; nil without a cap
+	max      int
+	hooks    *visitorHooks // nil without OnNewVisitor or OnEvict
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	ms := &MemoryStore{}
+	for i := range ms.shards {
+		ms.shards[i].visitors = make(plainVisitors)
+	}
+	return ms
+}
+
+// now returns the current time according to the store's clock
+func (ms *MemoryStore) now() time.Time {
+	if ms.clock == nil {
+		return time.Now()
+	}
+	return ms.clock.Now()
+}
+
+// shard returns the shard holding key
+func (ms *MemoryStore) shard(key string) *memoryShard {
+	return &ms.shards[fnv32a(key)&(memoryShards-1)]
+}
+
+// add stores a new visitor, evicting the least recently seen ones if the
+// shard is over its cap
+func (s *memoryShard) add(key string, v *visitor) {
+	s.visitors.put(key, v)
+	s.added(key, v)
+	if s.lru == nil {
+		return
+	}
+	v.elem = s.lru.PushFront(key)
+	for s.visitors.len() > s.max {
+		oldest := s.lru.Back().Value.(string)
+		v, _ := s.visitors.get(oldest)
+		s.evict(oldest, v, EvictCapacity)
+	}
+}
+
+// touch marks v as the shard's most recently seen visitor
+func (s *memoryShard) touch(v *visitor) {
+	if s.lru != nil && v.elem != nil {
+		s.lru.MoveToFront(v.elem)
+	}
+}
+
+// remove deletes a visitor
+func (s *memoryShard) remove(key string, v *visitor) {
+	s.visitors.del(key)
+	if s.lru != nil && v.elem != nil {
+		s.lru.Remove(v.elem)
+	}
+}
+
+// limitVisitors caps the number of visitors at roughly n, evicting the
+// least recently seen as new ones arrive. The cap is split evenly between
+// shards, so it is rounded up to a multiple of the shard count.
+func (ms *MemoryStore) limitVisitors(n int) {
+	perShard := (n + memoryShards - 1) / memoryShards
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		shard.max = perShard
+		if shard.lru == nil {
+			shard.lru = list.New()
+			shard.visitors.each(func(key string, v *visitor) {
+				v.elem = shard.lru.PushFront(key)
+			})
+		}
+		shard.mx.Unlock()
+	}
+}
+
+// syncVisitors moves every shard's visitors into a syncVisitors map. Unless
+// the shards keep an LRU list, which every lookup reorders, visitors can
+// then be looked up without their shard's lock.
+func (ms *MemoryStore) syncVisitors() {
+	lockFree := true
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		if _, ok := shard.visitors.(*syncVisitors); !ok {
+			m := &syncVisitors{}
+			shard.visitors.each(m.put)
+			shard.visitors = m
+		}
+		lockFree = lockFree && shard.lru == nil
+		shard.mx.Unlock()
+	}
+	ms.lockFree.Store(lockFree)
+}
+
+// each calls fn for every visitor, holding one shard's lock at a time
+func (ms *MemoryStore) each(fn func(key string, v *visitor)) {
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		shard.visitors.each(fn)
+		shard.mx.Unlock()
+	}
+}
+
+// len returns the number of visitors
+func (ms *MemoryStore) len() int {
+	n := 0
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		n += shard.visitors.len()
+		shard.mx.Unlock()
+	}
+	return n
+}
+
+// ShardBalance returns the number of visitors in each shard. Keys are spread
+// by hash, so the counts should be close to each other; one far above the
+// rest means skewed keys are contending for the same lock.
+func (ms *MemoryStore) ShardBalance() []int {
+	counts := make([]int, memoryShards)
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		counts[i] = shard.visitors.len()
+		shard.mx.Unlock()
+	}
+	return counts
+}
+
+// shardSkew returns how many times the average shard's visitors the fullest
+// shard holds, 1 for perfectly even shards or none at all
+func shardSkew(counts []int) float64 {
+	total, fullest := 0, 0
+	for _, n := range counts {
+		total += n
+		fullest = max(fullest, n)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(fullest) * float64(len(counts)) / float64(total)
+}
+
+// clear removes every visitor
+func (ms *MemoryStore) clear() {
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		shard.visitors.reset()
+		if shard.lru != nil {
+			shard.lru.Init()
+		}
+		shard.mx.Unlock()
+	}
+}
+
+// Allow implements Store. limit and burst are only used when the bucket is
+// created, so per-key limits set on the RateLimiter are kept.
+func (ms *MemoryStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	shard := ms.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	now := ms.now()
+	v, exists := shard.visitors.get(key)
+	if !exists {
+		v = newVisitor(rate.NewLimiter(limit, burst), now)
+		shard.add(key, v)
+	}
+	if v.limiter == nil {
+		v.limiter = rate.NewLimiter(limit, burst)
+	}
+	v.lastSeen.Store(now)
+	shard.touch(v)
+	return limiterResult(v.limiter, now, n, v.limiter.AllowN(now, n)), nil
+}
+
+// Get implements Store
+func (ms *MemoryStore) Get(ctx context.Context, key string) (Result, bool, error) {
+	shard := ms.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	v, exists := shard.visitors.get(key)
+	if !exists || v.limiter == nil {
+		return Result{}, false, nil
+	}
+	return limiterResult(v.limiter, ms.now(), 1, true), true, nil
+}
+
+// Touch implements Store
+func (ms *MemoryStore) Touch(ctx context.Context, key string) error {
+	shard := ms.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	if v, exists := shard.visitors.get(key); exists {
+		v.lastSeen.Store(ms.now())
+		shard.touch(v)
+	}
+	return nil
+}
+
+// Cleanup implements Store. Keys with an active boost are kept until the
+// boost expires, keys pinned by SetVisitorLimit until OverrideTTL has
+// passed, and keys whose window still counts requests until it no longer
+// does. With CleanupWorkers set, several shards are cleaned at once.
+func (ms *MemoryStore) Cleanup(ctx context.Context, maxIdle time.Duration) error {
+	now := ms.now()
+	workers := min(ms.workers, runtime.GOMAXPROCS(0), memoryShards)
+	if workers <= 1 {
+		for i := range ms.shards {
+			ms.shards[i].cleanup(now, maxIdle)
+		}
+		return nil
+	}
+	var next atomic.Int32
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next.Add(1) - 1; i < memoryShards; i = next.Add(1) - 1 {
+				ms.shards[i].cleanup(now, maxIdle)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// cleanup removes the shard's visitors idle for at least maxIdle at now
+func (s *memoryShard) cleanup(now time.Time, maxIdle time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.visitors.each(func(key string, v *visitor) {
+		v.expireBoost(now)
+		if v.window != nil && v.window.active(now) || now.Before(v.pinned) {
+			return
+		}
+		if v.boost == nil && now.Sub(v.lastSeen.Load()) >= maxIdle {
+			s.evict(key, v, EvictIdle)
+		}
+	})
+}
+
+// limiterResult describes limiter after a request for n tokens
+func limiterResult(limiter *rate.Limiter, now time.Time, n int, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: max(0, limiter.TokensAt(now)),
+		Limit:     limiter.Limit(),
+		Burst:     limiter.Burst(),
+	}
+	if !allowed {
+		res.RetryAfter = waitFor(limiter, now, n)
+	}
+	return res
+}
+
+// waitFor returns how long until limiter has n tokens available
+func waitFor(limiter *rate.Limiter, now time.Time, n int) time.Duration {
+	return tokenWait(limiter.TokensAt(now), limiter.Limit(), n)
+}
+
+// tokenWait returns how long a bucket holding tokens and refilling at limit
+// takes to reach n tokens
+func tokenWait(tokens float64, limit rate.Limit, n int) time.Duration {
+	missing := float64(n) - tokens
+	if missing <= 0 || limit == rate.Inf {
+		return 0
+	}
+	if limit <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(missing / float64(limit) * float64(time.Second))
+}
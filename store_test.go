@@ -0,0 +1,298 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fillMemoryStore adds n visitors to ms, the even ones last seen at idle and
+// the odd ones at now
+func fillMemoryStore(ms *MemoryStore, n int, idle, now time.Time) {
+	for i := range n {
+		key := strconv.Itoa(i)
+		seen := now
+		if i%2 == 0 {
+			seen = idle
+		}
+		shard := ms.shard(key)
+		shard.mx.Lock()
+		shard.add(key, newVisitor(rate.NewLimiter(1, 1), seen))
+		shard.mx.Unlock()
+	}
+}
+
+func TestMemoryStoreParallelCleanup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			ms := NewMemoryStore()
+			ms.clock = NewManualClock(now)
+			ms.workers = workers
+			fillMemoryStore(ms, 1000, now.Add(-time.Hour), now)
+
+			ms.Cleanup(context.Background(), time.Minute)
+			if n := ms.len(); n != 500 {
+				t.Fatalf("%d visitors left, want the 500 recent ones", n)
+			}
+			for i := 1; i < 1000; i += 2 {
+				shard := ms.shard(strconv.Itoa(i))
+				if _, ok := shard.visitors.get(strconv.Itoa(i)); !ok {
+					t.Fatalf("recent visitor %d was removed", i)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryStoreCleanup(b *testing.B) {
+	now := time.Unix(1000, 0)
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			ms := NewMemoryStore()
+			ms.clock = NewManualClock(now)
+			ms.workers = workers
+			// every visitor is recent, so each run scans them all
+			fillMemoryStore(ms, 200_000, now, now)
+			b.ResetTimer()
+			for range b.N {
+				ms.Cleanup(context.Background(), time.Minute)
+			}
+		})
+	}
+}
+
+// singleLockStore serializes every key behind one mutex, as MemoryStore did
+// before it was sharded
+type singleLockStore struct {
+	mx sync.Mutex
+	ms *MemoryStore
+}
+
+func (s *singleLockStore) Allow(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.ms.Allow(ctx, key, limit, burst, n)
+}
+
+// BenchmarkMemoryStoreParallel compares the sharded store against a single
+// lock for many clients making requests in parallel
+func BenchmarkMemoryStoreParallel(b *testing.B) {
+	keys := make([]string, 10_000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&0xff, i&0xff)
+	}
+	stores := []struct {
+		name  string
+		allow func(ctx context.Context, key string, limit rate.Limit, burst, n int) (Result, error)
+	}{
+		{"sharded", NewMemoryStore().Allow},
+		{"single-lock", (&singleLockStore{ms: NewMemoryStore()}).Allow},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			ctx := context.Background()
+			for _, key := range keys {
+				s.allow(ctx, key, 1e9, 1e9, 1)
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 7919
+				for pb.Next() {
+					s.allow(ctx, keys[i%len(keys)], 1e9, 1e9, 1)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkNewKeys measures a flood of keys the limiter hasn't seen, as from
+// a scan, each of which inserts into its shard under the shard's lock.
+// Banned keys are turned away before reaching the visitor table.
+func BenchmarkNewKeys(b *testing.B) {
+	cases := []struct {
+		name   string
+		config Config
+		banned bool
+	}{
+		{"unbounded", Config{}, false},
+		{"MaxVisitors", Config{MaxVisitors: 4096}, false},
+		{"PenaltyBox", Config{MaxVisitors: 4096, PenaltyBoxSize: 4096}, false},
+		{"banned", Config{}, true},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			c.config.RequestsPerSecond, c.config.Burst = 10, 10
+			rl := New(&c.config)
+			defer rl.Close()
+			const banned = 1 << 16
+			if c.banned {
+				for i := range banned {
+					rl.Ban(strconv.Itoa(i), time.Hour)
+				}
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(next.Add(1))
+					if c.banned {
+						i %= banned
+					}
+					rl.Allow(strconv.Itoa(i))
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStoreShardBalance(t *testing.T) {
+	ms := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	// keys shaped like real ones: IPs from one /16 and sequential user IDs
+	for i := range 6400 {
+		for _, key := range []string{fmt.Sprintf("10.0.%d.%d", i/256, i%256), "user:" + strconv.Itoa(i)} {
+			shard := ms.shard(key)
+			shard.mx.Lock()
+			shard.add(key, newVisitor(rate.NewLimiter(1, 1), now))
+			shard.mx.Unlock()
+		}
+	}
+
+	counts := ms.ShardBalance()
+	if len(counts) != memoryShards {
+		t.Fatalf("%d shard counts, want %d", len(counts), memoryShards)
+	}
+	total := 0
+	for i, n := range counts {
+		total += n
+		// 200 per shard on average; allow for the spread of a good hash
+		if n < 140 || n > 260 {
+			t.Errorf("shard %d holds %d visitors, want about 200", i, n)
+		}
+	}
+	if total != 12800 {
+		t.Errorf("shards hold %d visitors in total, want 12800", total)
+	}
+	if skew := shardSkew(counts); skew > 1.3 {
+		t.Errorf("shard skew %.2f, want close to 1", skew)
+	}
+	if skew := shardSkew(make([]int, memoryShards)); skew != 1 {
+		t.Errorf("empty shards have skew %v, want 1", skew)
+	}
+}
+
+func TestSyncVisitorMap(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	rl := New(&Config{RequestsPerSecond: 1, Burst: 2, SyncVisitorMap: true, MaxIdleTime: time.Minute, Clock: clock})
+	defer rl.Close()
+	if !rl.memory.lockFree.Load() {
+		t.Fatal("lookups take the shard lock with a sync visitor map")
+	}
+
+	for i, want := range []bool{true, true, false} {
+		if got := rl.Allow("a"); got != want {
+			t.Fatalf("request %d: allowed = %v, want %v", i, got, want)
+		}
+	}
+	rl.Allow("b")
+	if counts := rl.KeyCounts(); counts["a"] != 3 || counts["b"] != 1 {
+		t.Errorf("KeyCounts = %v, want a:3 b:1", counts)
+	}
+
+	// boosted visitors go through the lock, which expires the boost
+	rl.BoostKey("b", 100, 100, time.Second)
+	clock.Advance(2 * time.Second)
+	rl.Allow("b")
+	if info, _ := rl.VisitorInfo("b"); info.Limit != 1 {
+		t.Errorf("limit %v after the boost expired, want 1", info.Limit)
+	}
+
+	clock.Advance(30 * time.Second)
+	rl.Allow("a")
+	clock.Advance(45 * time.Second)
+	rl.memory.Cleanup(context.Background(), time.Minute)
+	if n := rl.memory.len(); n != 1 {
+		t.Errorf("%d visitors after cleanup, want only the recent one", n)
+	}
+}
+
+func TestSyncVisitorMapWithMaxVisitorsLocks(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 1, Burst: 1, SyncVisitorMap: true, MaxVisitors: 64})
+	defer rl.Close()
+	if rl.memory.lockFree.Load() {
+		t.Error("lookups skip the lock although MaxVisitors reorders the LRU list")
+	}
+	for i := range 1000 {
+		rl.Allow(strconv.Itoa(i))
+	}
+	if n := rl.memory.len(); n > 64 {
+		t.Errorf("%d visitors, want at most 64", n)
+	}
+}
+
+func TestSyncVisitorMapConcurrentUse(t *testing.T) {
+	rl := New(&Config{RequestsPerSecond: 1000, Burst: 1000, SyncVisitorMap: true})
+	defer rl.Close()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2000 {
+				key := strconv.Itoa(i % 50)
+				switch {
+				case w == 0 && i%100 == 0:
+					rl.BoostKey(key, 2000, 2000, time.Millisecond)
+				case w == 1 && i%100 == 0:
+					rl.memory.Cleanup(context.Background(), time.Hour)
+				default:
+					rl.Allow(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var total uint64
+	for _, n := range rl.KeyCounts() {
+		total += n
+	}
+	if total != 8*2000-40 {
+		t.Errorf("%d requests counted, want %d", total, 8*2000-40)
+	}
+}
+
+// BenchmarkVisitorMap compares the visitor maps for a read-heavy workload:
+// a fixed set of clients, already tracked, making requests in parallel
+func BenchmarkVisitorMap(b *testing.B) {
+	for _, syncMap := range []bool{false, true} {
+		name := map[bool]string{false: "map", true: "sync.Map"}[syncMap]
+		b.Run(name, func(b *testing.B) {
+			rl := New(&Config{RequestsPerSecond: 1e9, Burst: 1e9, SyncVisitorMap: syncMap})
+			defer rl.Close()
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+				rl.Allow(keys[i])
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 7919
+				for pb.Next() {
+					rl.Allow(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
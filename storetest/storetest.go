@@ -0,0 +1,184 @@
+// Package storetest is a conformance suite for ratelimiter.Store
+// implementations. Call Run from a test in the store's package:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) ratelimiter.Store {
+//			return mystore.New(connect(t), t.Name()+":")
+//		})
+//	}
+//
+// newStore is called once per subtest and must return a store whose keys
+// don't overlap with those of earlier calls, e.g. by using a fresh prefix.
+// Some checks wait for buckets to refill, so the suite takes about a second.
+package storetest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"golang.org/x/time/rate"
+)
+
+// Run runs the conformance suite against stores made by newStore
+func Run(t *testing.T, newStore func(t *testing.T) ratelimiter.Store) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, ratelimiter.Store)
+	}{
+		{"AllowsBurst", testAllowsBurst},
+		{"TakesN", testTakesN},
+		{"Refills", testRefills},
+		{"KeysAreIndependent", testKeysAreIndependent},
+		{"GetMissing", testGetMissing},
+		{"GetDoesNotTake", testGetDoesNotTake},
+		{"Concurrent", testConcurrent},
+		{"TouchAndCleanup", testTouchAndCleanup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// slow is a rate low enough that nothing refills during a test
+const slow = rate.Limit(0.001)
+
+func allow(t *testing.T, s ratelimiter.Store, key string, limit rate.Limit, burst, n int) ratelimiter.Result {
+	t.Helper()
+	res, err := s.Allow(context.Background(), key, limit, burst, n)
+	if err != nil {
+		t.Fatalf("Allow(%q): %v", key, err)
+	}
+	return res
+}
+
+func testAllowsBurst(t *testing.T, s ratelimiter.Store) {
+	for i := range 5 {
+		res := allow(t, s, "burst", slow, 5, 1)
+		if !res.Allowed {
+			t.Fatalf("request %d of a burst of 5 was denied", i+1)
+		}
+		if want := float64(4 - i); res.Remaining < want-0.01 || res.Remaining > want+0.01 {
+			t.Errorf("request %d: Remaining = %v, want %v", i+1, res.Remaining, want)
+		}
+		if res.Limit != slow || res.Burst != 5 {
+			t.Errorf("request %d: Limit, Burst = %v, %d, want %v, 5", i+1, res.Limit, res.Burst, slow)
+		}
+	}
+	res := allow(t, s, "burst", slow, 5, 1)
+	if res.Allowed {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if res.RetryAfter <= 0 {
+		t.Errorf("denied request has RetryAfter %v, want > 0", res.RetryAfter)
+	}
+}
+
+func testTakesN(t *testing.T, s ratelimiter.Store) {
+	if res := allow(t, s, "n", slow, 10, 7); !res.Allowed {
+		t.Fatal("taking 7 of 10 tokens was denied")
+	}
+	if res := allow(t, s, "n", slow, 10, 4); res.Allowed {
+		t.Fatal("taking 4 of the 3 remaining tokens was allowed")
+	}
+	if res := allow(t, s, "n", slow, 10, 3); !res.Allowed {
+		t.Fatal("taking the 3 remaining tokens was denied; a denied request must not take tokens")
+	}
+}
+
+func testRefills(t *testing.T, s ratelimiter.Store) {
+	allow(t, s, "refill", 20, 1, 1)
+	res := allow(t, s, "refill", 20, 1, 1)
+	if res.Allowed {
+		t.Fatal("second request on an empty bucket was allowed")
+	}
+	if res.RetryAfter > 100*time.Millisecond {
+		t.Errorf("RetryAfter = %v at 20 tokens per second, want at most 50ms", res.RetryAfter)
+	}
+	time.Sleep(1100 * time.Millisecond) // stores may keep time in whole seconds
+	if res := allow(t, s, "refill", 20, 1, 1); !res.Allowed {
+		t.Fatal("request after the bucket refilled was denied")
+	}
+}
+
+func testKeysAreIndependent(t *testing.T, s ratelimiter.Store) {
+	allow(t, s, "a", slow, 1, 1)
+	if res := allow(t, s, "b", slow, 1, 1); !res.Allowed {
+		t.Fatal("emptying one key's bucket denied another key")
+	}
+}
+
+func testGetMissing(t *testing.T, s ratelimiter.Store) {
+	_, ok, err := s.Get(context.Background(), "missing")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ok {
+		t.Fatal("Get reported a bucket for a key never used")
+	}
+}
+
+func testGetDoesNotTake(t *testing.T, s ratelimiter.Store) {
+	allow(t, s, "get", slow, 3, 1)
+	for range 3 {
+		res, ok, err := s.Get(context.Background(), "get")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !ok {
+			t.Fatal("Get found no bucket after Allow")
+		}
+		if res.Remaining < 1.99 || res.Remaining > 2.01 {
+			t.Fatalf("Get: Remaining = %v, want 2", res.Remaining)
+		}
+		if res.Limit != slow || res.Burst != 3 {
+			t.Fatalf("Get: Limit, Burst = %v, %d, want %v, 3", res.Limit, res.Burst, slow)
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, s ratelimiter.Store) {
+	const burst, workers = 20, 50
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.Allow(context.Background(), "concurrent", slow, burst, 1)
+			if err != nil {
+				t.Errorf("Allow: %v", err)
+				return
+			}
+			if res.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != burst {
+		t.Fatalf("%d concurrent requests allowed against a burst of %d", n, burst)
+	}
+}
+
+func testTouchAndCleanup(t *testing.T, s ratelimiter.Store) {
+	ctx := context.Background()
+	if err := s.Touch(ctx, "never-used"); err != nil {
+		t.Errorf("Touch on a missing key: %v", err)
+	}
+	allow(t, s, "touched", slow, 2, 1)
+	if err := s.Touch(ctx, "touched"); err != nil {
+		t.Errorf("Touch: %v", err)
+	}
+	if err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Errorf("Cleanup: %v", err)
+	}
+	if _, ok, err := s.Get(ctx, "touched"); err != nil || !ok {
+		t.Errorf("Get after Cleanup with a long idle time: ok = %v, err = %v; want the bucket kept", ok, err)
+	}
+}
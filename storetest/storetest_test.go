@@ -0,0 +1,60 @@
+package storetest_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/gigatar/ratelimiter/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) ratelimiter.Store {
+		return ratelimiter.NewMemoryStore()
+	})
+}
+
+func TestKVStore(t *testing.T) {
+	kv := &mapKV{values: map[string]mapValue{}}
+	storetest.Run(t, func(t *testing.T) ratelimiter.Store {
+		return ratelimiter.NewKVStore(kv, t.Name()+":")
+	})
+}
+
+// mapKV is an in-memory ratelimiter.KV. Expiry is ignored.
+type mapKV struct {
+	mx     sync.Mutex
+	values map[string]mapValue
+}
+
+type mapValue struct {
+	data    []byte
+	version int
+}
+
+func (m *mapKV) Get(ctx context.Context, key string) ([]byte, any, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	return v.data, v.version, nil
+}
+
+func (m *mapKV) CompareAndSwap(ctx context.Context, key string, value []byte, version any, ttl time.Duration) (bool, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	v, ok := m.values[key]
+	if ok != (version != nil) || ok && v.version != version.(int) {
+		return false, nil
+	}
+	m.values[key] = mapValue{data: value, version: v.version + 1}
+	return true, nil
+}
+
+func (m *mapKV) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return nil
+}
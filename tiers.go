@@ -0,0 +1,29 @@
+package ratelimiter
+
+import "net/http"
+
+// Tier holds the limits for one plan, such as "free" or "pro"
+type Tier struct {
+	// RequestsPerSecond is the number of requests allowed per second.
+	// Defaults to Config.RequestsPerSecond.
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is the maximum number of requests allowed in a burst. Defaults
+	// to Config.Burst.
+	Burst int `json:"burst" yaml:"burst" toml:"burst"`
+	// Unlimited exempts the tier from limiting altogether
+	Unlimited bool `json:"unlimited" yaml:"unlimited" toml:"unlimited"`
+}
+
+// resolveTier returns the name and limits of r's tier, or nil if it has
+// none or its tier isn't configured
+func (rl *RateLimiter) resolveTier(r *http.Request) (string, *Tier) {
+	if rl.config.TierResolver == nil {
+		return "", nil
+	}
+	name := rl.config.TierResolver(r)
+	tier, ok := rl.config.Tiers[name]
+	if !ok {
+		return "", nil
+	}
+	return name, &tier
+}
@@ -0,0 +1,101 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrLimited matches the errors Transport returns for requests over the
+// limit, with errors.Is
+var ErrLimited = errors.New("ratelimiter: rate limit exceeded")
+
+// LimitError is returned by a Transport for an outgoing request over the
+// limit. It unwraps to ErrLimited.
+type LimitError struct {
+	// Key is the key the request was limited by, the destination host by default
+	Key string
+	// RetryAfter is how long until the request would be allowed
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("ratelimiter: rate limit exceeded for %s, retry after %v", e.Key, e.RetryAfter)
+}
+
+// Unwrap returns ErrLimited
+func (e *LimitError) Unwrap() error {
+	return ErrLimited
+}
+
+// transport limits outgoing requests before handing them to base
+type transport struct {
+	limiter *RateLimiter
+	base    http.RoundTripper
+}
+
+// Transport returns an http.RoundTripper that limits outgoing requests, e.g.
+// to stay within a third-party API's quota, before passing them to base (or
+// http.DefaultTransport if nil). Requests are keyed by destination host, or
+// by TransportKeyFunc if set. Requests over the limit fail with a
+// *LimitError; in ModeWait they are delayed for up to MaxWait first. Bans,
+// the global limit and IP lists don't apply to outgoing requests.
+func (rl *RateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{limiter: rl, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.admitOutgoing(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// admitOutgoing takes tokens for an outgoing request, waiting for them in
+// ModeWait
+func (rl *RateLimiter) admitOutgoing(req *http.Request) error {
+	if rl.closed.Load() {
+		return nil
+	}
+	identity := req.URL.Host
+	if rl.config.TransportKeyFunc != nil {
+		if key := rl.config.TransportKeyFunc(req); key != "" {
+			identity = key
+		}
+	}
+	key := rl.storageKey(identity)
+	now := rl.now()
+	if rl.config.CountOnly {
+		rl.countVisitor(key)
+		rl.recordDecision(now, nil, false)
+		return nil
+	}
+
+	limit, burst := rl.defaultLimit()
+	limiter := rl.getVisitor(key, limit, burst)
+	cost := rl.requestWeight(req)
+	if cost > limiter.Burst() && rl.enforcing(now) {
+		rl.recordDecision(now, nil, true)
+		return ErrCostExceedsBurst
+	}
+	res := rl.take(req.Context(), key, limiter, cost, now)
+	if !res.Allowed && rl.config.Mode == ModeWait && rl.enforcing(now) {
+		var err error
+		if res, err = rl.waitTake(req.Context(), key, limiter, cost, res); err != nil {
+			rl.recordDecision(now, nil, true)
+			return err
+		}
+	}
+	rl.recordDecision(now, nil, !res.Allowed)
+	if !res.Allowed && rl.enforcing(now) {
+		return &LimitError{Key: identity, RetryAfter: res.RetryAfter}
+	}
+	return nil
+}
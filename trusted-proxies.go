@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// peerIP returns the address an allowlist or denylist decision is made on:
+// the connection's peer, or the client behind it if the peer is a trusted
+// proxy. Unlike clientIP it never trusts forwarding headers without
+// TrustedProxies, since a spoofed X-Forwarded-For would otherwise let any
+// client claim an allowlisted address or dodge the denylist.
+func (rl *RateLimiter) peerIP(r *http.Request) string {
+	return rl.trusted.peerIP(r)
+}
+
+// clientIP returns the client address of a request, see ipList.clientIP
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	return rl.trusted.clientIP(r)
+}
+
+// peerIP is RateLimiter.peerIP with l as the trusted proxies
+func (l *ipList) peerIP(r *http.Request) string {
+	if l.empty() {
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return remote
+	}
+	return l.clientIP(r)
+}
+
+// clientIP returns the client address of a request with l as the trusted
+// proxies. Without any it falls back to getClientIP. Otherwise forwarding headers are only
+// honoured when the request comes from a trusted proxy, and X-Forwarded-For
+// is walked right to left to the first hop that isn't one, since everything
+// left of it could have been made up by the client.
+func (l *ipList) clientIP(r *http.Request) string {
+	if l.empty() {
+		return getClientIP(r)
+	}
+
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !l.contains(remote) {
+		return remote
+	}
+
+	values := r.Header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		if realIP := r.Header.Get("X-Real-Ip"); realIP != "" {
+			return realIP
+		}
+		return remote
+	}
+	// walk the hops in place rather than splitting them into a slice, which
+	// would allocate on every proxied request
+	for i := len(values) - 1; i >= 0; i-- {
+		value := values[i]
+		for {
+			comma := strings.LastIndexByte(value, ',')
+			hop := strings.TrimSpace(value[comma+1:])
+			if comma < 0 && i == 0 {
+				return hop // the leftmost hop is the client however it looks
+			}
+			if !l.contains(hop) {
+				return hop
+			}
+			if comma < 0 {
+				break
+			}
+			value = value[:comma]
+		}
+	}
+	return remote
+}
@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPBehindTrustedProxies(t *testing.T) {
+	rl := New(&Config{TrustedProxies: []string{"192.0.2.0/24"}})
+	defer rl.Close()
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{name: "untrusted peer", remote: "198.51.100.1:1234", xff: []string{"203.0.113.9"}, want: "198.51.100.1"},
+		{name: "no forwarding headers", remote: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "real ip", remote: "192.0.2.1:1234", realIP: "203.0.113.9", want: "203.0.113.9"},
+		{name: "one hop", remote: "192.0.2.1:1234", xff: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed prefix", remote: "192.0.2.1:1234", xff: []string{"10.0.0.1, 203.0.113.9, 192.0.2.5"}, want: "203.0.113.9"},
+		{name: "several headers", remote: "192.0.2.1:1234", xff: []string{"203.0.113.9", "192.0.2.5,192.0.2.6"}, want: "203.0.113.9"},
+		{name: "all trusted", remote: "192.0.2.1:1234", xff: []string{"192.0.2.7, 192.0.2.8"}, want: "192.0.2.7"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := rl.clientIP(r); got != tc.want {
+				t.Errorf("clientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
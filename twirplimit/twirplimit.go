@@ -0,0 +1,77 @@
+// Package twirplimit applies a ratelimiter.RateLimiter to Twirp services,
+// so HTTP, gRPC and Twirp endpoints can share one set of limits.
+package twirplimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gigatar/ratelimiter"
+	"github.com/twitchtv/twirp"
+)
+
+// KeyFunc returns the key a call is limited by. method is the RPC's name,
+// e.g. "MakeHat". Calls for which it returns "" fall back to the client
+// address.
+type KeyFunc func(r *http.Request, method string) string
+
+// HeaderKey limits calls by the named request header, such as an API key
+// sent as "X-Api-Key"
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request, method string) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Middleware limits the calls to a Twirp server with rl, keyed by a key set
+// with ratelimiter.WithKey, by keyFunc or, failing both, by the client
+// address. Twirp servers are http.Handlers, so it wraps the server itself:
+//
+//	handler := twirplimit.Middleware(limiter, keyFunc)(haberdasher.NewHaberdasherServer(svc))
+//
+// Calls whose context was marked with ratelimiter.WithBypass aren't
+// limited. Denied calls fail with a Twirp resource_exhausted error, which
+// clients see as 429 Too Many Requests, carrying a Retry-After header and a
+// retry_after meta entry in seconds.
+func Middleware(rl *ratelimiter.RateLimiter, keyFunc KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ratelimiter.Bypassed(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed, wait := rl.AllowKey(callKey(r, keyFunc)); !allowed {
+				seconds := strconv.Itoa(retrySeconds(wait))
+				w.Header().Set("Retry-After", seconds)
+				twirp.WriteError(w, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded, retry after "+seconds+"s").WithMeta("retry_after", seconds))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// callKey returns the key a call is limited by
+func callKey(r *http.Request, keyFunc KeyFunc) string {
+	if key, ok := ratelimiter.KeyFromContext(r.Context()); ok {
+		return key
+	}
+	if keyFunc != nil {
+		if key := keyFunc(r, path.Base(r.URL.Path)); key != "" {
+			return key
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// retrySeconds rounds wait up to whole seconds, never returning less than one
+func retrySeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// upstreamMinSamples is the number of responses needed in the window before
+// the upstream breaker may trip, so a single early failure doesn't tighten limits
+const upstreamMinSamples = 10
+
+// upstreamHealth tracks upstream failures and whether the breaker is tripped.
+//
+// The control loop is: every response observed by the middleware (or reported
+// through ReportUpstream) is recorded as a success or failure in a sliding
+// window of UpstreamWindow. When the failure rate rises above
+// UpstreamErrorThreshold the breaker trips and each admitted request costs
+// UpstreamPenalty tokens instead of one, so every visitor's effective rate
+// drops accordingly. Once the failure rate falls below half the threshold the
+// breaker resets and requests cost a single token again. The gap between the
+// two thresholds keeps the limiter from flapping around the threshold.
+type upstreamHealth struct {
+	threshold float64
+	outcomes  *denyCounter // failures are counted as denials
+	tripped   atomic.Bool
+}
+
+func newUpstreamHealth(threshold float64, window time.Duration) *upstreamHealth {
+	return &upstreamHealth{threshold: threshold, outcomes: newDenyCounter(window)}
+}
+
+// record counts one upstream outcome and updates the breaker state
+func (uh *upstreamHealth) record(now time.Time, failed bool) {
+	uh.outcomes.record(now, failed)
+
+	ok, failures := uh.outcomes.totals(now)
+	if ok+failures < upstreamMinSamples {
+		uh.tripped.Store(false)
+		return
+	}
+	failureRate := float64(failures) / float64(ok+failures)
+	switch {
+	case failureRate > uh.threshold:
+		uh.tripped.Store(true)
+	case failureRate < uh.threshold/2:
+		uh.tripped.Store(false)
+	}
+}
+
+// ReportUpstream feeds the outcome of an upstream call into the limiter's
+// upstream health tracking. The middleware reports 5xx responses on its own;
+// this is for failures observed elsewhere, such as in an HTTP client. It does
+// nothing unless UpstreamErrorThreshold is set.
+func (rl *RateLimiter) ReportUpstream(failed bool) {
+	if rl.upstream == nil {
+		return
+	}
+	rl.upstream.record(rl.now(), failed)
+}
+
+// UpstreamHealthy reports whether the upstream breaker is currently closed
+func (rl *RateLimiter) UpstreamHealthy() bool {
+	return rl.upstream == nil || !rl.upstream.tripped.Load()
+}
+
+// requestCost returns the number of tokens a request costs for the limiter,
+// taking the upstream breaker and server load into account
+func (rl *RateLimiter) requestCost(burst int) int {
+	cost := max(1, rl.LoadPenalty())
+	if !rl.UpstreamHealthy() {
+		cost = max(cost, rl.config.UpstreamPenalty)
+	}
+	return min(cost, burst)
+}
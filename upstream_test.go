@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpstreamErrorsTightenAdmission(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond:      0.001,
+		Burst:                  20,
+		UpstreamErrorThreshold: 0.5,
+		UpstreamPenalty:        5,
+		Clock:                  NewManualClock(time.Unix(0, 0)),
+	})
+	defer rl.Close()
+	failing := true
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	// 10 failures at a token each trip the breaker, leaving 10 tokens
+	for range 10 {
+		serve()
+	}
+	if rl.UpstreamHealthy() {
+		t.Fatal("upstream still healthy after 10 of 10 responses failed")
+	}
+	failing = false
+	admitted := 0
+	for serve() != http.StatusTooManyRequests {
+		admitted++
+	}
+	if admitted != 2 {
+		t.Errorf("%d requests admitted from 10 tokens at 5 each, want 2", admitted)
+	}
+}
@@ -0,0 +1,88 @@
+package ratelimiter
+
+import "time"
+
+// EvictReason says why a visitor was removed
+type EvictReason int
+
+const (
+	// EvictIdle means cleanup removed the visitor after MaxIdleTime
+	EvictIdle EvictReason = iota
+	// EvictCapacity means the visitor was the least recently seen when
+	// MaxVisitors was reached
+	EvictCapacity
+	// EvictReset means the visitor was removed by ResetVisitor or the admin
+	// reset endpoint
+	EvictReset
+)
+
+// String returns "idle", "capacity" or "reset"
+func (r EvictReason) String() string {
+	switch r {
+	case EvictIdle:
+		return "idle"
+	case EvictCapacity:
+		return "capacity"
+	case EvictReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// VisitorEvent describes a visitor as it is first tracked or removed
+type VisitorEvent struct {
+	// Key is the stored key. Routes, tiers, dimensions and the like keep
+	// their buckets under the client's key with a suffix, e.g. "|route:/api".
+	Key string
+	// LastSeen is when the visitor's latest request arrived
+	LastSeen time.Time
+	// Requests and Denials count the visitor's requests since it was first
+	// tracked
+	Requests uint64
+	Denials  uint64
+	// Reason is why the visitor was removed; it is only set for OnEvict
+	Reason EvictReason
+}
+
+// visitorHooks holds the lifecycle callbacks of a MemoryStore's visitors
+type visitorHooks struct {
+	onNew     func(VisitorEvent)
+	onEvict   func(VisitorEvent)
+	penalties *penaltyBox // nil without PenaltyBoxSize
+}
+
+// event describes visitor v of key
+func (v *visitor) event(key string) VisitorEvent {
+	return VisitorEvent{Key: key, LastSeen: v.lastSeen.Load(), Requests: v.requests.Load(), Denials: v.denials}
+}
+
+// setHooks installs the lifecycle callbacks on every shard
+func (ms *MemoryStore) setHooks(h *visitorHooks) {
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.mx.Lock()
+		shard.hooks = h
+		shard.mx.Unlock()
+	}
+}
+
+// added reports a new visitor to OnNewVisitor
+func (s *memoryShard) added(key string, v *visitor) {
+	if s.hooks != nil && s.hooks.onNew != nil {
+		s.hooks.onNew(v.event(key))
+	}
+}
+
+// evict removes a visitor, reporting it to OnEvict and keeping its record in
+// the penalty box unless it is being reset
+func (s *memoryShard) evict(key string, v *visitor, reason EvictReason) {
+	s.remove(key, v)
+	if s.hooks != nil && s.hooks.penalties != nil && reason != EvictReset {
+		s.hooks.penalties.keep(key, v)
+	}
+	if s.hooks != nil && s.hooks.onEvict != nil {
+		event := v.event(key)
+		event.Reason = reason
+		s.hooks.onEvict(event)
+	}
+}
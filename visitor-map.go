@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// visitorMap indexes the visitors of a MemoryStore shard by key. Changes are
+// made under the shard's lock. plainVisitors is the default; syncVisitors
+// also allows lookups without the lock.
+type visitorMap interface {
+	get(key string) (*visitor, bool)
+	put(key string, v *visitor)
+	del(key string)
+	len() int
+	// each calls fn for every visitor; fn may delete the one it is given
+	each(fn func(key string, v *visitor))
+	reset()
+}
+
+// plainVisitors is a plain map, read and written under the shard's lock
+type plainVisitors map[string]*visitor
+
+func (m plainVisitors) get(key string) (*visitor, bool) {
+	v, ok := m[key]
+	return v, ok
+}
+
+func (m plainVisitors) put(key string, v *visitor) { m[key] = v }
+func (m plainVisitors) del(key string)             { delete(m, key) }
+func (m plainVisitors) len() int                   { return len(m) }
+func (m plainVisitors) reset()                     { clear(m) }
+
+func (m plainVisitors) each(fn func(key string, v *visitor)) {
+	for key, v := range m {
+		fn(key, v)
+	}
+}
+
+// syncVisitors is a sync.Map, so visitors that keep coming back are found
+// without taking the shard's lock. sync.Map is optimized for keys that are
+// written once and read many times, and costs more than a map per new key.
+type syncVisitors struct {
+	m sync.Map
+	n atomic.Int64
+}
+
+func (m *syncVisitors) get(key string) (*visitor, bool) {
+	v, ok := m.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*visitor), true
+}
+
+func (m *syncVisitors) put(key string, v *visitor) {
+	if _, loaded := m.m.Swap(key, v); !loaded {
+		m.n.Add(1)
+	}
+}
+
+func (m *syncVisitors) del(key string) {
+	if _, loaded := m.m.LoadAndDelete(key); loaded {
+		m.n.Add(-1)
+	}
+}
+
+func (m *syncVisitors) len() int { return int(m.n.Load()) }
+
+func (m *syncVisitors) each(fn func(key string, v *visitor)) {
+	m.m.Range(func(key, v any) bool {
+		fn(key.(string), v.(*visitor))
+		return true
+	})
+}
+
+func (m *syncVisitors) reset() {
+	m.m.Clear()
+	m.n.Store(0)
+}
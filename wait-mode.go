@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Mode controls what happens to requests over the limit
+type Mode int
+
+const (
+	// ModeReject denies requests over the limit straight away
+	ModeReject Mode = iota
+	// ModeWait delays requests over the limit until they fit, for at most
+	// MaxWait, smoothing short bursts instead of failing them
+	ModeWait
+)
+
+// MarshalText encodes the mode as "reject" or "wait"
+func (m Mode) MarshalText() ([]byte, error) {
+	switch m {
+	case ModeReject:
+		return []byte("reject"), nil
+	case ModeWait:
+		return []byte("wait"), nil
+	}
+	return nil, fmt.Errorf("ratelimiter: unknown mode %d", int(m))
+}
+
+// UnmarshalText decodes "reject" or "wait" so modes can be written by name
+// in config files
+func (m *Mode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "reject":
+		*m = ModeReject
+	case "wait":
+		*m = ModeWait
+	default:
+		return fmt.Errorf("ratelimiter: unknown mode %q", text)
+	}
+	return nil
+}
+
+// waitTake retries a denied request for n tokens until it is allowed or
+// waiting any longer would exceed MaxWait, returning the final result. The
+// error is set if ctx ended while waiting. Nothing waits with a custom Clock.
+func (rl *RateLimiter) waitTake(ctx context.Context, key string, limiter *rate.Limiter, n int, res Result) (Result, error) {
+	if _, ok := rl.config.Clock.(realClock); !ok {
+		// Waiting in real time would make no progress on a custom clock
+		return res, nil
+	}
+	if rl.localStore() && !rl.windowed() && !rl.gcra() {
+		// Reservations queue concurrent waiters fairly
+		if res.RetryAfter > rl.config.MaxWait {
+			return res, nil
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, rl.config.MaxWait)
+		defer cancel()
+		if err := limiter.WaitN(waitCtx, n); err != nil {
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			return limiterResult(limiter, rl.now(), n, false), nil
+		}
+		return limiterResult(limiter, rl.now(), n, true), nil
+	}
+
+	deadline := rl.now().Add(rl.config.MaxWait)
+	for !res.Allowed && res.RetryAfter <= deadline.Sub(rl.now()) {
+		timer := time.NewTimer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, ctx.Err()
+		case <-timer.C:
+		}
+		res = rl.take(ctx, key, limiter, n, rl.now())
+	}
+	return res, nil
+}
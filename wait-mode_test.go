@@ -0,0 +1,34 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitModeKeepsGlobalToken(t *testing.T) {
+	rl := New(&Config{
+		RequestsPerSecond:       20,
+		Burst:                   1,
+		GlobalRequestsPerSecond: 0.001,
+		GlobalBurst:             3,
+		Mode:                    ModeWait,
+		MaxWait:                 time.Second,
+	})
+	defer rl.Close()
+	h := rl.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i := range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, w.Code)
+		}
+	}
+	// the second request waited for the per-key bucket; it must still have
+	// paid the global limit
+	if tokens := rl.global.Tokens(); tokens > 1.01 {
+		t.Errorf("global bucket holds %v tokens after two admitted requests, want 1", tokens)
+	}
+}